@@ -0,0 +1,209 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// choiceValue converts a oneOf or anyOf schema into a Struct with Choices.
+// Without either, the branches are the schemas of the discriminator mapping.
+func (c *schemaConverter) choiceValue(js *jsonSchema) (*Value, error) {
+	keyword, branches := "oneOf", js.OneOf
+	if len(branches) == 0 {
+		keyword, branches = "anyOf", js.AnyOf
+	}
+	if len(branches) == 0 {
+		keyword = "discriminator.mapping"
+		for _, key := range sortedKeys(js.Discriminator.Mapping) {
+			ref := js.Discriminator.Mapping[key]
+			if !strings.HasPrefix(ref, "#") {
+				ref = c.schemaPrefix() + ref
+			}
+			branches = append(branches, &jsonSchema{Ref: ref})
+		}
+	}
+
+	// Resolve the branches first, since the discriminator may have to be
+	// found in their properties.
+	targets := make([]*jsonSchema, len(branches))
+	for i, b := range branches {
+		targets[i] = b
+		if b.Ref != "" {
+			target, err := c.lookupRef(b.Ref)
+			if err != nil {
+				return nil, fmt.Errorf("in %s[%d]: cannot resolve $ref %q: %w", keyword, i, b.Ref, err)
+			}
+			targets[i] = target
+		}
+	}
+
+	s := &Struct{ClassName: js.ClassName, ServiceName: js.ServiceName, Choices: make(map[string]*Struct)}
+	byRef := make(map[string]string)
+	if d := js.Discriminator; d != nil {
+		s.Discriminator = d.PropertyName
+		for key, ref := range d.Mapping {
+			byRef[ref] = key
+		}
+	}
+	if s.Discriminator == "" {
+		s.Discriminator = constProperty(targets)
+	}
+	// Cache the Struct before its branches, so that a branch referring back
+	// to it, as in a tree of shapes, resolves to the same *Struct.
+	v := &Value{Kind: &Value_SingleStruct{SingleStruct: s}}
+	c.converted[js] = v
+
+	for i, b := range branches {
+		bv, err := c.toValue(b)
+		if err != nil {
+			return nil, fmt.Errorf("in %s[%d]: %w", keyword, i, err)
+		}
+		choice := bv.GetSingleStruct()
+		if choice == nil {
+			return nil, fmt.Errorf("in %s[%d]: choice must be an object schema", keyword, i)
+		}
+		if choice.ClassName == "" {
+			choice.ClassName = targets[i].Title
+		}
+		if choice.ClassName == "" && b.Ref != "" {
+			choice.ClassName = refName(b.Ref)
+		}
+
+		key := ""
+		if b.Ref != "" {
+			if k, ok := byRef[b.Ref]; ok {
+				key = k
+			} else if k, ok := byRef[refName(b.Ref)]; ok {
+				key = k
+			}
+		}
+		if key == "" && s.Discriminator != "" {
+			key = constString(targets[i].Properties[s.Discriminator])
+		}
+		if key == "" {
+			key = choice.ClassName
+		}
+		if key == "" {
+			return nil, fmt.Errorf("in %s[%d]: cannot tell the discriminator value or class name of the choice", keyword, i)
+		}
+		if _, ok := s.Choices[key]; ok {
+			return nil, fmt.Errorf("in %s[%d]: duplicate choice %q", keyword, i, key)
+		}
+		s.Choices[key] = choice
+
+		// The discriminator is data, not a field needing a class.
+		if f := choice.Fields[s.Discriminator]; f != nil && isPrimitiveLeaf(f) {
+			delete(choice.Fields, s.Discriminator)
+		}
+	}
+	return v, nil
+}
+
+// schemaPrefix returns the $ref prefix of a schema named by a bare discriminator
+// mapping value, e.g. "Dog".
+func (c *schemaConverter) schemaPrefix() string {
+	if c.components != nil {
+		return "#/components/schemas/"
+	}
+	return "#/definitions/"
+}
+
+// constProperty returns the only property that holds a const string in every
+// branch, or "" if there is none or more than one.
+func constProperty(branches []*jsonSchema) string {
+	var found string
+	for name, prop := range branches[0].Properties {
+		if constString(prop) == "" {
+			continue
+		}
+		shared := true
+		for _, b := range branches[1:] {
+			if constString(b.Properties[name]) == "" {
+				shared = false
+				break
+			}
+		}
+		if !shared {
+			continue
+		}
+		if found != "" {
+			return ""
+		}
+		found = name
+	}
+	return found
+}
+
+// constString returns the const string of js, or "".
+func constString(js *jsonSchema) string {
+	if js == nil || len(js.Const) == 0 {
+		return ""
+	}
+	var str string
+	if err := json.Unmarshal(js.Const, &str); err != nil {
+		return ""
+	}
+	return str
+}
+
+// refName returns the last segment of a $ref, e.g. "Circle" for "#/definitions/Circle".
+func refName(ref string) string {
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return pointerUnescaper.Replace(name)
+}
+
+// isPrimitiveLeaf reports whether v is the Value of a schema without a class,
+// such as {"type": "string"} or {"const": "circle"}.
+func isPrimitiveLeaf(v *Value) bool {
+	s := v.GetSingleStruct()
+	return s != nil && s.ClassName == "" && s.ServiceName == "" && len(s.Fields) == 0 && len(s.Choices) == 0
+}
+
+// choiceSchemas returns the oneOf branches of a Struct with Choices, in key order.
+// Inline branches carry the discriminator as a const property; shared branches
+// are written as $ref and listed in the discriminator mapping.
+func (e *schemaExporter) choiceSchemas(s *Struct) ([]*jsonSchema, *jsonDiscriminator, error) {
+	var d *jsonDiscriminator
+	if s.Discriminator != "" {
+		d = &jsonDiscriminator{PropertyName: s.Discriminator}
+	}
+	var branches []*jsonSchema
+	for _, key := range sortedKeys(s.Choices) {
+		choice := s.Choices[key]
+		branch, err := e.structRef(choice)
+		if err != nil {
+			return nil, nil, fmt.Errorf("choice %q: %w", key, err)
+		}
+		if branch == nil {
+			continue
+		}
+		switch {
+		case branch.Ref != "":
+			if s.Discriminator != "" || key != choice.GetClassName() {
+				if d == nil {
+					d = &jsonDiscriminator{}
+				}
+				if d.Mapping == nil {
+					d.Mapping = make(map[string]string)
+				}
+				d.Mapping[key] = branch.Ref
+			}
+		case s.Discriminator != "":
+			if branch.Properties == nil {
+				branch.Properties = make(map[string]*jsonSchema)
+			}
+			raw, err := json.Marshal(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			branch.Properties[s.Discriminator] = &jsonSchema{Const: raw}
+		}
+		branches = append(branches, branch)
+	}
+	return branches, d, nil
+}
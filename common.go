@@ -305,6 +305,11 @@ func validateServiceEndStructWithSeen(s *Struct, seen map[*Struct]struct{}, path
 			return err
 		}
 	}
+	for key, choice := range s.Choices {
+		if err := validateServiceEndStructWithSeen(choice, seen, fmt.Sprintf("%s(%s)", path, key)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -664,6 +669,59 @@ func newEndMap2Struct(v map[[2]string][]string) (*Map2Struct, error) {
 	return &Map2Struct{Map2Fields: x}, nil
 }
 
+// --- Lookup helpers ---
+
+// StructAt returns the Struct describing the list element at index i.
+// A ListStruct with a single entry describes every element; otherwise
+// entries are positional. Returns nil if no entry applies.
+func (x *ListStruct) StructAt(i int) *Struct {
+	if x == nil || i < 0 {
+		return nil
+	}
+	if len(x.ListFields) == 1 {
+		return x.ListFields[0]
+	}
+	if i < len(x.ListFields) {
+		return x.ListFields[i]
+	}
+	return nil
+}
+
+// StructFor returns the Struct describing the map entry with the given key,
+// falling back to the "*" wildcard entry. Returns nil if no entry applies.
+func (x *MapStruct) StructFor(key string) *Struct {
+	if x == nil {
+		return nil
+	}
+	if s, ok := x.MapFields[key]; ok {
+		return s
+	}
+	return x.MapFields["*"]
+}
+
+// StructFor returns the Struct describing the two-level map entry [key1, key2],
+// falling back to "*" wildcard entries on either level. Returns nil if no entry applies.
+func (x *Map2Struct) StructFor(key1, key2 string) *Struct {
+	if x == nil {
+		return nil
+	}
+	if ms, ok := x.Map2Fields[key1]; ok {
+		if s := ms.StructFor(key2); s != nil {
+			return s
+		}
+	}
+	return x.Map2Fields["*"].StructFor(key2)
+}
+
+// ChoiceFor returns the Struct chosen by the discriminator value key, or nil
+// if the Struct has no such choice.
+func (x *Struct) ChoiceFor(key string) *Struct {
+	if x == nil {
+		return nil
+	}
+	return x.Choices[key]
+}
+
 // --- Compatibility aliases ---
 
 // GetObjectName returns ClassName (alias for backwards compatibility with grand/spec).
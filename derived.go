@@ -8,7 +8,8 @@ package schema
 //   - Clears the ServiceName field at the root level
 //   - Recursively processes all nested Fields to clear ServiceName in nested Structs
 //   - Handles all Value types: SingleStruct, ListStruct, MapStruct, and Map2Struct
-//   - Returns a deep copy with only ClassName and Fields preserved (ServiceNames removed)
+//   - Returns a deep copy with only ClassName, Fields, Discriminator and Choices preserved (ServiceNames removed)
+//   - Copies each Struct once, so shared and cyclic Structs stay shared and cyclic
 //
 // Example:
 //
@@ -20,21 +21,37 @@ package schema
 //	// newStruct will have ClassName="provider" with empty ServiceName
 //	// Nested Database and Cache will also have their ServiceNames cleared
 func DeriveStructWithoutServices(old *Struct) *Struct {
+	return deriveStructWithoutServices(old, make(map[*Struct]*Struct))
+}
+
+// deriveStructWithoutServices copies old, reusing the copy of a Struct already in copies.
+func deriveStructWithoutServices(old *Struct, copies map[*Struct]*Struct) *Struct {
 	if old == nil {
 		return nil
 	}
+	if newStruct, ok := copies[old]; ok {
+		return newStruct
+	}
 
 	// Create new struct with ClassName but empty ServiceName
 	newStruct := &Struct{
 		ClassName: old.ClassName,
 		// ServiceName is intentionally left empty
+		Discriminator: old.Discriminator,
 	}
+	copies[old] = newStruct
 
 	// Recursively process all fields
 	if old.Fields != nil {
 		newStruct.Fields = make(map[string]*Value, len(old.Fields))
 		for key, value := range old.Fields {
-			newStruct.Fields[key] = deriveValueWithoutServices(value)
+			newStruct.Fields[key] = deriveValueWithoutServices(value, copies)
+		}
+	}
+	if old.Choices != nil {
+		newStruct.Choices = make(map[string]*Struct, len(old.Choices))
+		for key, choice := range old.Choices {
+			newStruct.Choices[key] = deriveStructWithoutServices(choice, copies)
 		}
 	}
 
@@ -43,7 +60,7 @@ func DeriveStructWithoutServices(old *Struct) *Struct {
 
 // deriveValueWithoutServices recursively processes a Value and clears all ServiceNames
 // from any nested Structs it contains.
-func deriveValueWithoutServices(old *Value) *Value {
+func deriveValueWithoutServices(old *Value, copies map[*Struct]*Struct) *Value {
 	if old == nil {
 		return nil
 	}
@@ -53,22 +70,22 @@ func deriveValueWithoutServices(old *Value) *Value {
 	switch kind := old.Kind.(type) {
 	case *Value_SingleStruct:
 		newValue.Kind = &Value_SingleStruct{
-			SingleStruct: DeriveStructWithoutServices(kind.SingleStruct),
+			SingleStruct: deriveStructWithoutServices(kind.SingleStruct, copies),
 		}
 
 	case *Value_ListStruct:
 		newValue.Kind = &Value_ListStruct{
-			ListStruct: deriveListStructWithoutServices(kind.ListStruct),
+			ListStruct: deriveListStructWithoutServices(kind.ListStruct, copies),
 		}
 
 	case *Value_MapStruct:
 		newValue.Kind = &Value_MapStruct{
-			MapStruct: deriveMapStructWithoutServices(kind.MapStruct),
+			MapStruct: deriveMapStructWithoutServices(kind.MapStruct, copies),
 		}
 
 	case *Value_Map2Struct:
 		newValue.Kind = &Value_Map2Struct{
-			Map2Struct: deriveMap2StructWithoutServices(kind.Map2Struct),
+			Map2Struct: deriveMap2StructWithoutServices(kind.Map2Struct, copies),
 		}
 	}
 
@@ -76,7 +93,7 @@ func deriveValueWithoutServices(old *Value) *Value {
 }
 
 // deriveListStructWithoutServices processes a ListStruct and clears ServiceNames from all Structs.
-func deriveListStructWithoutServices(old *ListStruct) *ListStruct {
+func deriveListStructWithoutServices(old *ListStruct, copies map[*Struct]*Struct) *ListStruct {
 	if old == nil {
 		return nil
 	}
@@ -86,14 +103,14 @@ func deriveListStructWithoutServices(old *ListStruct) *ListStruct {
 	}
 
 	for i, s := range old.ListFields {
-		newList.ListFields[i] = DeriveStructWithoutServices(s)
+		newList.ListFields[i] = deriveStructWithoutServices(s, copies)
 	}
 
 	return newList
 }
 
 // deriveMapStructWithoutServices processes a MapStruct and clears ServiceNames from all Structs.
-func deriveMapStructWithoutServices(old *MapStruct) *MapStruct {
+func deriveMapStructWithoutServices(old *MapStruct, copies map[*Struct]*Struct) *MapStruct {
 	if old == nil {
 		return nil
 	}
@@ -103,14 +120,14 @@ func deriveMapStructWithoutServices(old *MapStruct) *MapStruct {
 	}
 
 	for key, s := range old.MapFields {
-		newMap.MapFields[key] = DeriveStructWithoutServices(s)
+		newMap.MapFields[key] = deriveStructWithoutServices(s, copies)
 	}
 
 	return newMap
 }
 
 // deriveMap2StructWithoutServices processes a Map2Struct and clears ServiceNames from all Structs.
-func deriveMap2StructWithoutServices(old *Map2Struct) *Map2Struct {
+func deriveMap2StructWithoutServices(old *Map2Struct, copies map[*Struct]*Struct) *Map2Struct {
 	if old == nil {
 		return nil
 	}
@@ -120,7 +137,7 @@ func deriveMap2StructWithoutServices(old *Map2Struct) *Map2Struct {
 	}
 
 	for key, ms := range old.Map2Fields {
-		newMap2.Map2Fields[key] = deriveMapStructWithoutServices(ms)
+		newMap2.Map2Fields[key] = deriveMapStructWithoutServices(ms, copies)
 	}
 
 	return newMap2
@@ -306,3 +306,50 @@ func TestDeriveStructWithoutServices_DeeplyNested(t *testing.T) {
 		t.Errorf("expected End ClassName 'EndClass', got '%s'", end.ClassName)
 	}
 }
+
+func TestDeriveStructWithoutServices_Cyclic(t *testing.T) {
+	tree := newCyclicTree()
+	derived := DeriveStructWithoutServices(tree)
+
+	node := derived.Fields["Root"].GetSingleStruct()
+	if node.Fields["Owner"].GetSingleStruct() != derived {
+		t.Error("cycle back to the root should point to the copy of the root")
+	}
+	if node.Fields["Children"].GetListStruct().ListFields[0] != node {
+		t.Error("self reference should point to the same copy")
+	}
+	leaf := node.Fields["Leaf"].GetSingleStruct()
+	if leaf.ServiceName != "" || leaf != derived.Fields["Index"].GetMapStruct().MapFields["*"] {
+		t.Errorf("shared leaf should be copied once without its service, got %v", leaf)
+	}
+	if tree.Fields["Index"].GetMapStruct().MapFields["*"].ServiceName != "leafService" {
+		t.Error("original should be unchanged")
+	}
+}
+
+func TestDeriveStructWithoutServices_Choices(t *testing.T) {
+	old := &Struct{
+		ClassName: "Config",
+		Fields: map[string]*Value{
+			"Database": {Kind: &Value_SingleStruct{SingleStruct: &Struct{
+				Discriminator: "engine",
+				Choices: map[string]*Struct{
+					"pg":    {ClassName: "Postgres", ServiceName: "pgService"},
+					"mysql": {ClassName: "MySQL"},
+				},
+			}}},
+		},
+	}
+	derived := DeriveStructWithoutServices(old)
+
+	db := derived.Fields["Database"].GetSingleStruct()
+	if db.Discriminator != "engine" || len(db.Choices) != 2 {
+		t.Fatalf("choices not copied: %v", db)
+	}
+	if pg := db.ChoiceFor("pg"); pg.ClassName != "Postgres" || pg.ServiceName != "" {
+		t.Errorf("choice pg should keep its class without its service, got %v", pg)
+	}
+	if old.Fields["Database"].GetSingleStruct().ChoiceFor("pg").ServiceName != "pgService" {
+		t.Error("original should be unchanged")
+	}
+}
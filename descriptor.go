@@ -0,0 +1,251 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// anyFullName is the message google.protobuf.Any, whose type is chosen at runtime.
+const anyFullName protoreflect.FullName = "google.protobuf.Any"
+
+// DescriptorOptions configures StructFromDescriptor.
+type DescriptorOptions struct {
+	// ServiceOption is a custom string option holding service names. Declared
+	// on google.protobuf.MessageOptions, it names the service of every use of a
+	// message; declared on google.protobuf.FieldOptions, the service of the
+	// message held by a field, taking precedence over the message option:
+	//
+	//	extend google.protobuf.FieldOptions { string service = 50001; }
+	//	message Config { Database database = 1 [(service) = "dbService"]; }
+	ServiceOption protoreflect.ExtensionType
+}
+
+// StructFromDescriptor derives a Struct from a protobuf message descriptor, so
+// that specs of gRPC messages do not drift from their .proto files.
+//
+//	╔═════════════════════════════════════════╤══════════════════════════════════════╗
+//	║ Protobuf field                          │ Value                                ║
+//	╠═════════════════════════════════════════╪══════════════════════════════════════╣
+//	║ Msg m                                   │ SingleStruct with ClassName Msg      ║
+//	║ repeated Msg m                          │ ListStruct with one entry            ║
+//	║ map<K, Msg> m                           │ MapStruct with key "*"               ║
+//	║ oneof o { A a; B b; }                   │ Field o: Struct with Choices a and b ║
+//	║ google.protobuf.Any m                   │ SingleStruct to be assigned a class  ║
+//	║ scalars, enums, other well-known types  │ left out, as data                    ║
+//	╚═════════════════════════════════════════╧══════════════════════════════════════╝
+//
+// Class names are full message names, e.g. "shapes.v1.Circle", as in the type URL
+// of an Any. Fields are keyed by their proto names. Every use of a message shares
+// one *Struct, so recursive messages yield cyclic Structs. Choices are keyed by the
+// name of the oneof member and have no Discriminator; members that are not
+// messages are left out.
+//
+// Any fields get a Struct with an empty ClassName; their paths, e.g.
+// `shapes.v1.Drawing.layers[0]`, are returned sorted as the places to fill in.
+// As with NewServiceStruct, a service name must be on a leaf message.
+func StructFromDescriptor(md protoreflect.MessageDescriptor, opts *DescriptorOptions) (*Struct, []string, error) {
+	if md == nil {
+		return nil, nil, fmt.Errorf("StructFromDescriptor: nil descriptor")
+	}
+	w := &descriptorWalker{structs: make(map[protoreflect.FullName]*Struct)}
+	if opts != nil {
+		w.serviceOption = opts.ServiceOption
+	}
+	s, err := w.message(md)
+	if err == nil {
+		for _, fixup := range w.fixups {
+			fixup()
+		}
+		err = validateServiceEndStruct(s)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("StructFromDescriptor: %w", err)
+	}
+	sort.Strings(w.unresolved)
+	return s, w.unresolved, nil
+}
+
+type descriptorWalker struct {
+	serviceOption protoreflect.ExtensionType
+	// structs holds the Struct of every message walked or being walked.
+	structs map[protoreflect.FullName]*Struct
+	// fixups run once the walk is complete.
+	fixups     []func()
+	unresolved []string
+}
+
+// message returns the shared Struct of md.
+func (w *descriptorWalker) message(md protoreflect.MessageDescriptor) (*Struct, error) {
+	if s, ok := w.structs[md.FullName()]; ok {
+		return s, nil
+	}
+	service, err := w.service(md.Options())
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", md.FullName(), err)
+	}
+	s := &Struct{ClassName: string(md.FullName()), ServiceName: service}
+	w.structs[md.FullName()] = s
+
+	path := string(md.FullName())
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			continue
+		}
+		v, err := w.field(fd, path+"."+string(fd.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			if s.Fields == nil {
+				s.Fields = make(map[string]*Value)
+			}
+			s.Fields[string(fd.Name())] = v
+		}
+	}
+
+	oneofs := md.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		od := oneofs.Get(i)
+		if od.IsSynthetic() {
+			continue
+		}
+		choices := make(map[string]*Struct)
+		members := od.Fields()
+		for j := 0; j < members.Len(); j++ {
+			fd := members.Get(j)
+			choice, err := w.fieldStruct(fd, fmt.Sprintf("%s.%s(%s)", path, od.Name(), fd.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if choice != nil {
+				choices[string(fd.Name())] = choice
+			}
+		}
+		if len(choices) == 0 {
+			continue
+		}
+		if s.Fields == nil {
+			s.Fields = make(map[string]*Value)
+		}
+		s.Fields[string(od.Name())] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{Choices: choices}}}
+	}
+	return s, nil
+}
+
+// field returns the Value of field fd, or nil if it holds data only.
+func (w *descriptorWalker) field(fd protoreflect.FieldDescriptor, path string) (*Value, error) {
+	switch {
+	case fd.IsMap():
+		elem, err := w.fieldStruct(fd.MapValue(), path+`["*"]`)
+		if err != nil || elem == nil {
+			return nil, err
+		}
+		if service, err := w.service(fd.Options()); err != nil {
+			return nil, atPath(path, err)
+		} else if service != "" {
+			elem = w.withService(elem, service)
+		}
+		return &Value{Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"*": elem}}}}, nil
+
+	case fd.IsList():
+		elem, err := w.fieldStruct(fd, path+"[0]")
+		if err != nil || elem == nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{elem}}}}, nil
+
+	default:
+		s, err := w.fieldStruct(fd, path)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_SingleStruct{SingleStruct: s}}, nil
+	}
+}
+
+// fieldStruct returns the Struct of a single value of field fd, or nil if the
+// field is not of a message type or is of a well-known type holding data.
+func (w *descriptorWalker) fieldStruct(fd protoreflect.FieldDescriptor, path string) (*Struct, error) {
+	md := fd.Message()
+	if md == nil {
+		return nil, nil
+	}
+	service, err := w.service(fd.Options())
+	if err != nil {
+		return nil, atPath(path, err)
+	}
+	if md.FullName() == anyFullName {
+		w.unresolved = append(w.unresolved, path)
+		return &Struct{ServiceName: service}, nil
+	}
+	if strings.HasPrefix(string(md.FullName()), "google.protobuf.") {
+		return nil, nil
+	}
+	s, err := w.message(md)
+	if err != nil {
+		return nil, err
+	}
+	if service != "" {
+		s = w.withService(s, service)
+	}
+	return s, nil
+}
+
+// withService returns a copy of s with the given service name. The Fields of
+// s may be incomplete while s is being walked, so they are copied at the end.
+func (w *descriptorWalker) withService(s *Struct, service string) *Struct {
+	if s.ServiceName == service {
+		return s
+	}
+	leaf := &Struct{ClassName: s.ClassName, ServiceName: service}
+	w.fixups = append(w.fixups, func() {
+		leaf.Fields = s.Fields
+		leaf.Discriminator = s.Discriminator
+		leaf.Choices = s.Choices
+	})
+	return leaf
+}
+
+// service returns the service name set by the ServiceOption in opts, or "".
+func (w *descriptorWalker) service(opts proto.Message) (string, error) {
+	xt := w.serviceOption
+	if xt == nil || opts == nil {
+		return "", nil
+	}
+	xd := xt.TypeDescriptor()
+	m := opts.ProtoReflect()
+	if xd.ContainingMessage().FullName() != m.Descriptor().FullName() {
+		return "", nil
+	}
+	if xd.Kind() != protoreflect.StringKind || xd.IsList() {
+		return "", fmt.Errorf("service option %s must be a string", xd.FullName())
+	}
+	if !m.Has(xd) && len(m.GetUnknown()) > 0 {
+		// The options were parsed without the extension, which is then
+		// among the unknown fields.
+		data, err := proto.Marshal(opts)
+		if err != nil {
+			return "", err
+		}
+		types := new(protoregistry.Types)
+		if err := types.RegisterExtension(xt); err != nil {
+			return "", err
+		}
+		resolved := m.Type().New().Interface()
+		if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(data, resolved); err != nil {
+			return "", err
+		}
+		m = resolved.ProtoReflect()
+	}
+	if !m.Has(xd) {
+		return "", nil
+	}
+	return m.Get(xd).String(), nil
+}
@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newShapesFile builds, without generated code, the descriptors of:
+//
+//	extend google.protobuf.FieldOptions { string service = 50001; }
+//	extend google.protobuf.MessageOptions { string message_service = 50002; }
+//
+//	message Point { option (message_service) = "pointService"; int32 x = 1; }
+//	message Circle { Point center = 1; }
+//	message Square {}
+//	message Drawing {
+//	    string title = 1;
+//	    Point origin = 2;
+//	    repeated google.protobuf.Any layers = 3;
+//	    map<string, Circle> circles = 4;
+//	    oneof shape { Circle circle = 5; Square square = 6 [(service) = "squareService"]; string name = 7; }
+//	    google.protobuf.Timestamp created = 8;
+//	    Drawing parent = 9;
+//	    optional Square extra = 10;
+//	}
+func newShapesFile(t *testing.T, edit func(*descriptorpb.FileDescriptorProto, protoreflect.ExtensionType)) (protoreflect.FileDescriptor, protoreflect.ExtensionType, protoreflect.ExtensionType) {
+	t.Helper()
+	files := new(protoregistry.Files)
+	for _, path := range []string{"google/protobuf/descriptor.proto", "google/protobuf/any.proto", "google/protobuf/timestamp.proto"} {
+		fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := files.RegisterFile(fd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	msg := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	extFile, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("opts.proto"),
+		Package:    proto.String("opts"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			{Name: proto.String("service"), Number: proto.Int32(50001), Type: str, Label: optional, Extendee: proto.String(".google.protobuf.FieldOptions")},
+			{Name: proto.String("message_service"), Number: proto.Int32(50002), Type: str, Label: optional, Extendee: proto.String(".google.protobuf.MessageOptions")},
+		},
+	}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files.RegisterFile(extFile); err != nil {
+		t.Fatal(err)
+	}
+	fieldService := dynamicpb.NewExtensionType(extFile.Extensions().ByName("service"))
+	messageService := dynamicpb.NewExtensionType(extFile.Extensions().ByName("message_service"))
+
+	pointOptions := &descriptorpb.MessageOptions{}
+	proto.SetExtension(pointOptions, messageService, "pointService")
+	squareOptions := &descriptorpb.FieldOptions{}
+	proto.SetExtension(squareOptions, fieldService, "squareService")
+
+	field := func(name string, number int32, typ *descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ, Label: optional}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	layers := field("layers", 3, msg, ".google.protobuf.Any")
+	layers.Label = repeated
+	circles := field("circles", 4, msg, ".shapes.v1.Drawing.CirclesEntry")
+	circles.Label = repeated
+	circle := field("circle", 5, msg, ".shapes.v1.Circle")
+	square := field("square", 6, msg, ".shapes.v1.Square")
+	square.Options = squareOptions
+	name := field("name", 7, str, "")
+	for _, f := range []*descriptorpb.FieldDescriptorProto{circle, square, name} {
+		f.OneofIndex = proto.Int32(0)
+	}
+	extra := field("extra", 10, msg, ".shapes.v1.Square")
+	extra.OneofIndex = proto.Int32(1)
+	extra.Proto3Optional = proto.Bool(true)
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("shapes.proto"),
+		Package:    proto.String("shapes.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"opts.proto", "google/protobuf/any.proto", "google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Point"), Options: pointOptions, Field: []*descriptorpb.FieldDescriptorProto{
+				field("x", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), ""),
+			}},
+			{Name: proto.String("Circle"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("center", 1, msg, ".shapes.v1.Point"),
+			}},
+			{Name: proto.String("Square")},
+			{
+				Name: proto.String("Drawing"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("title", 1, str, ""),
+					field("origin", 2, msg, ".shapes.v1.Point"),
+					layers, circles, circle, square, name,
+					field("created", 8, msg, ".google.protobuf.Timestamp"),
+					field("parent", 9, msg, ".shapes.v1.Drawing"),
+					extra,
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name:    proto.String("CirclesEntry"),
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, str, ""),
+						field("value", 2, msg, ".shapes.v1.Circle"),
+					},
+				}},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("shape")}, {Name: proto.String("_extra")}},
+			},
+		},
+	}
+	if edit != nil {
+		edit(fdp, fieldService)
+	}
+	file, err := protodesc.NewFile(fdp, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, fieldService, messageService
+}
+
+func TestStructFromDescriptor(t *testing.T) {
+	file, fieldService, messageService := newShapesFile(t, nil)
+	drawing := file.Messages().ByName("Drawing")
+
+	spec, unresolved, err := StructFromDescriptor(drawing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(unresolved, ",") != "shapes.v1.Drawing.layers[0]" {
+		t.Errorf("unresolved = %v", unresolved)
+	}
+	text, err := spec.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `shapes.v1.Drawing&shapes.v1.Drawing{` +
+		`circles: {*: shapes.v1.Circle&shapes.v1.Circle{center: shapes.v1.Point&shapes.v1.Point}}, ` +
+		`extra: shapes.v1.Square&shapes.v1.Square, layers: [""], origin: *shapes.v1.Point, parent: *shapes.v1.Drawing, ` +
+		`shape: <>(circle: *shapes.v1.Circle, square: *shapes.v1.Square)}`
+	if string(text) != want {
+		t.Errorf("got  %s\nwant %s", text, want)
+	}
+
+	for _, xt := range []protoreflect.ExtensionType{fieldService, messageService} {
+		spec, _, err := StructFromDescriptor(drawing, &DescriptorOptions{ServiceOption: xt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := spec.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range map[protoreflect.ExtensionType][]string{
+			fieldService:   {`square: shapes.v1.Square@squareService`, `extra: shapes.v1.Square,`},
+			messageService: {`center: shapes.v1.Point@pointService&shapes.v1.Point`, `origin: *shapes.v1.Point`},
+		}[xt] {
+			if !strings.Contains(string(text), want) {
+				t.Errorf("%s\nshould contain %s", text, want)
+			}
+		}
+	}
+}
+
+func TestStructFromDescriptor_ServiceOnParent(t *testing.T) {
+	// A service on a message with nested classes is rejected, as in NewServiceStruct.
+	file, fieldService, _ := newShapesFile(t, func(fdp *descriptorpb.FileDescriptorProto, fieldService protoreflect.ExtensionType) {
+		options := &descriptorpb.FieldOptions{}
+		proto.SetExtension(options, fieldService, "circleService")
+		for _, f := range fdp.MessageType[3].Field {
+			if f.GetName() == "circle" {
+				f.Options = options
+			}
+		}
+	})
+	_, _, err := StructFromDescriptor(file.Messages().ByName("Drawing"), &DescriptorOptions{ServiceOption: fieldService})
+	want := "StructFromDescriptor: service name must be on leaf struct at shapes.v1.Drawing.shape(circle)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,772 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseSpec parses a spec written in the text notation printed by
+// Struct.MarshalText:
+//
+//	Config{
+//	    Database: PostgresDB@dbService,
+//	    Servers: [HTTPServer, GRPCServer],
+//	    Handlers: {api: APIHandler, *: WebHandler},
+//	    Grid: {{r1, k1}: Cell},
+//	}
+//
+// Notation:
+//
+//	╔══════════════════════════════════════════════╤══════════════════════════════════════════╗
+//	║ Text                                         │ Meaning                                  ║
+//	╠══════════════════════════════════════════════╪══════════════════════════════════════════╣
+//	║ Circle                                       │ Struct with ClassName "Circle"           ║
+//	║ Circle@s1                                    │ ... and ServiceName "s1"                 ║
+//	║ Class1{Field1: Circle, Field2: ...}          │ ... with Fields                          ║
+//	║ [HTTPServer, GRPCServer]                     │ ListStruct                               ║
+//	║ {api: APIHandler, *: WebHandler}             │ MapStruct, {} when empty                 ║
+//	║ {{r1, k1}: Cell, {*, *}: Cell}               │ Map2Struct, {{}} when empty              ║
+//	║ {{r1}: {}}                                   │ ... with an empty row r1                 ║
+//	║ [[Cell]], {*: [Cell]}                        │ entries that are lists or maps           ║
+//	║ Shape<kind>(circle: Circle, square: Square)  │ Choices keyed by the Discriminator value ║
+//	║ <>(HTTPServer: HTTPServer, grpc: GRPCServer) │ Choices without a Discriminator          ║
+//	║ Node&n{Children: [*n]}                       │ label n on a Struct, and a reference     ║
+//	║ "x/y z"                                      │ a name that is not an identifier         ║
+//	╚══════════════════════════════════════════════╧══════════════════════════════════════════╝
+//
+// A label refers to the same *Struct wherever it is referenced, so shared and
+// cyclic graphs can be written; it must be defined before it is referenced.
+// The class name of the top-level Struct may be left out, as in {A: X}, and so
+// may "" before @, & or <. Identifiers consist of letters, digits, '_', '.'
+// and '-'; other names are Go string literals. Commas may trail, and // starts
+// a comment running to the end of the line.
+func ParseSpec(text string) (*Struct, error) {
+	tokens, err := scanSpec(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &specParser{tokens: tokens, labels: make(map[string]*Struct)}
+	s, err := p.structTerm(true)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s after the spec", t)
+	}
+	return s, nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. It prints the
+// Struct in the notation of ParseSpec, on one line, with fields, map keys and
+// choices in sorted order. A Struct referenced more than once is labelled at
+// its first occurrence, after its class name, and referenced afterwards.
+func (s *Struct) MarshalText() ([]byte, error) {
+	p := &specPrinter{
+		root:    s,
+		refs:    make(map[*Struct]int),
+		labels:  make(map[*Struct]string),
+		printed: make(map[*Struct]bool),
+	}
+	p.count(s)
+	p.label(s)
+	if err := p.structTerm(s); err != nil {
+		return nil, err
+	}
+	return []byte(p.b.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for the
+// notation of ParseSpec.
+func (s *Struct) UnmarshalText(text []byte) error {
+	parsed, err := ParseSpec(string(text))
+	if err != nil {
+		return err
+	}
+	s.ClassName = parsed.ClassName
+	s.ServiceName = parsed.ServiceName
+	s.Fields = parsed.Fields
+	s.Discriminator = parsed.Discriminator
+	s.Choices = parsed.Choices
+	// A label on the top-level Struct must refer to s itself.
+	relinkStruct(s, parsed, s)
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenPunct
+)
+
+type specToken struct {
+	kind tokenKind
+	// text is the name, unquoted, or the punctuation character.
+	text      string
+	quoted    bool
+	line, col int
+}
+
+func (t specToken) String() string {
+	switch {
+	case t.kind == tokenEOF:
+		return "end of input"
+	case t.quoted:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+func isSpecIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-'
+}
+
+// scanSpec splits text into names and punctuation.
+func scanSpec(text string) ([]specToken, error) {
+	var tokens []specToken
+	line, col := 1, 1
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line, col = line+1, 1
+			i++
+		case unicode.IsSpace(r):
+			col++
+			i++
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case strings.ContainsRune("{}[]()<>:,@&*", r):
+			tokens = append(tokens, specToken{kind: tokenPunct, text: string(r), line: line, col: col})
+			col++
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' && runes[j] != '\n' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) || runes[j] != '"' {
+				return nil, fmt.Errorf("line %d, column %d: unterminated string", line, col)
+			}
+			name, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %d: invalid string %s", line, col, string(runes[i:j+1]))
+			}
+			tokens = append(tokens, specToken{kind: tokenName, text: name, quoted: true, line: line, col: col})
+			col += j + 1 - i
+			i = j + 1
+		case isSpecIdentRune(r):
+			j := i
+			for j < len(runes) && isSpecIdentRune(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, specToken{kind: tokenName, text: string(runes[i:j]), line: line, col: col})
+			col += j - i
+			i = j
+		default:
+			return nil, fmt.Errorf("line %d, column %d: unexpected character %q", line, col, r)
+		}
+	}
+	return append(tokens, specToken{kind: tokenEOF, line: line, col: col}), nil
+}
+
+// specParser is a recursive descent parser over the tokens of a spec.
+type specParser struct {
+	tokens []specToken
+	pos    int
+	// labels holds the Struct of every label defined so far.
+	labels map[string]*Struct
+}
+
+func (p *specParser) peek() specToken {
+	return p.tokens[p.pos]
+}
+
+func (p *specParser) next() specToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the punctuation c.
+func (p *specParser) is(c string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.text == c
+}
+
+func (p *specParser) errorf(t specToken, format string, args ...any) error {
+	return fmt.Errorf("line %d, column %d: %s", t.line, t.col, fmt.Sprintf(format, args...))
+}
+
+func (p *specParser) expect(c string) error {
+	if t := p.next(); t.kind != tokenPunct || t.text != c {
+		return p.errorf(t, "expected '%s', found %s", c, t)
+	}
+	return nil
+}
+
+func (p *specParser) name(what string) (string, error) {
+	t := p.next()
+	if t.kind != tokenName {
+		return "", p.errorf(t, "expected %s, found %s", what, t)
+	}
+	return t.text, nil
+}
+
+// key reads a map key: a name or '*'.
+func (p *specParser) key() (string, error) {
+	if p.is("*") {
+		p.next()
+		return "*", nil
+	}
+	return p.name("a key")
+}
+
+// list reads items separated by commas up to the closing punctuation end,
+// allowing a trailing comma.
+func (p *specParser) list(end string, item func() error) error {
+	for !p.is(end) {
+		if err := item(); err != nil {
+			return err
+		}
+		if !p.is(end) {
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+	}
+	p.next()
+	return nil
+}
+
+// structTerm reads a Struct: class name, service, label, choices and fields,
+// each optional. Only the top-level Struct may start with its fields, since
+// elsewhere '{' starts a map.
+func (p *specParser) structTerm(top bool) (*Struct, error) {
+	start := p.peek()
+	s := &Struct{}
+	if start.kind == tokenName {
+		s.ClassName = p.next().text
+	} else if !p.is("@") && !p.is("&") && !p.is("<") && !(top && p.is("{")) {
+		return nil, p.errorf(start, "expected a class name, found %s", start)
+	}
+	if p.is("@") {
+		p.next()
+		name, err := p.name("a service name")
+		if err != nil {
+			return nil, err
+		}
+		s.ServiceName = name
+	}
+	if p.is("&") {
+		p.next()
+		t := p.peek()
+		label, err := p.name("a label")
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.labels[label]; ok {
+			return nil, p.errorf(t, "label %q defined twice", label)
+		}
+		p.labels[label] = s
+	}
+	if p.is("<") {
+		if err := p.choices(s); err != nil {
+			return nil, err
+		}
+	}
+	if p.is("{") {
+		if err := p.fields(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// structRef reads a Struct or a reference *label to one.
+func (p *specParser) structRef() (*Struct, error) {
+	if !p.is("*") {
+		return p.structTerm(false)
+	}
+	p.next()
+	t := p.peek()
+	label, err := p.name("a label")
+	if err != nil {
+		return nil, err
+	}
+	s, ok := p.labels[label]
+	if !ok {
+		return nil, p.errorf(t, "undefined label %q", label)
+	}
+	return s, nil
+}
+
+func (p *specParser) choices(s *Struct) error {
+	p.next() // <
+	if !p.is(">") {
+		name, err := p.name("a discriminator")
+		if err != nil {
+			return err
+		}
+		s.Discriminator = name
+	}
+	if err := p.expect(">"); err != nil {
+		return err
+	}
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	s.Choices = make(map[string]*Struct)
+	return p.list(")", func() error {
+		t := p.peek()
+		key, err := p.name("a choice key")
+		if err != nil {
+			return err
+		}
+		if _, ok := s.Choices[key]; ok {
+			return p.errorf(t, "duplicate choice %q", key)
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		choice, err := p.structRef()
+		if err != nil {
+			return err
+		}
+		s.Choices[key] = choice
+		return nil
+	})
+}
+
+func (p *specParser) fields(s *Struct) error {
+	p.next() // {
+	s.Fields = make(map[string]*Value)
+	return p.list("}", func() error {
+		t := p.peek()
+		name, err := p.name("a field name")
+		if err != nil {
+			return err
+		}
+		if _, ok := s.Fields[name]; ok {
+			return p.errorf(t, "duplicate field %q", name)
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		v, err := p.value()
+		if err != nil {
+			return err
+		}
+		s.Fields[name] = v
+		return nil
+	})
+}
+
+// entry reads an entry of a list or map. An entry that is itself a list or map
+// is wrapped as a Struct, as in Struct.UnmarshalJSON.
+func (p *specParser) entry() (*Struct, error) {
+	if !p.is("[") && !p.is("{") {
+		return p.structRef()
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return wrapValueAsStruct(v), nil
+}
+
+// value reads a field value: a list, a map or a Struct.
+func (p *specParser) value() (*Value, error) {
+	switch {
+	case p.is("["):
+		p.next()
+		ls := &ListStruct{ListFields: []*Struct{}}
+		err := p.list("]", func() error {
+			item, err := p.entry()
+			if err != nil {
+				return err
+			}
+			ls.ListFields = append(ls.ListFields, item)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_ListStruct{ListStruct: ls}}, nil
+
+	case p.is("{"):
+		p.next()
+		if p.is("{") {
+			return p.map2()
+		}
+		ms := &MapStruct{MapFields: make(map[string]*Struct)}
+		err := p.list("}", func() error {
+			t := p.peek()
+			key, err := p.key()
+			if err != nil {
+				return err
+			}
+			if _, ok := ms.MapFields[key]; ok {
+				return p.errorf(t, "duplicate key %q", key)
+			}
+			if err := p.expect(":"); err != nil {
+				return err
+			}
+			target, err := p.entry()
+			if err != nil {
+				return err
+			}
+			ms.MapFields[key] = target
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_MapStruct{MapStruct: ms}}, nil
+
+	default:
+		s, err := p.structRef()
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_SingleStruct{SingleStruct: s}}, nil
+	}
+}
+
+// map2 reads the entries of a two-level map after its opening '{'.
+func (p *specParser) map2() (*Value, error) {
+	m2 := &Map2Struct{Map2Fields: make(map[string]*MapStruct)}
+	value := &Value{Kind: &Value_Map2Struct{Map2Struct: m2}}
+	if p.tokens[p.pos+1].kind == tokenPunct && p.tokens[p.pos+1].text == "}" {
+		// {{}} is the empty two-level map.
+		p.next()
+		p.next()
+		return value, p.expect("}")
+	}
+	err := p.list("}", func() error {
+		t := p.peek()
+		if err := p.expect("{"); err != nil {
+			return err
+		}
+		key1, err := p.key()
+		if err != nil {
+			return err
+		}
+		if p.is("}") {
+			// {key1}: {} is a row without entries.
+			p.next()
+			for _, c := range []string{":", "{", "}"} {
+				if err := p.expect(c); err != nil {
+					return err
+				}
+			}
+			if _, ok := m2.Map2Fields[key1]; ok {
+				return p.errorf(t, "duplicate key %s", key1)
+			}
+			m2.Map2Fields[key1] = &MapStruct{MapFields: make(map[string]*Struct)}
+			return nil
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+		key2, err := p.key()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("}"); err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		target, err := p.entry()
+		if err != nil {
+			return err
+		}
+		inner := m2.Map2Fields[key1]
+		if inner == nil {
+			inner = &MapStruct{MapFields: make(map[string]*Struct)}
+			m2.Map2Fields[key1] = inner
+		}
+		if _, ok := inner.MapFields[key2]; ok {
+			return p.errorf(t, "duplicate key {%s, %s}", key1, key2)
+		}
+		inner.MapFields[key2] = target
+		return nil
+	})
+	return value, err
+}
+
+// specPrinter prints a Struct graph in the notation of ParseSpec.
+type specPrinter struct {
+	b    strings.Builder
+	root *Struct
+	// refs counts the references to each Struct from within the graph.
+	refs    map[*Struct]int
+	labels  map[*Struct]string
+	printed map[*Struct]bool
+}
+
+// specChildren returns the Structs referenced by s, in printing order. A wrapped
+// list or map entry is printed as the collection, so its entries take its place.
+func specChildren(s *Struct) []*Struct {
+	var out []*Struct
+	for _, key := range sortedKeys(s.Choices) {
+		out = append(out, s.Choices[key])
+	}
+	for _, name := range sortedKeys(s.Fields) {
+		out = appendValueChildren(out, s.Fields[name])
+	}
+	return out
+}
+
+func appendValueChildren(out []*Struct, v *Value) []*Struct {
+	entry := func(x *Struct) {
+		if inner, ok := unwrapValueFromStruct(x); ok {
+			out = appendValueChildren(out, inner)
+		} else {
+			out = append(out, x)
+		}
+	}
+	switch k := v.GetKind().(type) {
+	case *Value_SingleStruct:
+		out = append(out, k.SingleStruct)
+	case *Value_ListStruct:
+		for _, x := range k.ListStruct.GetListFields() {
+			entry(x)
+		}
+	case *Value_MapStruct:
+		fields := k.MapStruct.GetMapFields()
+		for _, key := range sortedKeys(fields) {
+			entry(fields[key])
+		}
+	case *Value_Map2Struct:
+		fields := k.Map2Struct.GetMap2Fields()
+		for _, key1 := range sortedKeys(fields) {
+			inner := fields[key1].GetMapFields()
+			for _, key2 := range sortedKeys(inner) {
+				entry(inner[key2])
+			}
+		}
+	}
+	return out
+}
+
+func (p *specPrinter) count(s *Struct) {
+	for _, x := range specChildren(s) {
+		if x == nil {
+			continue
+		}
+		p.refs[x]++
+		if p.refs[x] == 1 && x != p.root {
+			p.count(x)
+		}
+	}
+}
+
+// label names every Struct referenced more than once, and the root if it is
+// referenced at all, after its class name in printing order.
+func (p *specPrinter) label(root *Struct) {
+	used := make(map[string]bool)
+	seen := make(map[*Struct]bool)
+	var walk func(s *Struct)
+	walk = func(s *Struct) {
+		if s == nil || seen[s] {
+			return
+		}
+		seen[s] = true
+		if p.refs[s] >= 2 || (s == root && p.refs[s] >= 1) {
+			base := s.ClassName
+			if !isSpecIdent(base) {
+				base = "Struct"
+			}
+			name := base
+			for i := 2; used[name]; i++ {
+				name = fmt.Sprintf("%s_%d", base, i)
+			}
+			used[name] = true
+			p.labels[s] = name
+		}
+		for _, x := range specChildren(s) {
+			walk(x)
+		}
+	}
+	walk(root)
+}
+
+func isSpecIdent(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !isSpecIdentRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *specPrinter) name(name string) {
+	if isSpecIdent(name) {
+		p.b.WriteString(name)
+	} else {
+		p.b.WriteString(strconv.Quote(name))
+	}
+}
+
+func (p *specPrinter) key(key string) {
+	if key == "*" {
+		p.b.WriteString(key)
+	} else {
+		p.name(key)
+	}
+}
+
+func (p *specPrinter) structTerm(s *Struct) error {
+	if s == nil {
+		return fmt.Errorf("nil Struct")
+	}
+	label, labelled := p.labels[s]
+	if labelled && p.printed[s] {
+		p.b.WriteString("*" + label)
+		return nil
+	}
+	p.printed[s] = true
+
+	if s.ClassName != "" || (s.ServiceName == "" && !labelled && len(s.Choices) == 0) {
+		p.name(s.ClassName)
+	}
+	if s.ServiceName != "" {
+		p.b.WriteString("@")
+		p.name(s.ServiceName)
+	}
+	if labelled {
+		p.b.WriteString("&" + label)
+	}
+	if len(s.Choices) > 0 {
+		p.b.WriteString("<")
+		if s.Discriminator != "" {
+			p.name(s.Discriminator)
+		}
+		p.b.WriteString(">(")
+		for i, key := range sortedKeys(s.Choices) {
+			if i > 0 {
+				p.b.WriteString(", ")
+			}
+			p.name(key)
+			p.b.WriteString(": ")
+			if err := p.structTerm(s.Choices[key]); err != nil {
+				return fmt.Errorf("choice %q: %w", key, err)
+			}
+		}
+		p.b.WriteString(")")
+	}
+	if len(s.Fields) > 0 {
+		p.b.WriteString("{")
+		for i, name := range sortedKeys(s.Fields) {
+			if i > 0 {
+				p.b.WriteString(", ")
+			}
+			p.name(name)
+			p.b.WriteString(": ")
+			if err := p.value(s.Fields[name]); err != nil {
+				return fmt.Errorf("field %q: %w", name, err)
+			}
+		}
+		p.b.WriteString("}")
+	}
+	return nil
+}
+
+// entry prints an entry of a list or map, unwrapping a nested list or map.
+func (p *specPrinter) entry(s *Struct) error {
+	if v, ok := unwrapValueFromStruct(s); ok {
+		return p.value(v)
+	}
+	return p.structTerm(s)
+}
+
+func (p *specPrinter) value(v *Value) error {
+	switch k := v.GetKind().(type) {
+	case *Value_SingleStruct:
+		return p.structTerm(k.SingleStruct)
+
+	case *Value_ListStruct:
+		p.b.WriteString("[")
+		for i, item := range k.ListStruct.GetListFields() {
+			if i > 0 {
+				p.b.WriteString(", ")
+			}
+			if err := p.entry(item); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		p.b.WriteString("]")
+
+	case *Value_MapStruct:
+		fields := k.MapStruct.GetMapFields()
+		p.b.WriteString("{")
+		for i, key := range sortedKeys(fields) {
+			if i > 0 {
+				p.b.WriteString(", ")
+			}
+			p.key(key)
+			p.b.WriteString(": ")
+			if err := p.entry(fields[key]); err != nil {
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+		}
+		p.b.WriteString("}")
+
+	case *Value_Map2Struct:
+		fields := k.Map2Struct.GetMap2Fields()
+		p.b.WriteString("{")
+		n := 0
+		for _, key1 := range sortedKeys(fields) {
+			inner := fields[key1].GetMapFields()
+			if len(inner) == 0 {
+				if n > 0 {
+					p.b.WriteString(", ")
+				}
+				n++
+				p.b.WriteString("{")
+				p.key(key1)
+				p.b.WriteString("}: {}")
+				continue
+			}
+			for _, key2 := range sortedKeys(inner) {
+				if n > 0 {
+					p.b.WriteString(", ")
+				}
+				n++
+				p.b.WriteString("{")
+				p.key(key1)
+				p.b.WriteString(", ")
+				p.key(key2)
+				p.b.WriteString("}: ")
+				if err := p.entry(inner[key2]); err != nil {
+					return fmt.Errorf("key {%s, %s}: %w", key1, key2, err)
+				}
+			}
+		}
+		if n == 0 {
+			p.b.WriteString("{}")
+		}
+		p.b.WriteString("}")
+
+	default:
+		return fmt.Errorf("unknown Value kind: %T", v.GetKind())
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec(`
+// The example of the notation.
+Config{
+	Database: PostgresDB@dbService,
+	Servers: [HTTPServer, GRPCServer],
+	Handlers: {api: APIHandler, *: WebHandler},
+	Grid: {{r1, k1}: Cell, {*, *}: Cell},
+	Shape: <kind>(circle: Circle{Center: Point}, square: Square@squareService),
+	"odd name": "x/y z",
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewServiceStruct("Config", map[string]any{
+		"Database": []string{"PostgresDB", "dbService"},
+		"Servers":  [][]string{{"HTTPServer"}, {"GRPCServer"}},
+		"Handlers": map[string][]string{"api": {"APIHandler"}, "*": {"WebHandler"}},
+		"Grid":     map[[2]string][]string{{"r1", "k1"}: {"Cell"}, {"*", "*"}: {"Cell"}},
+		"odd name": []string{"x/y z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Fields["Shape"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{
+		Discriminator: "kind",
+		Choices: map[string]*Struct{
+			"circle": {ClassName: "Circle", Fields: map[string]*Value{
+				"Center": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "Point"}}},
+			}},
+			"square": {ClassName: "Square", ServiceName: "squareService"},
+		},
+	}}}
+	if !proto.Equal(spec, want) {
+		t.Errorf("got  %v\nwant %v", spec, want)
+	}
+
+	text, err := spec.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantText := `Config{Database: PostgresDB@dbService, Grid: {{*, *}: Cell, {r1, k1}: Cell}, ` +
+		`Handlers: {*: WebHandler, api: APIHandler}, Servers: [HTTPServer, GRPCServer], ` +
+		`Shape: <kind>(circle: Circle{Center: Point}, square: Square@squareService), "odd name": "x/y z"}`
+	if string(text) != wantText {
+		t.Errorf("got  %s\nwant %s", text, wantText)
+	}
+}
+
+func TestStruct_MarshalText_RoundTrip(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"Single":    "Circle",
+		"List":      []string{"HTTPServer", "GRPCServer"},
+		"EmptyList": []string{},
+		"Keyed":     map[string]string{"api": "APIHandler", "*": "WebHandler"},
+		"EmptyMap":  map[string]string{},
+		"Grid":      map[[2]string]string{{"r1", "k1"}: "Cell"},
+		"EmptyGrid": map[[2]string]string{},
+		"Nested": [][2]any{
+			{"Server", map[string]any{"Handlers": map[string]string{"a": "A"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Fields["Anonymous"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{
+		Fields: map[string]*Value{"A": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ServiceName: "s"}}}},
+	}}}
+	spec.Fields["Server"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{Choices: map[string]*Struct{
+		"HTTPServer": {ClassName: "HTTPServer"},
+		"grpc":       {ClassName: "GRPCServer"},
+	}}}}
+
+	text, err := spec.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`Anonymous: ""{A: @s}`,
+		`EmptyGrid: {{}}`,
+		`EmptyList: []`,
+		`EmptyMap: {}`,
+		`Server: <>(HTTPServer: HTTPServer, grpc: GRPCServer)`,
+	} {
+		if !strings.Contains(string(text), want) {
+			t.Errorf("%s\nshould contain %s", text, want)
+		}
+	}
+	var restored Struct
+	if err := restored.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, &restored) {
+		t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", text, &restored, spec)
+	}
+}
+
+func TestStruct_MarshalText_Cyclic(t *testing.T) {
+	tree := newCyclicTree()
+	text, err := tree.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Tree&Tree{Index: {*: Leaf@leafService&Leaf}, Root: Node&Node{Children: [*Node], Leaf: *Leaf, Owner: *Tree}}`
+	if string(text) != want {
+		t.Errorf("got  %s\nwant %s", text, want)
+	}
+
+	var restored Struct
+	if err := restored.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if !sameGraph(tree, &restored) {
+		t.Error("cyclic Struct did not round-trip")
+	}
+	node := restored.Fields["Root"].GetSingleStruct()
+	if node.Fields["Owner"].GetSingleStruct() != &restored {
+		t.Error("reference to the root should point to the receiver")
+	}
+	if node.Fields["Children"].GetListStruct().ListFields[0] != node {
+		t.Error("self reference should point to the same *Struct")
+	}
+}
+
+func TestParseSpec_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"missing colon", "Config{A Circle}", "line 1, column 10: expected ':', found 'Circle'"},
+		{"missing comma", "Config{A: X\n  B: Y}", "line 2, column 3: expected ',', found 'B'"},
+		{"unclosed", "Config{A: [X", "line 1, column 13: expected ',', found end of input"},
+		{"undefined label", "Config{A: *n}", `line 1, column 12: undefined label "n"`},
+		{"duplicate label", "Config&n{A: X&n}", `line 1, column 15: label "n" defined twice`},
+		{"duplicate field", "Config{A: X, A: Y}", `line 1, column 14: duplicate field "A"`},
+		{"map of classes with fields", "Config{A: {B: X{C: Y}}, D: {E: F}}", ""},
+		{"bad character", "Config{A: X;}", "line 1, column 12: unexpected character ';'"},
+		{"trailing", "Config{} Extra", "line 1, column 10: unexpected 'Extra' after the spec"},
+		{"unterminated string", `Config{A: "X}`, "line 1, column 11: unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSpec(tt.text)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStruct_MarshalText_RootReferencedOnce(t *testing.T) {
+	// The root referenced once must not be counted again through its
+	// children, which would label Structs that are referenced only once.
+	tree := &Struct{ClassName: "Tree"}
+	node := &Struct{ClassName: "Node", Fields: map[string]*Value{
+		"Leaf":  {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "Leaf"}}},
+		"Owner": {Kind: &Value_SingleStruct{SingleStruct: tree}},
+	}}
+	tree.Fields = map[string]*Value{"Root": {Kind: &Value_SingleStruct{SingleStruct: node}}}
+
+	text, err := tree.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Tree&Tree{Root: Node{Leaf: Leaf, Owner: *Tree}}`
+	if string(text) != want {
+		t.Errorf("got  %s\nwant %s", text, want)
+	}
+}
+
+func TestStruct_MarshalText_NestedCollections(t *testing.T) {
+	spec := newNestedSpec(t)
+	text, err := spec.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Board{Grid: [[Cell]], Index: {*: [Cell]}}`
+	if string(text) != want {
+		t.Errorf("got  %s\nwant %s", text, want)
+	}
+
+	var restored Struct
+	if err := restored.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, &restored) {
+		t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", text, &restored, spec)
+	}
+
+	parsed, err := ParseSpec(`{Rows: [{a: Cell}, []], Grid: {{r1, k1}: [Cell]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := unwrapValueFromStruct(parsed.Fields["Rows"].GetListStruct().StructAt(0)); !ok || v.GetMapStruct().StructFor("a").GetClassName() != "Cell" {
+		t.Errorf("a map in a list should be wrapped: %v", parsed.Fields["Rows"])
+	}
+	if _, ok := unwrapValueFromStruct(parsed.Fields["Grid"].GetMap2Struct().GetMap2Fields()["r1"].GetMapFields()["k1"]); !ok {
+		t.Errorf("a list in a two-level map should be wrapped: %v", parsed.Fields["Grid"])
+	}
+}
+
+func TestStruct_MarshalText_EmptyMap2Row(t *testing.T) {
+	spec := &Struct{ClassName: "Sheet", Fields: map[string]*Value{
+		"Grid": {Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map[string]*MapStruct{
+			"r1": {},
+			"r2": {MapFields: map[string]*Struct{"k1": {ClassName: "Cell"}}},
+		}}}},
+	}}
+	text, err := spec.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Sheet{Grid: {{r1}: {}, {r2, k1}: Cell}}`
+	if string(text) != want {
+		t.Errorf("got  %s\nwant %s", text, want)
+	}
+
+	var restored Struct
+	if err := restored.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, &restored) {
+		t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", text, &restored, spec)
+	}
+	if _, ok := restored.Fields["Grid"].GetMap2Struct().GetMap2Fields()["r1"]; !ok {
+		t.Error("the empty row should be kept")
+	}
+
+	if _, err := ParseSpec(`{Grid: {{r1}: {}, {r1}: {}}}`); err == nil || !strings.Contains(err.Error(), "duplicate key r1") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
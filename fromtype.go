@@ -0,0 +1,439 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// TypeOptions configures StructFromType.
+type TypeOptions struct {
+	// Registry supplies default class names: if exactly one registered class
+	// implements the interface type of a field, it is used for that field, and the
+	// fields of registered classes are walked in turn. Nil means DefaultRegistry.
+	Registry *Registry
+	// TagKey is the struct tag holding explicit class and service names,
+	// e.g. `schema:"class=Circle,service=shapeService"`. Defaults to "schema".
+	TagKey string
+}
+
+const defaultTagKey = "schema"
+
+// StructFor derives a skeleton Struct from the Go type T. See StructFromType.
+func StructFor[T any](opts ...*TypeOptions) (*Struct, []string, error) {
+	var o *TypeOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	return StructFromType(reflect.TypeOf((*T)(nil)).Elem(), o)
+}
+
+// StructFromType derives a skeleton Struct from a Go struct type by reflection,
+// so that specs do not drift from the Go code.
+//
+// The walk finds every field whose concrete type must be chosen at runtime:
+//
+//	╔════════════════════════════════════╤══════════════════════════════════╗
+//	║ Go field type                      │ Value                            ║
+//	╠════════════════════════════════════╪══════════════════════════════════╣
+//	║ interface                          │ SingleStruct                     ║
+//	║ []interface, [N]interface          │ ListStruct with one entry        ║
+//	║ map[string]interface               │ MapStruct with key "*"           ║
+//	║ map[[2]string]interface            │ Map2Struct with keys "*", "*"    ║
+//	║ map[string]map[string]interface    │ Map2Struct with keys "*", "*"    ║
+//	║ struct, *struct (and collections)  │ SingleStruct with nested Fields, ║
+//	║                                    │ if the struct has such fields    ║
+//	╚════════════════════════════════════╧══════════════════════════════════╝
+//
+// Interfaces without methods (any) are only included when tagged. Fields of embedded
+// structs are promoted, and fields tagged `schema:"-"` are skipped.
+//
+// The class of an interface field comes from its struct tag, or else from the only
+// class in the registry implementing the interface. Fields of a resolved class are
+// walked as well. Interface fields that cannot be resolved are left with an empty
+// ClassName, and their paths (e.g. `Config.Servers[0]`) are returned, sorted,
+// as the list of places to fill in.
+//
+// Tags can also name service names and the entries of collections; see schemaTag:
+//
+//	type Config struct {
+//	    Database DB       `schema:"class=PostgresDB,service=dbService"`
+//	    Servers  []Server `schema:"list=HTTPServer|GRPCServer@grpcService"`
+//	    Handlers map[string]Handler `schema:"map=api:APIHandler|web:WebHandler"`
+//	}
+//
+// A recursive type, such as a Node with Children []*Node, yields a cyclic Struct
+// whose recursive fields point back to the Struct of the enclosing type.
+//
+// As with NewServiceStruct, a service name must be on a leaf struct; a tagged
+// service on a class with nested fields is an error. The result of a fully tagged
+// type is therefore interchangeable with the equivalent NewServiceStruct spec.
+func StructFromType(t reflect.Type, opts *TypeOptions) (*Struct, []string, error) {
+	if t == nil {
+		return nil, nil, fmt.Errorf("StructFromType: nil type")
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("StructFromType: type must be a struct or pointer to struct, got %v", t)
+	}
+
+	w := &typeWalker{
+		reg:        DefaultRegistry,
+		tagKey:     defaultTagKey,
+		inProgress: make(map[reflect.Type]*Struct),
+		named:      make(map[tagClass]*Struct),
+	}
+	if opts != nil {
+		w.reg = registryOrDefault(opts.Registry)
+		if opts.TagKey != "" {
+			w.tagKey = opts.TagKey
+		}
+	}
+
+	path := t.Name()
+	if path == "" {
+		path = "<root>"
+	}
+	s, err := w.structOf(t, path)
+	if err == nil {
+		for _, fixup := range w.fixups {
+			fixup()
+		}
+		err = validateServiceEndStruct(s)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("StructFromType: %w", err)
+	}
+	sort.Strings(w.unresolved)
+	return s, w.unresolved, nil
+}
+
+type typeWalker struct {
+	reg    *Registry
+	tagKey string
+	// inProgress and named hold the Structs being built for struct types and
+	// registered classes, so that recursive types yield cyclic Structs.
+	inProgress map[reflect.Type]*Struct
+	named      map[tagClass]*Struct
+	// fixups run once the walk is complete.
+	fixups     []func()
+	unresolved []string
+}
+
+// structOf returns the Struct of struct type t, with Fields for every field that needs a class.
+// A type that is already being walked yields the Struct being built for it, so a
+// recursive type such as a Node with Children []*Node gives a cyclic Struct.
+func (w *typeWalker) structOf(t reflect.Type, path string) (*Struct, error) {
+	if s := w.inProgress[t]; s != nil {
+		return s, nil
+	}
+	s := &Struct{ClassName: t.Name()}
+	w.inProgress[t] = s
+	defer delete(w.inProgress, t)
+
+	if err := w.addFields(s, t, path, false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// addFields adds the field specifications of struct type t to s.
+// Promoted fields of embedded structs do not override fields already present.
+func (w *typeWalker) addFields(s *Struct, t reflect.Type, path string, promoted bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		raw, tagged := field.Tag.Lookup(w.tagKey)
+		if raw == "-" {
+			continue
+		}
+		tag, err := parseSchemaTag(raw)
+		if err != nil {
+			return atPath(path+"."+field.Name, err)
+		}
+
+		if field.Anonymous && !tagged {
+			et := field.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				if err := w.addFields(s, et, path, true); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if _, ok := s.Fields[field.Name]; ok && promoted {
+			continue
+		}
+
+		v, err := w.valueOf(field.Type, tag, path+"."+field.Name)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			continue
+		}
+		if s.Fields == nil {
+			s.Fields = make(map[string]*Value)
+		}
+		s.Fields[field.Name] = v
+	}
+	return nil
+}
+
+// valueOf returns the Value describing a field of type ft, or nil if
+// nothing in ft needs a class.
+func (w *typeWalker) valueOf(ft reflect.Type, tag schemaTag, path string) (*Value, error) {
+	if err := tag.check(ft); err != nil {
+		return nil, atPath(path, err)
+	}
+	if isClassType(ft, tag) {
+		s, err := w.classStruct(ft, tag.elem, path)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_SingleStruct{SingleStruct: s}}, nil
+	}
+
+	switch ft.Kind() {
+	case reflect.Slice, reflect.Array:
+		if tag.list != nil {
+			structs := make([]*Struct, len(tag.list))
+			for i, c := range tag.list {
+				s, err := w.namedClass(c, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return nil, err
+				}
+				structs[i] = s
+			}
+			return &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: structs}}}, nil
+		}
+		elem, err := w.elemStruct(ft.Elem(), tag.elem, path+"[0]")
+		if err != nil || elem == nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{elem}}}}, nil
+
+	case reflect.Map:
+		if tag.mapping != nil {
+			fields := make(map[string]*Struct, len(tag.mapping))
+			for key, c := range tag.mapping {
+				s, err := w.namedClass(c, fmt.Sprintf("%s[%q]", path, key))
+				if err != nil {
+					return nil, err
+				}
+				fields[key] = s
+			}
+			return &Value{Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: fields}}}, nil
+		}
+		if tag.map2 != nil {
+			fields := make(map[string]*MapStruct)
+			for key, c := range tag.map2 {
+				s, err := w.namedClass(c, fmt.Sprintf("%s[%q][%q]", path, key[0], key[1]))
+				if err != nil {
+					return nil, err
+				}
+				if fields[key[0]] == nil {
+					fields[key[0]] = &MapStruct{MapFields: make(map[string]*Struct)}
+				}
+				fields[key[0]].MapFields[key[1]] = s
+			}
+			return &Value{Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: fields}}}, nil
+		}
+
+		var elemType reflect.Type
+		switch {
+		case isStringPairKey(ft.Key()):
+			elemType = ft.Elem()
+		case isMap2Nested(ft):
+			elemType = ft.Elem().Elem()
+		case isStringKey(ft.Key()):
+			elem, err := w.elemStruct(ft.Elem(), tag.elem, path+`["*"]`)
+			if err != nil || elem == nil {
+				return nil, err
+			}
+			return &Value{Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"*": elem}}}}, nil
+		default:
+			return nil, nil
+		}
+		elem, err := w.elemStruct(elemType, tag.elem, path+`["*"]["*"]`)
+		if err != nil || elem == nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map[string]*MapStruct{
+			"*": {MapFields: map[string]*Struct{"*": elem}},
+		}}}}, nil
+
+	case reflect.Ptr, reflect.Struct:
+		elem, err := w.elemStruct(ft, tag.elem, path)
+		if err != nil || elem == nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_SingleStruct{SingleStruct: elem}}, nil
+	}
+	return nil, nil
+}
+
+// elemStruct returns the Struct describing a single value of type et, which is either
+// an interface needing a class or a struct containing such fields; otherwise nil.
+// A concrete struct tagged with a service is included even without such fields.
+func (w *typeWalker) elemStruct(et reflect.Type, c tagClass, path string) (*Struct, error) {
+	if et.Kind() == reflect.Interface && (et.NumMethod() > 0 || c.class != "") {
+		return w.classStruct(et, c, path)
+	}
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	if s := w.inProgress[et]; s != nil {
+		// A recursive reference: its Fields are not known yet.
+		if c.service != "" {
+			leaf := &Struct{ClassName: s.ClassName, ServiceName: c.service}
+			w.fixups = append(w.fixups, func() { leaf.Fields = s.Fields })
+			return leaf, nil
+		}
+		if !w.reaches(et, make(map[reflect.Type]bool)) {
+			return nil, nil
+		}
+		return s, nil
+	}
+	s, err := w.structOf(et, path)
+	if err != nil {
+		return nil, err
+	}
+	if c.service != "" {
+		s.ServiceName = c.service
+		return s, nil
+	}
+	if len(s.Fields) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// classStruct resolves the class of the interface type iface.
+func (w *typeWalker) classStruct(iface reflect.Type, c tagClass, path string) (*Struct, error) {
+	if c.class == "" {
+		if impl := w.reg.Implementing(iface); len(impl) == 1 {
+			c.class = impl[0]
+		}
+	}
+	if c.class == "" {
+		w.unresolved = append(w.unresolved, path)
+		return &Struct{ServiceName: c.service}, nil
+	}
+	return w.namedClass(c, path)
+}
+
+// namedClass returns the Struct of a class given by name. If the class is
+// registered, its fields are walked as well.
+func (w *typeWalker) namedClass(c tagClass, path string) (*Struct, error) {
+	if s := w.named[c]; s != nil {
+		return s, nil
+	}
+	s := &Struct{ClassName: c.class, ServiceName: c.service}
+	ct, ok := w.reg.Lookup(c.class)
+	if !ok || ct.Kind() != reflect.Struct {
+		return s, nil
+	}
+	w.named[c] = s
+	defer delete(w.named, c)
+	if w.inProgress[ct] == nil {
+		w.inProgress[ct] = s
+		defer delete(w.inProgress, ct)
+	}
+	if err := w.addFields(s, ct, path, false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reaches reports whether walking the struct type t yields any Fields, i.e.
+// whether t, or a type reachable from its fields, has a field needing a class
+// or a schema tag. It decides whether a recursive reference to t is kept.
+func (w *typeWalker) reaches(t reflect.Type, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		raw, tagged := field.Tag.Lookup(w.tagKey)
+		if raw == "-" {
+			continue
+		}
+		tag, err := parseSchemaTag(raw)
+		if err != nil {
+			return true
+		}
+		if field.Anonymous && !tagged {
+			et := field.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				if w.reaches(et, visited) {
+					return true
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if tag.list != nil || tag.mapping != nil || tag.map2 != nil || isClassType(field.Type, tag) {
+			return true
+		}
+
+		et := field.Type
+		switch et.Kind() {
+		case reflect.Slice, reflect.Array:
+			et = et.Elem()
+		case reflect.Map:
+			switch {
+			case isStringPairKey(et.Key()):
+				et = et.Elem()
+			case isMap2Nested(et):
+				et = et.Elem().Elem()
+			case isStringKey(et.Key()):
+				et = et.Elem()
+			default:
+				continue
+			}
+		}
+		if et.Kind() == reflect.Interface && (et.NumMethod() > 0 || tag.elem.class != "") {
+			return true
+		}
+		for et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+		if et.Kind() == reflect.Struct && (tag.elem.service != "" || w.reaches(et, visited)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isClassType reports whether values of type t need a class from the spec:
+// interfaces with methods, or any interface when the field is tagged with a class.
+func isClassType(t reflect.Type, tag schemaTag) bool {
+	return t.Kind() == reflect.Interface && (t.NumMethod() > 0 || tag.elem.class != "")
+}
+
+// isMap2Nested reports whether t is a map[string]map[string]T.
+func isMap2Nested(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && isStringKey(t.Key()) &&
+		t.Elem().Kind() == reflect.Map && isStringKey(t.Elem().Key())
+}
+
+func isStringKey(t reflect.Type) bool {
+	return t.Kind() == reflect.String || reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
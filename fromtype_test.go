@@ -0,0 +1,209 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type ftShape interface {
+	Area() float64
+}
+
+type ftStore interface {
+	Get(key string) string
+}
+
+type ftCircle struct {
+	Radius float64
+}
+
+func (c *ftCircle) Area() float64 { return 0 }
+
+type ftDB struct {
+	Cache ftStore `schema:"class=Redis"`
+}
+
+func (d *ftDB) Get(string) string { return "" }
+
+type ftBase struct {
+	Primary ftShape
+}
+
+type ftInner struct {
+	Name  string
+	Shape ftShape
+}
+
+type ftConfig struct {
+	ftBase
+	Name     string
+	Any      any
+	Tagged   any `schema:"class=Circle"`
+	Store    ftStore
+	Shapes   []ftShape
+	Fixed    [2]ftShape
+	ByName   map[string]ftShape
+	Grid     map[[2]string]ftShape
+	Nested   map[string]map[string]ftShape
+	Inner    *ftInner
+	Inners   []ftInner
+	Plain    *ftCircle
+	Skipped  ftShape `schema:"-"`
+	unexport ftShape
+}
+
+func TestStructFromType(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[ftCircle](reg, "Circle"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, unresolved, err := StructFor[ftConfig](&TypeOptions{Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := NewStruct("ftConfig", map[string]any{
+		"Primary": "Circle",
+		"Tagged":  "Circle",
+		"Store":   &Struct{},
+		"Shapes":  []string{"Circle"},
+		"Fixed":   []string{"Circle"},
+		"ByName":  map[string]string{"*": "Circle"},
+		"Grid":    map[[2]string]string{{"*", "*"}: "Circle"},
+		"Nested":  map[[2]string]string{{"*", "*"}: "Circle"},
+		"Inner":   [2]any{"ftInner", map[string]any{"Shape": "Circle"}},
+		"Inners":  [][2]any{{"ftInner", map[string]any{"Shape": "Circle"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("StructFor mismatch:\ngot  %v\nwant %v", got, want)
+	}
+	if !reflect.DeepEqual(unresolved, []string{"ftConfig.Store"}) {
+		t.Errorf("unresolved = %v", unresolved)
+	}
+}
+
+func TestStructFromType_NestedClassAndUnresolved(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[ftDB](reg, "DB"); err != nil {
+		t.Fatal(err)
+	}
+
+	type config struct {
+		Store  ftStore
+		Shapes []ftShape
+		Grid   map[[2]string]ftShape
+	}
+	got, unresolved, err := StructFromType(reflect.TypeOf(&config{}), &TypeOptions{Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := got.Fields["Store"].GetSingleStruct()
+	if store.ClassName != "DB" || store.Fields["Cache"].GetSingleStruct().ClassName != "Redis" {
+		t.Errorf("Store = %v", store)
+	}
+	want := []string{`config.Grid["*"]["*"]`, "config.Shapes[0]"}
+	if !reflect.DeepEqual(unresolved, want) {
+		t.Errorf("unresolved = %v, want %v", unresolved, want)
+	}
+}
+
+func TestStructFromType_Errors(t *testing.T) {
+	if _, _, err := StructFromType(reflect.TypeOf(1), nil); err == nil {
+		t.Error("expected error for non-struct type")
+	}
+	type badTag struct {
+		Shape ftShape `schema:"colour=red"`
+	}
+	if _, _, err := StructFor[badTag](); err == nil {
+		t.Error("expected error for unknown tag key")
+	}
+	type customTag struct {
+		Shape ftShape `spec:"class=Circle"`
+	}
+	got, unresolved, err := StructFor[customTag](&TypeOptions{Registry: NewRegistry(), TagKey: "spec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Fields["Shape"].GetSingleStruct().ClassName != "Circle" || len(unresolved) != 0 {
+		t.Errorf("custom tag key not honored: %v %v", got, unresolved)
+	}
+}
+
+type ftNode struct {
+	Name     string
+	Shape    ftShape
+	Children []*ftNode
+	Parent   *ftNode
+}
+
+type ftPlainNode struct {
+	Name     string
+	Children []*ftPlainNode
+}
+
+type ftTreeNode interface {
+	Kids() []ftTreeNode
+}
+
+type ftBranch struct {
+	Kids_ []ftTreeNode
+}
+
+func (b *ftBranch) Kids() []ftTreeNode { return b.Kids_ }
+
+func TestStructFromType_Recursive(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[ftCircle](reg, "Circle"); err != nil {
+		t.Fatal(err)
+	}
+	got, unresolved, err := StructFor[ftNode](&TypeOptions{Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unresolved) != 0 {
+		t.Errorf("unexpected unresolved paths %v", unresolved)
+	}
+	if got.Fields["Children"].GetListStruct().ListFields[0] != got || got.Fields["Parent"].GetSingleStruct() != got {
+		t.Errorf("recursive fields should point back to the root Struct")
+	}
+	if got.Fields["Shape"].GetSingleStruct().ClassName != "Circle" {
+		t.Errorf("Shape not resolved: %v", got.Fields["Shape"])
+	}
+
+	plain, _, err := StructFor[ftPlainNode]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.Fields) != 0 {
+		t.Errorf("recursion alone should not produce fields, got %v", plain.Fields)
+	}
+}
+
+func TestStructFromType_RecursiveClass(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[ftBranch](reg, "Branch"); err != nil {
+		t.Fatal(err)
+	}
+	type forest struct {
+		Trees []ftTreeNode
+	}
+	got, _, err := StructFor[forest](&TypeOptions{Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch := got.Fields["Trees"].GetListStruct().ListFields[0]
+	if branch.ClassName != "Branch" || branch.Fields["Kids_"].GetListStruct().ListFields[0] != branch {
+		t.Errorf("recursive class should yield a cyclic Struct, got %v", branch)
+	}
+	if _, err := json.Marshal(got); err != nil {
+		t.Errorf("cyclic spec should marshal: %v", err)
+	}
+}
@@ -0,0 +1,486 @@
+// Package hcl decodes HCL configuration into Go structs whose interface fields
+// are described by a schema.Struct specification.
+//
+// Blocks are matched to the spec'd field kinds by their number of labels:
+//
+//	╔══════════════════════════════╤══════════════╤══════════════════════════════╗
+//	║ HCL                          │ Spec Value   │ Go field                     ║
+//	╠══════════════════════════════╪══════════════╪══════════════════════════════╣
+//	║ shape { ... }                │ SingleStruct │ interface, *T or T           ║
+//	║ shapes { ... } (repeated)    │ ListStruct   │ []interface, []*T or []T     ║
+//	║ shape "name" { ... }         │ MapStruct    │ map[string]interface         ║
+//	║ shape "row" "col" { ... }    │ Map2Struct   │ map[[2]string]interface or   ║
+//	║                              │              │ map[string]map[string]...    ║
+//	╚══════════════════════════════╧══════════════╧══════════════════════════════╝
+//
+// A spec'd Struct with Choices picks the choice named by the string attribute
+// Discriminator of the block, e.g. kind = "circle", then decodes as that choice.
+// The attribute is decoded into the chosen class only if the class declares it.
+//
+// Field names in the spec are Go field names. The block type name is taken from
+// the field's hcl tag (as in gohcl, e.g. `hcl:"shape,block"`), or is the Go field
+// name if the field has no hcl tag. All other fields are decoded following the
+// gohcl tag conventions: attributes, optional attributes, labels, nested blocks,
+// and remain and body fields.
+package hcl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/tabilet/schema"
+)
+
+// Unmarshal parses HCL native syntax in data and decodes it into target using spec.
+// See DecodeBody for the decoding rules.
+func Unmarshal(data []byte, target any, spec *schema.Struct, reg ...*schema.Registry) error {
+	file, diags := hclsyntax.ParseConfig(data, "<input>", hcl.InitialPos)
+	if diags.HasErrors() {
+		return diags
+	}
+	return DecodeBody(file.Body, nil, target, spec, reg...)
+}
+
+// DecodeBody decodes an HCL body into target, which must be a non-nil pointer,
+// instantiating the class named by spec for every interface field, recursively.
+//
+// Expressions are evaluated in ctx, which may be nil. If reg is omitted,
+// schema.DefaultRegistry is used. Errors are hcl.Diagnostics whose summaries
+// carry the spec path, e.g. "Config.Servers[1]".
+func DecodeBody(body hcl.Body, ctx *hcl.EvalContext, target any, spec *schema.Struct, reg ...*schema.Registry) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("hcl: target must be a non-nil pointer, got %T", target)
+	}
+
+	d := &decoder{ctx: ctx, reg: schema.DefaultRegistry}
+	if len(reg) > 0 && reg[0] != nil {
+		d.reg = reg[0]
+	}
+	path := "<root>"
+	if spec.GetClassName() != "" {
+		path = spec.GetClassName()
+	}
+	if diags := d.decodeSingle(body, nil, rv.Elem(), spec, path, nil); diags.HasErrors() {
+		return diags
+	}
+	return nil
+}
+
+type decoder struct {
+	ctx *hcl.EvalContext
+	reg *schema.Registry
+}
+
+var (
+	bodyType = reflect.TypeOf((*hcl.Body)(nil)).Elem()
+	exprType = reflect.TypeOf((*hcl.Expression)(nil)).Elem()
+)
+
+// fieldTag is a parsed gohcl-style hcl struct tag.
+type fieldTag struct {
+	index []int
+	name  string
+	kind  string
+}
+
+// parseFields returns the exported fields of t with their hcl tags.
+// Untagged fields are returned with an empty kind.
+func parseFields(t reflect.Type) []fieldTag {
+	var out []fieldTag
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("hcl")
+		if !ok {
+			out = append(out, fieldTag{index: field.Index, name: field.Name})
+			continue
+		}
+		name, kind, found := strings.Cut(tag, ",")
+		if !found {
+			kind = "attr"
+		}
+		if name == "" {
+			name = field.Name
+		}
+		out = append(out, fieldTag{index: field.Index, name: name, kind: kind})
+	}
+	return out
+}
+
+// decodeSingle decodes a block body into dst, whose type may be an interface,
+// a pointer or a struct, according to the class described by s.
+func (d *decoder) decodeSingle(body hcl.Body, labels []string, dst reflect.Value, s *schema.Struct, path string, rng *hcl.Range) hcl.Diagnostics {
+	if len(s.GetChoices()) > 0 {
+		return d.decodeChoice(body, labels, dst, s, path, rng)
+	}
+	switch dst.Kind() {
+	case reflect.Interface:
+		if s == nil || s.ClassName == "" {
+			return errorAt(path, rng, "no class specified for %v", dst.Type())
+		}
+		obj, err := d.reg.New(s.ClassName)
+		if err != nil {
+			return errorAt(path, rng, "%v", err)
+		}
+		ptr := reflect.ValueOf(obj)
+		if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+			return errorAt(path, rng, "class %q must be instantiated as a pointer to struct, got %T", s.ClassName, obj)
+		}
+		diags := d.decodeStruct(body, labels, ptr.Elem(), s, path)
+		if diags.HasErrors() {
+			return diags
+		}
+		switch {
+		case ptr.Type().AssignableTo(dst.Type()):
+			dst.Set(ptr)
+		case ptr.Elem().Type().AssignableTo(dst.Type()):
+			dst.Set(ptr.Elem())
+		default:
+			return errorAt(path, rng, "class %q (%v) is not assignable to %v", s.ClassName, ptr.Type(), dst.Type())
+		}
+		return diags
+	case reflect.Ptr:
+		ptr := reflect.New(dst.Type().Elem())
+		if ptr.Elem().Kind() != reflect.Struct {
+			return errorAt(path, rng, "block requires struct type, got %v", dst.Type())
+		}
+		diags := d.decodeStruct(body, labels, ptr.Elem(), s, path)
+		dst.Set(ptr)
+		return diags
+	case reflect.Struct:
+		return d.decodeStruct(body, labels, dst, s, path)
+	default:
+		return errorAt(path, rng, "block requires struct, pointer or interface type, got %v", dst.Type())
+	}
+}
+
+// decodeChoice decodes a block body into dst as the choice of s named by the
+// discriminator attribute of the body.
+func (d *decoder) decodeChoice(body hcl.Body, labels []string, dst reflect.Value, s *schema.Struct, path string, rng *hcl.Range) hcl.Diagnostics {
+	if s.Discriminator == "" {
+		return errorAt(path, rng, "spec has choices but no discriminator")
+	}
+	content, rest, diags := body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: s.Discriminator, Required: true}},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+	attr := content.Attributes[s.Discriminator]
+	var key string
+	if diags := gohcl.DecodeExpression(attr.Expr, d.ctx, &key); diags.HasErrors() {
+		return append(errorAt(path, &attr.Range, "discriminator %q must be a string", s.Discriminator), diags...)
+	}
+	choice := s.ChoiceFor(key)
+	if choice == nil {
+		return errorAt(path, &attr.Range, "no choice for %s %q", s.Discriminator, key)
+	}
+
+	t := dst.Type()
+	if dst.Kind() == reflect.Interface {
+		t, _ = d.reg.Lookup(choice.ClassName)
+	}
+	if !declaresAttr(t, s.Discriminator) {
+		body = rest
+	}
+	return d.decodeSingle(body, labels, dst, choice, fmt.Sprintf("%s(%s)", path, key), rng)
+}
+
+// declaresAttr reports whether the struct behind t has the attribute name.
+func declaresAttr(t reflect.Type, name string) bool {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return false
+	}
+	for _, f := range parseFields(t) {
+		if f.name == name && (f.kind == "attr" || f.kind == "optional") {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeStruct decodes body into the addressable struct rv.
+func (d *decoder) decodeStruct(body hcl.Body, labels []string, rv reflect.Value, s *schema.Struct, path string) hcl.Diagnostics {
+	t := rv.Type()
+	fields := parseFields(t)
+	specFields := s.GetFields()
+
+	bodySchema := &hcl.BodySchema{}
+	attrs := make(map[string]fieldTag)
+	blocks := make(map[string]fieldTag)
+	specBlocks := make(map[string]string)
+	var labelFields []fieldTag
+	var remain, whole *fieldTag
+
+	for _, f := range fields {
+		goName := t.FieldByIndex(f.index).Name
+		if v, ok := specFields[goName]; ok && v != nil {
+			bodySchema.Blocks = append(bodySchema.Blocks, hcl.BlockHeaderSchema{Type: f.name, LabelNames: labelNamesFor(v)})
+			blocks[f.name] = f
+			specBlocks[f.name] = goName
+			continue
+		}
+		fieldType := t.FieldByIndex(f.index).Type
+		switch f.kind {
+		case "attr", "optional":
+			required := f.kind == "attr" && fieldType.Kind() != reflect.Ptr && !fieldType.AssignableTo(exprType)
+			bodySchema.Attributes = append(bodySchema.Attributes, hcl.AttributeSchema{Name: f.name, Required: required})
+			attrs[f.name] = f
+		case "block":
+			bodySchema.Blocks = append(bodySchema.Blocks, hcl.BlockHeaderSchema{Type: f.name, LabelNames: labelNamesOf(fieldType)})
+			blocks[f.name] = f
+		case "label":
+			labelFields = append(labelFields, f)
+		case "remain", "body":
+			if !bodyType.AssignableTo(fieldType) {
+				return errorAt(path, nil, "field %s with %q tag must have type hcl.Body, got %v", goName, f.kind, fieldType)
+			}
+			if f.kind == "remain" {
+				remain = &f
+			} else {
+				whole = &f
+			}
+		}
+	}
+	for _, name := range sortedKeys(specFields) {
+		if field, ok := t.FieldByName(name); !ok || !field.IsExported() {
+			return errorAt(path, nil, "field %q in spec not found in struct %s", name, t.Name())
+		}
+	}
+
+	for i, f := range labelFields {
+		if i < len(labels) {
+			rv.FieldByIndex(f.index).SetString(labels[i])
+		}
+	}
+	if whole != nil {
+		rv.FieldByIndex(whole.index).Set(reflect.ValueOf(body))
+	}
+
+	var content *hcl.BodyContent
+	var diags hcl.Diagnostics
+	if remain != nil {
+		var rest hcl.Body
+		content, rest, diags = body.PartialContent(bodySchema)
+		rv.FieldByIndex(remain.index).Set(reflect.ValueOf(rest))
+	} else {
+		content, diags = body.Content(bodySchema)
+	}
+	if content == nil {
+		return diags
+	}
+
+	for _, name := range sortedKeys(content.Attributes) {
+		attr := content.Attributes[name]
+		field := rv.FieldByIndex(attrs[name].index)
+		if field.Type().AssignableTo(exprType) {
+			field.Set(reflect.ValueOf(attr.Expr))
+			continue
+		}
+		diags = append(diags, gohcl.DecodeExpression(attr.Expr, d.ctx, field.Addr().Interface())...)
+	}
+
+	grouped := make(map[string][]*hcl.Block)
+	for _, block := range content.Blocks {
+		grouped[block.Type] = append(grouped[block.Type], block)
+	}
+	for _, blockType := range sortedKeys(blocks) {
+		f := blocks[blockType]
+		field := rv.FieldByIndex(f.index)
+		if goName, ok := specBlocks[blockType]; ok {
+			diags = append(diags, d.decodeValue(grouped[blockType], field, specFields[goName], path+"."+goName)...)
+			continue
+		}
+		diags = append(diags, d.decodePlainBlocks(grouped[blockType], field, path+"."+t.FieldByIndex(f.index).Name)...)
+	}
+	return diags
+}
+
+// decodeValue decodes the blocks of one spec'd field according to the kind of v.
+func (d *decoder) decodeValue(blocks []*hcl.Block, dst reflect.Value, v *schema.Value, path string) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	switch k := v.GetKind().(type) {
+	case *schema.Value_SingleStruct:
+		if len(blocks) == 0 {
+			return nil
+		}
+		if len(blocks) > 1 {
+			return errorAt(path, &blocks[1].DefRange, "duplicate %q block", blocks[1].Type)
+		}
+		return d.decodeSingle(blocks[0].Body, blocks[0].Labels, dst, k.SingleStruct, path, &blocks[0].DefRange)
+
+	case *schema.Value_ListStruct:
+		if len(blocks) == 0 {
+			return nil
+		}
+		switch dst.Kind() {
+		case reflect.Slice:
+			dst.Set(reflect.MakeSlice(dst.Type(), len(blocks), len(blocks)))
+		case reflect.Array:
+			if len(blocks) > dst.Len() {
+				return errorAt(path, &blocks[dst.Len()].DefRange, "array of length %d cannot hold %d blocks", dst.Len(), len(blocks))
+			}
+		default:
+			return errorAt(path, &blocks[0].DefRange, "ListStruct requires slice or array type, got %v", dst.Kind())
+		}
+		for i, block := range blocks {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			s := k.ListStruct.StructAt(i)
+			if s == nil {
+				return append(diags, errorAt(itemPath, &block.DefRange, "no class specified for list index %d", i)...)
+			}
+			diags = append(diags, d.decodeSingle(block.Body, block.Labels, dst.Index(i), s, itemPath, &block.DefRange)...)
+		}
+		return diags
+
+	case *schema.Value_MapStruct:
+		if len(blocks) == 0 {
+			return nil
+		}
+		t := dst.Type()
+		if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+			return errorAt(path, &blocks[0].DefRange, "MapStruct requires map[string]T type, got %v", t)
+		}
+		// Entries are merged into an existing map, as for Map2Struct and encoding/json.
+		out := dst
+		if out.IsNil() {
+			out = reflect.MakeMapWithSize(t, len(blocks))
+		}
+		for _, block := range blocks {
+			key := block.Labels[0]
+			itemPath := fmt.Sprintf("%s[%q]", path, key)
+			s := k.MapStruct.StructFor(key)
+			if s == nil {
+				return append(diags, errorAt(itemPath, &block.DefRange, "no class specified for map key %q", key)...)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			diags = append(diags, d.decodeSingle(block.Body, block.Labels, elem, s, itemPath, &block.DefRange)...)
+			out.SetMapIndex(reflect.ValueOf(key).Convert(t.Key()), elem)
+		}
+		dst.Set(out)
+		return diags
+
+	case *schema.Value_Map2Struct:
+		if len(blocks) == 0 {
+			return nil
+		}
+		t := dst.Type()
+		nested := t.Kind() == reflect.Map && t.Key().Kind() == reflect.String &&
+			t.Elem().Kind() == reflect.Map && t.Elem().Key().Kind() == reflect.String
+		pair := t.Kind() == reflect.Map && t.Key().Kind() == reflect.Array &&
+			t.Key().Len() == 2 && t.Key().Elem().Kind() == reflect.String
+		if !nested && !pair {
+			return errorAt(path, &blocks[0].DefRange, "Map2Struct requires map[[2]string]T or map[string]map[string]T type, got %v", t)
+		}
+		out := dst
+		if out.IsNil() {
+			out = reflect.MakeMap(t)
+		}
+		for _, block := range blocks {
+			key1, key2 := block.Labels[0], block.Labels[1]
+			itemPath := fmt.Sprintf("%s[%q][%q]", path, key1, key2)
+			s := k.Map2Struct.StructFor(key1, key2)
+			if s == nil {
+				return append(diags, errorAt(itemPath, &block.DefRange, "no class specified for map keys [%q, %q]", key1, key2)...)
+			}
+			if nested {
+				outer := reflect.ValueOf(key1).Convert(t.Key())
+				inner := out.MapIndex(outer)
+				if !inner.IsValid() || inner.IsNil() {
+					inner = reflect.MakeMap(t.Elem())
+					out.SetMapIndex(outer, inner)
+				}
+				elem := reflect.New(t.Elem().Elem()).Elem()
+				diags = append(diags, d.decodeSingle(block.Body, block.Labels, elem, s, itemPath, &block.DefRange)...)
+				inner.SetMapIndex(reflect.ValueOf(key2).Convert(t.Elem().Key()), elem)
+				continue
+			}
+			keyValue := reflect.New(t.Key()).Elem()
+			keyValue.Index(0).SetString(key1)
+			keyValue.Index(1).SetString(key2)
+			elem := reflect.New(t.Elem()).Elem()
+			diags = append(diags, d.decodeSingle(block.Body, block.Labels, elem, s, itemPath, &block.DefRange)...)
+			out.SetMapIndex(keyValue, elem)
+		}
+		dst.Set(out)
+		return diags
+	}
+	return nil
+}
+
+// decodePlainBlocks decodes the blocks of a field that is not in the spec,
+// following gohcl: a struct or pointer field takes one block, a slice takes all.
+func (d *decoder) decodePlainBlocks(blocks []*hcl.Block, dst reflect.Value, path string) hcl.Diagnostics {
+	if len(blocks) == 0 {
+		return nil
+	}
+	if dst.Kind() != reflect.Slice {
+		if len(blocks) > 1 {
+			return errorAt(path, &blocks[1].DefRange, "duplicate %q block", blocks[1].Type)
+		}
+		return d.decodeSingle(blocks[0].Body, blocks[0].Labels, dst, nil, path, &blocks[0].DefRange)
+	}
+	var diags hcl.Diagnostics
+	dst.Set(reflect.MakeSlice(dst.Type(), len(blocks), len(blocks)))
+	for i, block := range blocks {
+		diags = append(diags, d.decodeSingle(block.Body, block.Labels, dst.Index(i), nil, fmt.Sprintf("%s[%d]", path, i), &block.DefRange)...)
+	}
+	return diags
+}
+
+// labelNamesFor returns the block labels implied by the kind of a spec'd field.
+func labelNamesFor(v *schema.Value) []string {
+	switch v.GetKind().(type) {
+	case *schema.Value_MapStruct:
+		return []string{"key"}
+	case *schema.Value_Map2Struct:
+		return []string{"key1", "key2"}
+	default:
+		return nil
+	}
+}
+
+// labelNamesOf returns the label field names of the struct behind a block field type.
+func labelNamesOf(t reflect.Type) []string {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for _, f := range parseFields(t) {
+		if f.kind == "label" {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+func errorAt(path string, rng *hcl.Range, format string, args ...any) hcl.Diagnostics {
+	return hcl.Diagnostics{{
+		Severity: hcl.DiagError,
+		Summary:  path + ": " + fmt.Sprintf(format, args...),
+		Subject:  rng,
+	}}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
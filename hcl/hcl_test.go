@@ -0,0 +1,373 @@
+package hcl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/tabilet/schema"
+)
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct {
+	Radius float64 `hcl:"radius,optional"`
+}
+
+func (c *circle) Area() float64 { return 3.14 * c.Radius * c.Radius }
+
+type square struct {
+	Name string  `hcl:"name,label"`
+	Side float64 `hcl:"side,optional"`
+}
+
+func (s *square) Area() float64 { return s.Side * s.Side }
+
+type frame struct {
+	Color  string  `hcl:"color,optional"`
+	Border shape   `hcl:"border,block"`
+	Layers []shape `hcl:"layer,block"`
+}
+
+func (f *frame) Area() float64 { return 0 }
+
+type meta struct {
+	Kind  string `hcl:"kind,label"`
+	Owner string `hcl:"owner"`
+}
+
+type geo struct {
+	Title    string                      `hcl:"title"`
+	Count    *int                        `hcl:"count"`
+	Primary  shape                       `hcl:"primary,block"`
+	Shapes   []shape                     `hcl:"shape,block"`
+	ByName   map[string]shape            `hcl:"named,block"`
+	Grid     map[[2]string]shape         `hcl:"grid,block"`
+	Nested   map[string]map[string]shape `hcl:"cell,block"`
+	Frame    *frame                      `hcl:"frame,block"`
+	Meta     []meta                      `hcl:"meta,block"`
+	Untagged shape
+	Rest     hcl.Body `hcl:",remain"`
+	internal shape
+}
+
+func newRegistry(t *testing.T) *schema.Registry {
+	t.Helper()
+	reg := schema.NewRegistry()
+	if err := schema.RegisterTo[circle](reg, "Circle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.RegisterTo[square](reg, "Square"); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.RegisterTo[frame](reg, "Frame"); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestUnmarshal(t *testing.T) {
+	reg := newRegistry(t)
+	spec, err := schema.NewStruct("Geo", map[string]any{
+		"Primary":  "Circle",
+		"Shapes":   []string{"Square", "Circle"},
+		"ByName":   map[string]string{"*": "Square", "round": "Circle"},
+		"Grid":     map[[2]string]string{{"r1", "k1"}: "Circle", {"r1", "k2"}: "Square"},
+		"Nested":   map[[2]string]string{{"*", "*"}: "Circle"},
+		"Untagged": "Square",
+		"Frame": [2]any{"Frame", map[string]any{
+			"Border": "Square",
+			"Layers": []string{"Circle"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `
+title = "demo"
+extra = 1
+
+primary {
+  radius = 2
+}
+
+shape {
+  side = 3
+}
+shape {
+  radius = 1
+}
+
+named "box" {
+  side = 4
+}
+named "round" {
+  radius = 5
+}
+
+grid "r1" "k1" {
+  radius = 6
+}
+grid "r1" "k2" {
+  side = 7
+}
+
+cell "a" "b" {
+  radius = 8
+}
+
+Untagged {
+  side = 9
+}
+
+frame {
+  color = "red"
+  border {
+    side = 10
+  }
+  layer {
+    radius = 11
+  }
+  layer {
+    radius = 12
+  }
+}
+
+meta "info" {
+  owner = "me"
+}
+`
+
+	var g geo
+	if err := Unmarshal([]byte(data), &g, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Title != "demo" || g.Count != nil {
+		t.Errorf("Title = %q, Count = %v", g.Title, g.Count)
+	}
+	if c, ok := g.Primary.(*circle); !ok || c.Radius != 2 {
+		t.Errorf("Primary = %#v", g.Primary)
+	}
+	if len(g.Shapes) != 2 {
+		t.Fatalf("Shapes = %#v", g.Shapes)
+	}
+	if s, ok := g.Shapes[0].(*square); !ok || s.Side != 3 {
+		t.Errorf("Shapes[0] = %#v", g.Shapes[0])
+	}
+	if c, ok := g.Shapes[1].(*circle); !ok || c.Radius != 1 {
+		t.Errorf("Shapes[1] = %#v", g.Shapes[1])
+	}
+	if s, ok := g.ByName["box"].(*square); !ok || s.Side != 4 || s.Name != "box" {
+		t.Errorf("ByName[box] = %#v", g.ByName["box"])
+	}
+	if c, ok := g.ByName["round"].(*circle); !ok || c.Radius != 5 {
+		t.Errorf("ByName[round] = %#v", g.ByName["round"])
+	}
+	if c, ok := g.Grid[[2]string{"r1", "k1"}].(*circle); !ok || c.Radius != 6 {
+		t.Errorf("Grid[r1,k1] = %#v", g.Grid[[2]string{"r1", "k1"}])
+	}
+	if s, ok := g.Grid[[2]string{"r1", "k2"}].(*square); !ok || s.Side != 7 || s.Name != "r1" {
+		t.Errorf("Grid[r1,k2] = %#v", g.Grid[[2]string{"r1", "k2"}])
+	}
+	if c, ok := g.Nested["a"]["b"].(*circle); !ok || c.Radius != 8 {
+		t.Errorf("Nested[a][b] = %#v", g.Nested["a"]["b"])
+	}
+	if s, ok := g.Untagged.(*square); !ok || s.Side != 9 {
+		t.Errorf("Untagged = %#v", g.Untagged)
+	}
+	if g.Frame == nil || g.Frame.Color != "red" {
+		t.Fatalf("Frame = %#v", g.Frame)
+	}
+	if s, ok := g.Frame.Border.(*square); !ok || s.Side != 10 {
+		t.Errorf("Frame.Border = %#v", g.Frame.Border)
+	}
+	if len(g.Frame.Layers) != 2 {
+		t.Fatalf("Frame.Layers = %#v", g.Frame.Layers)
+	}
+	if c, ok := g.Frame.Layers[1].(*circle); !ok || c.Radius != 12 {
+		t.Errorf("Frame.Layers[1] = %#v", g.Frame.Layers[1])
+	}
+	if len(g.Meta) != 1 || g.Meta[0].Kind != "info" || g.Meta[0].Owner != "me" {
+		t.Errorf("Meta = %#v", g.Meta)
+	}
+	// hclsyntax reports the hidden blocks here, but still returns the remaining attributes.
+	attrs, _ := g.Rest.JustAttributes()
+	if len(attrs) != 1 || attrs["extra"] == nil {
+		t.Errorf("Rest should hold only the extra attribute, got %v", attrs)
+	}
+}
+
+func TestUnmarshal_Errors(t *testing.T) {
+	reg := newRegistry(t)
+	tests := []struct {
+		name string
+		spec map[string]any
+		data string
+		want string
+	}{
+		{
+			name: "unregistered class",
+			spec: map[string]any{"Primary": "Triangle"},
+			data: "title = \"x\"\nprimary {}\n",
+			want: `Geo.Primary: class not registered: "Triangle"`,
+		},
+		{
+			name: "list index",
+			spec: map[string]any{"Shapes": []string{"Circle", "Hexagon"}},
+			data: "title = \"x\"\nshape {}\nshape {}\n",
+			want: `Geo.Shapes[1]: class not registered: "Hexagon"`,
+		},
+		{
+			name: "map key without class",
+			spec: map[string]any{"ByName": map[string]string{"a": "Circle"}},
+			data: "title = \"x\"\nnamed \"b\" {}\n",
+			want: `Geo.ByName["b"]: no class specified`,
+		},
+		{
+			name: "map2 keys without class",
+			spec: map[string]any{"Grid": map[[2]string]string{{"r1", "k1"}: "Circle"}},
+			data: "title = \"x\"\ngrid \"r1\" \"k9\" {}\n",
+			want: `Geo.Grid["r1"]["k9"]: no class specified`,
+		},
+		{
+			name: "wrong label count",
+			spec: map[string]any{"Grid": map[[2]string]string{{"r1", "k1"}: "Circle"}},
+			data: "title = \"x\"\ngrid \"r1\" {}\n",
+			want: `Missing key2 for grid`,
+		},
+		{
+			name: "duplicate single block",
+			spec: map[string]any{"Primary": "Circle"},
+			data: "title = \"x\"\nprimary {}\nprimary {}\n",
+			want: `Geo.Primary: duplicate "primary" block`,
+		},
+		{
+			name: "nested path",
+			spec: map[string]any{"Frame": [2]any{"Frame", map[string]any{"Layers": []string{"Octagon"}}}},
+			data: "title = \"x\"\nframe {\n  layer {}\n}\n",
+			want: `Geo.Frame.Layers[0]: class not registered`,
+		},
+		{
+			name: "interface block without spec",
+			spec: map[string]any{},
+			data: "title = \"x\"\nprimary {}\n",
+			want: `Geo.Primary: no class specified`,
+		},
+		{
+			name: "missing Go field",
+			spec: map[string]any{"Missing": "Circle"},
+			data: "title = \"x\"\n",
+			want: `field "Missing" in spec not found`,
+		},
+		{
+			name: "unexported Go field",
+			spec: map[string]any{"internal": "Circle"},
+			data: "title = \"x\"\n",
+			want: `Geo: field "internal" in spec not found in struct geo`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := schema.NewStruct("Geo", tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var g geo
+			err = Unmarshal([]byte(tt.data), &g, spec, reg)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshal_MergeMaps(t *testing.T) {
+	reg := newRegistry(t)
+	spec, err := schema.NewStruct("Geo", map[string]any{
+		"ByName": map[string]string{"*": "Circle"},
+		"Grid":   map[[2]string]string{{"*", "*"}: "Circle"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := geo{
+		ByName: map[string]shape{"old": &square{Side: 1}},
+		Grid:   map[[2]string]shape{{"r0", "k0"}: &square{Side: 1}},
+	}
+	data := "title = \"x\"\nnamed \"new\" {}\ngrid \"r1\" \"k1\" {}\n"
+	if err := Unmarshal([]byte(data), &g, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.ByName) != 2 || g.ByName["old"] == nil || g.ByName["new"] == nil {
+		t.Errorf("ByName = %v, want old and new entries", g.ByName)
+	}
+	if len(g.Grid) != 2 || g.Grid[[2]string{"r0", "k0"}] == nil || g.Grid[[2]string{"r1", "k1"}] == nil {
+		t.Errorf("Grid = %v, want old and new entries", g.Grid)
+	}
+}
+
+type labeled struct {
+	Kind  string  `hcl:"kind"`
+	Scale float64 `hcl:"scale,optional"`
+}
+
+func (l *labeled) Area() float64 { return l.Scale }
+
+func TestUnmarshal_Choices(t *testing.T) {
+	reg := newRegistry(t)
+	if err := schema.RegisterTo[labeled](reg, "Labeled"); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := schema.NewStruct("Geo", map[string]any{"Shapes": []string{"Circle"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Fields["Shapes"].GetListStruct().ListFields[0] = &schema.Struct{
+		Discriminator: "kind",
+		Choices: map[string]*schema.Struct{
+			"circle":  {ClassName: "Circle"},
+			"square":  {ClassName: "Square"},
+			"labeled": {ClassName: "Labeled"},
+		},
+	}
+	data := `title = "x"
+shape {
+  kind   = "circle"
+  radius = 2
+}
+shape {
+  kind = "labeled"
+  scale = 3
+}
+`
+	var g geo
+	if err := Unmarshal([]byte(data), &g, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := g.Shapes[0].(*circle); !ok || c.Radius != 2 {
+		t.Errorf("Shapes[0] = %#v", g.Shapes[0])
+	}
+	// A class declaring the discriminator attribute receives it.
+	if l, ok := g.Shapes[1].(*labeled); !ok || l.Kind != "labeled" || l.Scale != 3 {
+		t.Errorf("Shapes[1] = %#v", g.Shapes[1])
+	}
+
+	for data, want := range map[string]string{
+		"title = \"x\"\nshape {\n  kind = \"hexagon\"\n}\n": `Geo.Shapes[0]: no choice for kind "hexagon"`,
+		"title = \"x\"\nshape {\n  radius = 1\n}\n":         `Missing required argument`,
+	} {
+		var g geo
+		err := Unmarshal([]byte(data), &g, spec, reg)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %s", err, want)
+		}
+	}
+}
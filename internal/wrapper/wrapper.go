@@ -0,0 +1,11 @@
+// Package wrapper holds the names of the Struct that stands for a nested list or
+// map Value, such as the inner list of a list of lists, where a ListStruct or
+// MapStruct entry must be a Struct. The schema package and its subpackages share
+// them; they are not part of the public API.
+package wrapper
+
+const (
+	ClassName   = "__schema_wrapper__"
+	FieldName   = "__schema_value__"
+	ServiceName = "__schema_wrapper_service__"
+)
@@ -3,6 +3,11 @@ package schema
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tabilet/schema/internal/wrapper"
 )
 
 // jsonSchema represents a subset of JSON Schema for parsing.
@@ -14,12 +19,58 @@ type jsonSchema struct {
 	Ref                  string                 `json:"$ref,omitempty"`
 	ServiceName          string                 `json:"serviceName,omitempty"`
 	XMap2                bool                   `json:"x-map2,omitempty"`
+	XMap                 bool                   `json:"x-map,omitempty"`
+	PrefixItems          []*jsonSchema          `json:"prefixItems,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Const                json.RawMessage        `json:"const,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	AllOf                []*jsonSchema          `json:"allOf,omitempty"`
+	Discriminator        *jsonDiscriminator     `json:"discriminator,omitempty"`
+	Type                 json.RawMessage        `json:"type,omitempty"`
+	XServiceName         string                 `json:"x-service-name,omitempty"`
+	// never marks the boolean schema false, which matches nothing.
+	never bool
+}
+
+// UnmarshalJSON accepts the boolean schemas true, which is the empty schema,
+// and false.
+func (js *jsonSchema) UnmarshalJSON(data []byte) error {
+	switch strings.TrimSpace(string(data)) {
+	case "true":
+		*js = jsonSchema{}
+		return nil
+	case "false":
+		*js = jsonSchema{never: true}
+		return nil
+	}
+	type plain jsonSchema
+	return json.Unmarshal(data, (*plain)(js))
+}
+
+// jsonDiscriminator is an OpenAPI-style discriminator object.
+type jsonDiscriminator struct {
+	PropertyName string            `json:"propertyName,omitempty"`
+	Mapping      map[string]string `json:"mapping,omitempty"`
+}
+
+// JSMOptions configures JSMServiceStructWithOptions.
+type JSMOptions struct {
+	// Choices translates oneOf and anyOf into class choices: the Struct of such a
+	// schema records every branch in Choices, keyed by its discriminator value, and
+	// the discriminator property in Discriminator. Without it, JSMServiceStruct and
+	// JSMStruct ignore oneOf and anyOf as before. Struct.UnmarshalJSON and
+	// Value.UnmarshalJSON always read them, so that the Choices written by
+	// MarshalJSON round-trip.
+	Choices bool
 }
 
 const (
-	wrapperClassName   = "__schema_wrapper__"
-	wrapperFieldName   = "__schema_value__"
-	wrapperServiceName = "__schema_wrapper_service__"
+	wrapperClassName   = wrapper.ClassName
+	wrapperFieldName   = wrapper.FieldName
+	wrapperServiceName = wrapper.ServiceName
 )
 
 func wrapValueAsStruct(v *Value) *Struct {
@@ -59,6 +110,8 @@ func unwrapValueFromStruct(s *Struct) (*Value, bool) {
 //   - className: array (optional), items -> ListStruct
 //   - className: object (optional), additionalProperties -> MapStruct
 //   - custom className (MyClass) -> SingleStruct with ClassName = MyClass
+//   - $ref -> the schema it points to, e.g. "#/definitions/X", "#/$defs/X" or any
+//     JSON Pointer into the document; repeated references share one *Struct
 //
 // Arguments:
 //   - className: The name of the root object.
@@ -76,8 +129,33 @@ func unwrapValueFromStruct(s *Struct) (*Value, bool) {
 //	║ {"className": "array", "items": {"className": "Class2", "properties": {...}}}                        │ ListStruct       │ n/a         │ n/a                        ║
 //	║ {"className": "object", "additionalProperties": {"className": "Class3", "properties": {...}}}        │ MapStruct        │ n/a         │ n/a                        ║
 //	║ {"className": "object", "x-map2": true, "properties": {"r1": {"properties": {"k1": T}}}}             │ Map2Struct       │ n/a         │ n/a                        ║
+//	║ {"prefixItems": [{"className": "HTTPServer"}, {"className": "GRPCServer"}]}                          │ ListStruct       │ n/a         │ n/a                        ║
+//	║ {"x-map": true, "properties": {"api": {"className": "A"}}, "additionalProperties": {...}}            │ MapStruct        │ n/a         │ n/a                        ║
 //	╚══════════════════════════════════════════════════════════════════════════════════════════════════════╧══════════════════╧═════════════╧════════════════════════════╝
 func JSMServiceStruct(className, jsonSchemaStr string) (*Struct, error) {
+	return JSMServiceStructWithOptions(className, jsonSchemaStr, nil)
+}
+
+// JSMServiceStructWithOptions creates a Struct from a JSON Schema string like
+// JSMServiceStruct, with the import modes given in opts.
+//
+// With opts.Choices, a field whose schema is a oneOf (or anyOf) of object schemas
+// becomes a Struct with Choices. The key of each branch is, in order of preference:
+//   - the key of its $ref in discriminator.mapping
+//   - the const value of the discriminator property in the branch
+//   - the class name of the branch
+//
+// The discriminator property is discriminator.propertyName, or else the only
+// property holding a const string in every branch; it is removed from the Fields
+// of the branches. The class name of a branch is its className, else its title,
+// else the last segment of its $ref:
+//
+//	{"oneOf": [
+//	    {"title": "Circle", "properties": {"type": {"const": "circle"}}},
+//	    {"title": "Square", "properties": {"type": {"const": "square"}}}
+//	]}
+//	// Struct{Discriminator: "type", Choices: {"circle": {ClassName: "Circle"}, "square": {ClassName: "Square"}}}
+func JSMServiceStructWithOptions(className, jsonSchemaStr string, opts *JSMOptions) (*Struct, error) {
 	if className == "" {
 		return nil, fmt.Errorf("className cannot be empty")
 	}
@@ -87,7 +165,7 @@ func JSMServiceStruct(className, jsonSchemaStr string) (*Struct, error) {
 		return nil, fmt.Errorf("failed to parse JSON Schema: %w", err)
 	}
 
-	value, err := convertSchemaToValue(&schema)
+	value, err := convertSchemaToValue(&schema, opts != nil && opts.Choices)
 	if err != nil {
 		return nil, err
 	}
@@ -131,26 +209,137 @@ func JSMStruct(className, jsonSchemaStr string) (*Struct, error) {
 	return DeriveStructWithoutServices(s), nil
 }
 
-func convertSchemaToValue(js *jsonSchema) (*Value, error) {
+// JSMServiceValue creates a Value from a JSON Schema string like JSMServiceStruct,
+// for a spec of any kind: an object schema gives a SingleStruct, and the top-level
+// list, map and two-level map schemas give a ListStruct, MapStruct and Map2Struct:
+//
+//	JSMServiceValue(`{"items": {"className": "Circle", "serviceName": "s1"}}`)
+//	// ListStruct{ListFields: [{ClassName: "Circle", ServiceName: "s1"}]}
+//	JSMServiceValue(`{"x-map2": true, "properties": {"r1": {"properties": {"k1": {"className": "Cell"}}}}}`)
+//	// Map2Struct{Map2Fields: {"r1": {MapFields: {"k1": {ClassName: "Cell"}}}}}
+//
+// A schema that describes no class, such as a list of primitives, is an error.
+func JSMServiceValue(jsonSchemaStr string) (*Value, error) {
+	var schema jsonSchema
+	if err := json.Unmarshal([]byte(jsonSchemaStr), &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON Schema: %w", err)
+	}
+	value, err := convertSchemaToValue(&schema, false)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("JSON schema describes no class")
+	}
+	return value, nil
+}
+
+// JSMValue creates a Value from a JSON Schema string like JSMServiceValue,
+// stripping all service names from the result.
+func JSMValue(jsonSchemaStr string) (*Value, error) {
+	v, err := JSMServiceValue(jsonSchemaStr)
+	if err != nil {
+		return nil, err
+	}
+	return deriveValueWithoutServices(v, make(map[*Struct]*Struct)), nil
+}
+
+func convertSchemaToValue(js *jsonSchema, choices bool) (*Value, error) {
+	return newSchemaConverter(js, choices).toValue(js)
+}
+
+func newSchemaConverter(root *jsonSchema, choices bool) *schemaConverter {
+	return &schemaConverter{
+		root:      root,
+		choices:   choices,
+		converted: make(map[*jsonSchema]*Value),
+		resolving: make(map[*jsonSchema]bool),
+	}
+}
+
+// schemaConverter converts a parsed JSON Schema document into Values,
+// resolving local $ref pointers against the document root.
+type schemaConverter struct {
+	root *jsonSchema
+	// choices translates oneOf and anyOf into class choices.
+	choices bool
+	// openAPI drops schemas that name no class, such as {"type": "string"},
+	// and resolves "#/components/schemas/X" against components.
+	openAPI    bool
+	components map[string]*jsonSchema
+	// converted caches the Value of every schema, so that repeated
+	// references share the same *Struct.
+	converted map[*jsonSchema]*Value
+	resolving map[*jsonSchema]bool
+	// failed is the innermost schema whose conversion failed, for the
+	// position of the error in the source document.
+	failed *jsonSchema
+}
+
+// toValue converts js, reusing the Value of a schema already converted.
+func (c *schemaConverter) toValue(js *jsonSchema) (*Value, error) {
 	if js == nil {
 		return nil, fmt.Errorf("nil schema")
 	}
+	if v, ok := c.converted[js]; ok {
+		return v, nil
+	}
+	v, err := c.convert(js)
+	if err != nil {
+		if c.failed == nil {
+			c.failed = js
+		}
+		return nil, err
+	}
+	c.converted[js] = v
+	return v, nil
+}
+
+func (c *schemaConverter) convert(js *jsonSchema) (*Value, error) {
+	// A $ref replaces the schema it appears in, as in Draft 7.
+	if js.Ref != "" {
+		return c.resolveRef(js.Ref)
+	}
+
+	// oneOf and anyOf list the classes a polymorphic field may hold, and
+	// so does a discriminator mapping on its own, as on an OpenAPI base schema.
+	if c.choices && (len(js.OneOf) > 0 || len(js.AnyOf) > 0 || (js.Discriminator != nil && len(js.Discriminator.Mapping) > 0)) {
+		return c.choiceValue(js)
+	}
 
 	// 0. If "x-map2" is true, it is a Map2Struct.
 	// It relies on 2-layer Properties: Region -> Key -> Service
 	if js.XMap2 {
-		if js.Properties == nil {
-			return nil, fmt.Errorf("x-map2 requires properties")
-		}
 		map2Fields := make(map[string]*MapStruct)
-		for regionKey, regionSchema := range js.Properties {
+		regions := js.Properties
+		// "additionalProperties" holds the "*" region, and within a region
+		// the "*" key, as written by ToOpenAPIComponents.
+		if ap := js.AdditionalProperties; ap != nil && !ap.never {
+			regions = make(map[string]*jsonSchema, len(js.Properties)+1)
+			for key, region := range js.Properties {
+				regions[key] = region
+			}
+			regions["*"] = ap
+		}
+		if len(regions) == 0 {
+			return &Value{Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map2Fields}}}, nil
+		}
+		for regionKey, regionSchema := range regions {
+			inner := regionSchema.Properties
+			if ap := regionSchema.AdditionalProperties; ap != nil && !ap.never {
+				inner = make(map[string]*jsonSchema, len(regionSchema.Properties)+1)
+				for key, schema := range regionSchema.Properties {
+					inner[key] = schema
+				}
+				inner["*"] = ap
+			}
 			// regionSchema should have properties for the inner map
-			if regionSchema.Properties == nil {
+			if inner == nil {
 				return nil, fmt.Errorf("x-map2 region %q missing properties", regionKey)
 			}
 			innerMapFields := make(map[string]*Struct)
-			for innerKey, innerSchema := range regionSchema.Properties {
-				val, err := convertSchemaToValue(innerSchema)
+			for innerKey, innerSchema := range inner {
+				val, err := c.toValue(innerSchema)
 				if err != nil {
 					return nil, fmt.Errorf("in x-map2 region %q key %q: %w", regionKey, innerKey, err)
 				}
@@ -169,11 +358,44 @@ func convertSchemaToValue(js *jsonSchema) (*Value, error) {
 		return &Value{Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map2Fields}}}, nil
 	}
 
+	// 0b. If "x-map" is true, it is a keyed MapStruct: "properties" holds the
+	// explicit keys and "additionalProperties" the "*" entry.
+	if js.XMap {
+		mapFields := make(map[string]*Struct)
+		for key, prop := range js.Properties {
+			val, err := c.toValue(prop)
+			if err != nil {
+				return nil, fmt.Errorf("in x-map key %q: %w", key, err)
+			}
+			if val != nil {
+				mapFields[key] = extractStructFromValue(val)
+			}
+		}
+		if js.AdditionalProperties != nil && !js.AdditionalProperties.never {
+			val, err := c.toValue(js.AdditionalProperties)
+			if err != nil {
+				return nil, fmt.Errorf("in additionalProperties: %w", err)
+			}
+			if val != nil {
+				mapFields["*"] = extractStructFromValue(val)
+			}
+		}
+		return &Value{Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: mapFields}}}, nil
+	}
+
 	// 1. If "properties" is present, it is a Struct (SingleStruct).
 	if js.Properties != nil {
 		fields := make(map[string]*Value)
+		s := &Struct{Fields: fields, ServiceName: js.ServiceName}
+		if js.ClassName != "" {
+			s.ClassName = js.ClassName
+		}
+		// Cache the Struct before its properties, so that a $ref cycling
+		// back to it resolves to the same *Struct.
+		v := &Value{Kind: &Value_SingleStruct{SingleStruct: s}}
+		c.converted[js] = v
 		for name, prop := range js.Properties {
-			val, err := convertSchemaToValue(prop)
+			val, err := c.toValue(prop)
 			if err != nil {
 				return nil, fmt.Errorf("in property %q: %w", name, err)
 			}
@@ -181,18 +403,14 @@ func convertSchemaToValue(js *jsonSchema) (*Value, error) {
 				fields[name] = val
 			}
 		}
-		s := &Struct{Fields: fields, ServiceName: js.ServiceName}
-		if js.ClassName != "" {
-			s.ClassName = js.ClassName
-		}
-		return &Value{Kind: &Value_SingleStruct{SingleStruct: s}}, nil
+		return v, nil
 	}
 
 	// 2. If "additionalProperties" is present, it is a MapStruct.
-	if js.AdditionalProperties != nil {
-		val, err := convertSchemaToValue(js.AdditionalProperties)
+	if js.AdditionalProperties != nil && !js.AdditionalProperties.never {
+		val, err := c.toValue(js.AdditionalProperties)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("in additionalProperties: %w", err)
 		}
 		if val == nil {
 			return nil, nil // Ignore Map of primitives
@@ -201,11 +419,27 @@ func convertSchemaToValue(js *jsonSchema) (*Value, error) {
 		return &Value{Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"*": targetStruct}}}}, nil
 	}
 
-	// 3. If "items" is present, it is a ListStruct (Array).
-	if js.Items != nil {
-		itemVal, err := convertSchemaToValue(js.Items)
+	// 3. If "prefixItems" is present, it is a positional ListStruct.
+	if js.PrefixItems != nil {
+		listFields := make([]*Struct, len(js.PrefixItems))
+		for i, item := range js.PrefixItems {
+			val, err := c.toValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("in prefixItems[%d]: %w", i, err)
+			}
+			if val == nil {
+				return nil, nil // Ignore List of primitives
+			}
+			listFields[i] = extractStructFromValue(val)
+		}
+		return &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: listFields}}}, nil
+	}
+
+	// 4. If "items" is present, it is a ListStruct (Array).
+	if js.Items != nil && !js.Items.never {
+		itemVal, err := c.toValue(js.Items)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("in items: %w", err)
 		}
 		if itemVal == nil {
 			return nil, nil // Ignore List of primitives
@@ -214,12 +448,109 @@ func convertSchemaToValue(js *jsonSchema) (*Value, error) {
 		return &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{itemStruct}}}}, nil
 	}
 
-	// 4. Custom Class / Leaf
+	// 5. Custom Class / Leaf
 	// Treating as CUSTOM CLASS (opaque class with ClassName = type)
 	// This captures "MyType".
+	if c.openAPI && js.ClassName == "" && js.ServiceName == "" {
+		return nil, nil // Ignore data schemas without a class
+	}
 	return &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: js.ClassName, ServiceName: js.ServiceName}}}, nil
 }
 
+// resolveRef converts the schema a local $ref points to. Supported references
+// are "#" and JSON Pointer fragments such as "#/definitions/X", "#/$defs/X" or
+// "#/properties/a/items".
+func (c *schemaConverter) resolveRef(ref string) (*Value, error) {
+	target, err := c.lookupRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve $ref %q: %w", ref, err)
+	}
+	if v, ok := c.converted[target]; ok {
+		return v, nil
+	}
+	if c.resolving[target] {
+		// Only a cycle through a Struct can be represented.
+		return nil, fmt.Errorf("cyclic $ref %q does not pass through an object with properties", ref)
+	}
+	c.resolving[target] = true
+	defer delete(c.resolving, target)
+
+	v, err := c.toValue(target)
+	if err != nil {
+		return nil, fmt.Errorf("in $ref %q: %w", ref, err)
+	}
+	return v, nil
+}
+
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// lookupRef finds the schema a local $ref points to.
+func (c *schemaConverter) lookupRef(ref string) (*jsonSchema, error) {
+	fragment, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("only local references starting with # are supported")
+	}
+	fragment, err := url.PathUnescape(fragment)
+	if err != nil {
+		return nil, err
+	}
+	if fragment == "" {
+		return c.root, nil
+	}
+	if !strings.HasPrefix(fragment, "/") {
+		return nil, fmt.Errorf("fragment must be a JSON Pointer")
+	}
+
+	tokens := strings.Split(fragment[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = pointerUnescaper.Replace(token)
+	}
+	js := c.root
+	for i := 0; i < len(tokens); i++ {
+		var next *jsonSchema
+		member := func(m map[string]*jsonSchema) *jsonSchema {
+			if i+1 >= len(tokens) {
+				return nil
+			}
+			i++
+			return m[tokens[i]]
+		}
+		switch tokens[i] {
+		case "definitions":
+			next = member(js.Definitions)
+		case "$defs":
+			next = member(js.Defs)
+		case "properties":
+			next = member(js.Properties)
+		case "components":
+			if js == c.root && c.components != nil && i+2 < len(tokens) && tokens[i+1] == "schemas" {
+				i += 2
+				next = c.components[tokens[i]]
+			}
+		case "prefixItems", "oneOf", "anyOf":
+			list := map[string][]*jsonSchema{"prefixItems": js.PrefixItems, "oneOf": js.OneOf, "anyOf": js.AnyOf}[tokens[i]]
+			if i+1 < len(tokens) {
+				i++
+				if n, err := strconv.Atoi(tokens[i]); err == nil && n >= 0 && n < len(list) {
+					next = list[n]
+				}
+			}
+		case "items":
+			next = js.Items
+		case "additionalProperties":
+			next = js.AdditionalProperties
+		}
+		if next == nil {
+			return nil, fmt.Errorf("no schema at /%s", strings.Join(tokens[:i+1], "/"))
+		}
+		js = next
+	}
+	return js, nil
+}
+
 // extractStructFromValue attempts to get a Struct from a Value.
 // If Value is not a SingleStruct, it wraps it or creates a dummy Struct.
 func extractStructFromValue(v *Value) *Struct {
@@ -241,23 +572,33 @@ func (s *Struct) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
-// It parses the Genelet JSON Schema format into the Struct.
+// It parses the Genelet JSON Schema format into the Struct. Unlike JSMServiceStruct,
+// it always reads oneOf and anyOf as Choices, as with JSMOptions.Choices, since
+// MarshalJSON writes Choices that way.
 func (s *Struct) UnmarshalJSON(data []byte) error {
 	var js jsonSchema
 	if err := json.Unmarshal(data, &js); err != nil {
 		return err
 	}
 
-	val, err := convertSchemaToValue(&js)
+	val, err := convertSchemaToValue(&js, true)
 	if err != nil {
 		return err
 	}
+	s.setSchema(&js, val)
+	return nil
+}
+
+// setSchema sets s to val, the converted Value of the document js.
+func (s *Struct) setSchema(js *jsonSchema, val *Value) {
 	if val == nil {
 		// Empty struct if primitive/ignored
 		s.ClassName = js.ClassName
 		s.ServiceName = js.ServiceName
 		s.Fields = nil
-		return nil
+		s.Discriminator = ""
+		s.Choices = nil
+		return
 	}
 
 	// Extract the single struct from the value
@@ -265,6 +606,10 @@ func (s *Struct) UnmarshalJSON(data []byte) error {
 	s.ClassName = extracted.ClassName
 	s.ServiceName = extracted.ServiceName
 	s.Fields = extracted.Fields
+	s.Discriminator = extracted.Discriminator
+	s.Choices = extracted.Choices
+	// References back to the root ("$ref": "#") must point to s itself.
+	relinkStruct(s, extracted, s)
 
 	// If the top-level schema had a class name, ensure it's preserved
 	// (extractStructFromValue might return a wrapper or missing name if it came from non-SingleStruct)
@@ -275,16 +620,244 @@ func (s *Struct) UnmarshalJSON(data []byte) error {
 	if js.ServiceName != "" {
 		s.ServiceName = js.ServiceName
 	}
+}
 
+// MarshalJSON implements the json.Marshaler interface. The Value is written as
+// the schema of a field holding it, e.g. {"items": {"className": "Circle"}} for a
+// ListStruct, with shared and cyclic Structs under "definitions" as in
+// Struct.MarshalJSON.
+func (v *Value) MarshalJSON() ([]byte, error) {
+	js, err := convertValueToSchema(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(schemaToJSONValue(js))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for the schemas
+// written by Value.MarshalJSON. oneOf and anyOf are read as Choices, as in
+// Struct.UnmarshalJSON.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var js jsonSchema
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	val, err := convertSchemaToValue(&js, true)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return fmt.Errorf("JSON schema describes no class")
+	}
+	v.Kind = val.Kind
 	return nil
 }
 
+// relinkStruct replaces every reference to from by to in the graph reachable from root.
+func relinkStruct(root, from, to *Struct) {
+	seen := make(map[*Struct]bool)
+	var walk func(x *Struct) *Struct
+	walk = func(x *Struct) *Struct {
+		if x == from {
+			x = to
+		}
+		if x == nil || seen[x] {
+			return x
+		}
+		seen[x] = true
+		for _, v := range x.Fields {
+			switch k := v.GetKind().(type) {
+			case *Value_SingleStruct:
+				k.SingleStruct = walk(k.SingleStruct)
+			case *Value_ListStruct:
+				for i, y := range k.ListStruct.GetListFields() {
+					k.ListStruct.ListFields[i] = walk(y)
+				}
+			case *Value_MapStruct:
+				for key, y := range k.MapStruct.GetMapFields() {
+					k.MapStruct.MapFields[key] = walk(y)
+				}
+			case *Value_Map2Struct:
+				for _, ms := range k.Map2Struct.GetMap2Fields() {
+					for key, y := range ms.GetMapFields() {
+						ms.MapFields[key] = walk(y)
+					}
+				}
+			}
+		}
+		for key, y := range x.Choices {
+			x.Choices[key] = walk(y)
+		}
+		return x
+	}
+	walk(root)
+}
+
+// convertStructToSchema converts the Struct graph rooted at s into a JSON Schema
+// document. A Struct reached more than once, through sharing or a cycle, is written
+// once under "definitions" and referenced with "$ref"; references back to the root
+// are written as {"$ref": "#"}.
 func convertStructToSchema(s *Struct) (*jsonSchema, error) {
 	if s == nil {
 		return nil, nil
 	}
+	e := &schemaExporter{
+		root:        s,
+		refs:        make(map[*Struct]int),
+		names:       make(map[*Struct]string),
+		definitions: make(map[string]*jsonSchema),
+	}
+	var order []*Struct
+	e.count(s, &order)
+	e.nameShared(order[1:])
+
+	js, err := e.structSchema(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.definitions) > 0 {
+		js.Definitions = e.definitions
+	}
+	return js, nil
+}
+
+// convertValueToSchema converts v into a JSON Schema document like
+// convertStructToSchema, with shared Structs under "definitions".
+func convertValueToSchema(v *Value) (*jsonSchema, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if s := v.GetSingleStruct(); s != nil {
+		return convertStructToSchema(s)
+	}
+	e := &schemaExporter{
+		refs:        make(map[*Struct]int),
+		names:       make(map[*Struct]string),
+		definitions: make(map[string]*jsonSchema),
+	}
+	// Count from a holder of v, which no Struct in the graph refers to.
+	var order []*Struct
+	e.count(&Struct{Fields: map[string]*Value{"": v}}, &order)
+	e.nameShared(order[1:])
+
+	js, err := e.valueSchema(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.definitions) > 0 {
+		js.Definitions = e.definitions
+	}
+	return js, nil
+}
+
+// schemaExporter converts a possibly cyclic Struct graph into JSON Schema.
+type schemaExporter struct {
+	root *Struct
+	// refs counts the references to each Struct from within the graph.
+	refs map[*Struct]int
+	// names holds the definition name of every Struct reached more than once.
+	names       map[*Struct]string
+	definitions map[string]*jsonSchema
+}
+
+// count counts the references to every Struct reachable from s, appending
+// each Struct to order on its first visit. Keys are visited in sorted order,
+// so that definition names are deterministic.
+func (e *schemaExporter) count(s *Struct, order *[]*Struct) {
+	*order = append(*order, s)
+	visit := func(x *Struct) {
+		if x == nil {
+			return
+		}
+		e.refs[x]++
+		if e.refs[x] == 1 && x != e.root {
+			e.count(x, order)
+		}
+	}
+	for _, name := range sortedKeys(s.Fields) {
+		switch k := s.Fields[name].GetKind().(type) {
+		case *Value_SingleStruct:
+			visit(k.SingleStruct)
+		case *Value_ListStruct:
+			for _, x := range k.ListStruct.GetListFields() {
+				visit(x)
+			}
+		case *Value_MapStruct:
+			fields := k.MapStruct.GetMapFields()
+			for _, key := range sortedKeys(fields) {
+				visit(fields[key])
+			}
+		case *Value_Map2Struct:
+			fields := k.Map2Struct.GetMap2Fields()
+			for _, key1 := range sortedKeys(fields) {
+				inner := fields[key1].GetMapFields()
+				for _, key2 := range sortedKeys(inner) {
+					visit(inner[key2])
+				}
+			}
+		}
+	}
+	for _, key := range sortedKeys(s.Choices) {
+		x := s.Choices[key]
+		visit(x)
+		// Without a discriminator, a choice keyed by other than its class name
+		// can only be told apart through the mapping, which needs a $ref.
+		if x != nil && s.Discriminator == "" && key != x.ClassName {
+			e.refs[x]++
+		}
+	}
+}
+
+// nameShared names the definition of every Struct in order reached more
+// than once, after its class name.
+func (e *schemaExporter) nameShared(order []*Struct) {
+	used := make(map[string]bool)
+	for _, x := range order {
+		if x == e.root || e.refs[x] < 2 {
+			continue
+		}
+		base := x.ClassName
+		if base == "" {
+			base = "Struct"
+		}
+		name := base
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[name] = true
+		e.names[x] = name
+	}
+}
+
+// structRef returns the schema of a Struct referenced from a Value: a $ref for
+// the root or a shared Struct, otherwise the Struct itself.
+func (e *schemaExporter) structRef(s *Struct) (*jsonSchema, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if s == e.root {
+		return &jsonSchema{Ref: "#"}, nil
+	}
+	name, ok := e.names[s]
+	if !ok {
+		return e.structSchema(s)
+	}
+	if _, done := e.definitions[name]; !done {
+		// Reserve the name first, so that a cycle back to s ends in a $ref.
+		e.definitions[name] = nil
+		js, err := e.structSchema(s)
+		if err != nil {
+			return nil, err
+		}
+		e.definitions[name] = js
+	}
+	return &jsonSchema{Ref: "#/definitions/" + url.PathEscape(pointerEscaper.Replace(name))}, nil
+}
+
+// structSchema returns the schema of s itself.
+func (e *schemaExporter) structSchema(s *Struct) (*jsonSchema, error) {
 	if v, ok := unwrapValueFromStruct(s); ok {
-		return convertValueToSchema(v)
+		return e.valueSchema(v)
 	}
 	js := &jsonSchema{
 		ClassName:   s.ClassName,
@@ -294,7 +867,7 @@ func convertStructToSchema(s *Struct) (*jsonSchema, error) {
 	if len(s.Fields) > 0 {
 		js.Properties = make(map[string]*jsonSchema)
 		for name, val := range s.Fields {
-			propJs, err := convertValueToSchema(val)
+			propJs, err := e.valueSchema(val)
 			if err != nil {
 				return nil, fmt.Errorf("field %q: %w", name, err)
 			}
@@ -303,59 +876,70 @@ func convertStructToSchema(s *Struct) (*jsonSchema, error) {
 			}
 		}
 	}
+	if len(s.Choices) > 0 {
+		branches, d, err := e.choiceSchemas(s)
+		if err != nil {
+			return nil, err
+		}
+		js.OneOf, js.Discriminator = branches, d
+	}
 	return js, nil
 }
 
-func convertValueToSchema(v *Value) (*jsonSchema, error) {
+func (e *schemaExporter) valueSchema(v *Value) (*jsonSchema, error) {
 	if v == nil {
 		return nil, nil
 	}
-
 	switch k := v.Kind.(type) {
 	case *Value_SingleStruct:
-		return convertStructToSchema(k.SingleStruct)
+		return e.structRef(k.SingleStruct)
 
 	case *Value_ListStruct:
 		// ListStruct: items -> schema of first element
+		// A single entry describes every element and is written as items;
+		// otherwise entries are positional and written as prefixItems.
 		ls := k.ListStruct
-		if len(ls.ListFields) == 0 {
-			// Empty list, cannot determine item schema.
-			// Return empty schema or error?
-			// Return schema with empty items to indicate array type but unknown element.
-			return &jsonSchema{Items: &jsonSchema{}}, nil
+		if len(ls.GetListFields()) == 1 {
+			itemJs, err := e.structRef(ls.ListFields[0])
+			if err != nil {
+				return nil, err
+			}
+			return &jsonSchema{Items: itemJs}, nil
 		}
-		itemJs, err := convertStructToSchema(ls.ListFields[0])
-		if err != nil {
-			return nil, err
+		prefix := make([]*jsonSchema, len(ls.GetListFields()))
+		for i, item := range ls.GetListFields() {
+			itemJs, err := e.structRef(item)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			prefix[i] = itemJs
 		}
-		return &jsonSchema{Items: itemJs}, nil
+		return &jsonSchema{PrefixItems: prefix}, nil
 
 	case *Value_MapStruct:
-		// MapStruct: additionalProperties -> schema of "*" element
+		// MapStruct: a "*" entry alone is written as additionalProperties;
+		// explicit keys need x-map, with the "*" entry as additionalProperties.
 		ms := k.MapStruct
-		if len(ms.MapFields) == 0 {
-			return &jsonSchema{AdditionalProperties: &jsonSchema{}}, nil
-		}
-		// Look for "*" key specifically? Or just pick one?
-		// JSMServiceStruct uses "*" as the key for values.
-		// If constructed manually, might be specific keys.
-		// We maintain the "MapStruct = Map of T" semantic.
-		// If "*" exists, use it. Else pick any (assuming homogeneity).
-		var target *Struct
-		if s, ok := ms.MapFields["*"]; ok {
-			target = s
-		} else {
-			// iter and pick first
-			for _, s := range ms.MapFields {
-				target = s
-				break
+		js := &jsonSchema{}
+		for key, target := range ms.GetMapFields() {
+			valJs, err := e.structRef(target)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			if key == "*" {
+				js.AdditionalProperties = valJs
+				continue
+			}
+			js.XMap = true
+			if js.Properties == nil {
+				js.Properties = make(map[string]*jsonSchema)
 			}
+			js.Properties[key] = valJs
 		}
-		valJs, err := convertStructToSchema(target)
-		if err != nil {
-			return nil, err
+		if js.AdditionalProperties == nil {
+			js.XMap = true
 		}
-		return &jsonSchema{AdditionalProperties: valJs}, nil
+		return js, nil
 
 	case *Value_Map2Struct:
 		// Map2Struct: x-map2: true, Nested properties
@@ -366,7 +950,7 @@ func convertValueToSchema(v *Value) (*jsonSchema, error) {
 			// This MapStruct contains the inner keys -> Structs
 			innerProps := make(map[string]*jsonSchema)
 			for innerKey, innerStruct := range mapStruct.MapFields {
-				innerJs, err := convertStructToSchema(innerStruct)
+				innerJs, err := e.structRef(innerStruct)
 				if err != nil {
 					return nil, err
 				}
@@ -414,5 +998,56 @@ func schemaToJSONValue(js *jsonSchema) any {
 	if js.XMap2 {
 		out["x-map2"] = true
 	}
+	if js.XMap {
+		out["x-map"] = true
+	}
+	if js.PrefixItems != nil {
+		items := make([]any, len(js.PrefixItems))
+		for i, child := range js.PrefixItems {
+			items[i] = schemaToJSONValue(child)
+		}
+		out["prefixItems"] = items
+	}
+	if len(js.Const) > 0 {
+		out["const"] = js.Const
+	}
+	if len(js.OneOf) > 0 {
+		branches := make([]any, len(js.OneOf))
+		for i, child := range js.OneOf {
+			branches[i] = schemaToJSONValue(child)
+		}
+		out["oneOf"] = branches
+	}
+	if len(js.AnyOf) > 0 {
+		branches := make([]any, len(js.AnyOf))
+		for i, child := range js.AnyOf {
+			branches[i] = schemaToJSONValue(child)
+		}
+		out["anyOf"] = branches
+	}
+	if d := js.Discriminator; d != nil {
+		disc := make(map[string]any)
+		if d.PropertyName != "" {
+			disc["propertyName"] = d.PropertyName
+		}
+		if len(d.Mapping) > 0 {
+			disc["mapping"] = d.Mapping
+		}
+		out["discriminator"] = disc
+	}
+	if len(js.Definitions) > 0 {
+		defs := make(map[string]any)
+		for name, child := range js.Definitions {
+			defs[name] = schemaToJSONValue(child)
+		}
+		out["definitions"] = defs
+	}
+	if len(js.Defs) > 0 {
+		defs := make(map[string]any)
+		for name, child := range js.Defs {
+			defs[name] = schemaToJSONValue(child)
+		}
+		out["$defs"] = defs
+	}
 	return out
 }
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"strings"
 	"testing"
+
+	"google.golang.org/protobuf/proto"
 )
 
 func TestJSONString(t *testing.T) {
@@ -542,3 +544,578 @@ func TestStruct_MarshalJSON_RoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestJSMServiceStruct_Ref(t *testing.T) {
+	jsonSchemaStr := `{
+		"definitions": {
+			"Server": {"className": "HTTPServer", "properties": {"Handler": {"className": "APIHandler", "serviceName": "api"}}},
+			"a/b": {"className": "Escaped"}
+		},
+		"$defs": {
+			"Cell": {"className": "Cell", "serviceName": "cellService"}
+		},
+		"properties": {
+			"Primary":  {"$ref": "#/definitions/Server"},
+			"Backup":   {"$ref": "#/definitions/Server"},
+			"Servers":  {"items": {"$ref": "#/definitions/Server"}},
+			"Cells":    {"additionalProperties": {"$ref": "#/$defs/Cell"}},
+			"Handler":  {"$ref": "#/definitions/Server/properties/Handler"},
+			"Escaped":  {"$ref": "#/definitions/a~1b"}
+		}
+	}`
+	spec, err := JSMServiceStruct("Config", jsonSchemaStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := spec.Fields["Primary"].GetSingleStruct()
+	if primary.ClassName != "HTTPServer" || primary.Fields["Handler"].GetSingleStruct().ServiceName != "api" {
+		t.Errorf("Primary not resolved: %v", primary)
+	}
+	if spec.Fields["Backup"].GetSingleStruct() != primary || spec.Fields["Servers"].GetListStruct().ListFields[0] != primary {
+		t.Error("repeated references should share the resolved *Struct")
+	}
+	if cell := spec.Fields["Cells"].GetMapStruct().MapFields["*"]; cell.ClassName != "Cell" || cell.ServiceName != "cellService" {
+		t.Errorf("Cells not resolved: %v", cell)
+	}
+	if spec.Fields["Handler"].GetSingleStruct() != primary.Fields["Handler"].GetSingleStruct() {
+		t.Error("JSON Pointer into properties should share the resolved *Struct")
+	}
+	if got := spec.Fields["Escaped"].GetSingleStruct().ClassName; got != "Escaped" {
+		t.Errorf("escaped pointer resolved to %q", got)
+	}
+}
+
+func TestJSMServiceStruct_RefErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			name:   "missing definition",
+			schema: `{"properties": {"A": {"items": {"$ref": "#/definitions/Nope"}}}}`,
+			want:   `in property "A": in items: cannot resolve $ref "#/definitions/Nope": no schema at /definitions/Nope`,
+		},
+		{
+			name:   "remote reference",
+			schema: `{"properties": {"A": {"$ref": "other.json#/definitions/X"}}}`,
+			want:   "only local references",
+		},
+		{
+			name:   "not a pointer",
+			schema: `{"properties": {"A": {"$ref": "#anchor"}}}`,
+			want:   "fragment must be a JSON Pointer",
+		},
+		{
+			name:   "cycle without object",
+			schema: `{"definitions": {"L": {"items": {"$ref": "#/definitions/L"}}}, "properties": {"A": {"$ref": "#/definitions/L"}}}`,
+			want:   `cyclic $ref "#/definitions/L" does not pass through an object with properties`,
+		},
+		{
+			name:   "error inside target",
+			schema: `{"definitions": {"M": {"x-map2": true, "properties": {"r1": {}}}}, "properties": {"A": {"$ref": "#/definitions/M"}}}`,
+			want:   `in property "A": in $ref "#/definitions/M": x-map2 region "r1" missing properties`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := JSMServiceStruct("Config", tt.schema)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// sameGraph reports whether the Struct graphs at a and b have the same shape,
+// class names, service names and sharing, following cycles.
+func sameGraph(a, b *Struct) bool {
+	pairs := make(map[*Struct]*Struct)
+	var same func(a, b *Struct) bool
+	same = func(a, b *Struct) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		if p, ok := pairs[a]; ok {
+			return p == b
+		}
+		pairs[a] = b
+		if a.ClassName != b.ClassName || a.ServiceName != b.ServiceName || len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		if a.Discriminator != b.Discriminator || len(a.Choices) != len(b.Choices) {
+			return false
+		}
+		for key, ca := range a.Choices {
+			if !same(ca, b.Choices[key]) {
+				return false
+			}
+		}
+		for name, va := range a.Fields {
+			vb, ok := b.Fields[name]
+			if !ok {
+				return false
+			}
+			switch ka := va.GetKind().(type) {
+			case *Value_SingleStruct:
+				if !same(ka.SingleStruct, vb.GetSingleStruct()) {
+					return false
+				}
+			case *Value_ListStruct:
+				la, lb := ka.ListStruct.GetListFields(), vb.GetListStruct().GetListFields()
+				if vb.GetListStruct() == nil || len(la) != len(lb) {
+					return false
+				}
+				for i := range la {
+					if !same(la[i], lb[i]) {
+						return false
+					}
+				}
+			case *Value_MapStruct:
+				ma, mb := ka.MapStruct.GetMapFields(), vb.GetMapStruct().GetMapFields()
+				if vb.GetMapStruct() == nil || len(ma) != len(mb) {
+					return false
+				}
+				for key := range ma {
+					if !same(ma[key], mb[key]) {
+						return false
+					}
+				}
+			case *Value_Map2Struct:
+				ma, mb := ka.Map2Struct.GetMap2Fields(), vb.GetMap2Struct().GetMap2Fields()
+				if vb.GetMap2Struct() == nil || len(ma) != len(mb) {
+					return false
+				}
+				for key1 := range ma {
+					ia, ib := ma[key1].GetMapFields(), mb[key1].GetMapFields()
+					if len(ia) != len(ib) {
+						return false
+					}
+					for key2 := range ia {
+						if !same(ia[key2], ib[key2]) {
+							return false
+						}
+					}
+				}
+			}
+		}
+		return true
+	}
+	return same(a, b)
+}
+
+// newCyclicTree returns a Tree whose Root node lists Node children, which in turn
+// point back to the Tree and to a shared Leaf.
+func newCyclicTree() *Struct {
+	tree := &Struct{ClassName: "Tree"}
+	node := &Struct{ClassName: "Node"}
+	leaf := &Struct{ClassName: "Leaf", ServiceName: "leafService"}
+	node.Fields = map[string]*Value{
+		"Children": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{node}}}},
+		"Owner":    {Kind: &Value_SingleStruct{SingleStruct: tree}},
+		"Leaf":     {Kind: &Value_SingleStruct{SingleStruct: leaf}},
+	}
+	tree.Fields = map[string]*Value{
+		"Root":  {Kind: &Value_SingleStruct{SingleStruct: node}},
+		"Index": {Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"*": leaf}}}},
+	}
+	return tree
+}
+
+func TestStruct_MarshalJSON_Cyclic(t *testing.T) {
+	tree := newCyclicTree()
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"className":"Tree","definitions":{"Leaf":{"className":"Leaf","serviceName":"leafService"},` +
+		`"Node":{"className":"Node","properties":{"Children":{"items":{"$ref":"#/definitions/Node"}},` +
+		`"Leaf":{"$ref":"#/definitions/Leaf"},"Owner":{"$ref":"#"}}}},` +
+		`"properties":{"Index":{"additionalProperties":{"$ref":"#/definitions/Leaf"}},"Root":{"$ref":"#/definitions/Node"}}}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+
+	var restored Struct
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !sameGraph(tree, &restored) {
+		t.Error("cyclic Struct did not round-trip")
+	}
+	node := restored.Fields["Root"].GetSingleStruct()
+	if node.Fields["Owner"].GetSingleStruct() != &restored {
+		t.Error("reference to the root should point to the receiver")
+	}
+	if node.Fields["Children"].GetListStruct().ListFields[0] != node {
+		t.Error("self reference should point to the same *Struct")
+	}
+}
+
+func TestStruct_MarshalJSON_DefinitionNames(t *testing.T) {
+	a1 := &Struct{ClassName: "A", ServiceName: "s1"}
+	a2 := &Struct{ClassName: "A", ServiceName: "s2"}
+	odd := &Struct{ClassName: "x/y~z"}
+	spec := &Struct{ClassName: "Root", Fields: map[string]*Value{
+		"F1": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{a1, a1}}}},
+		"F2": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{a2, a2}}}},
+		"F3": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{odd, odd}}}},
+	}}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"A":{`, `"A_2":{`, `"$ref":"#/definitions/x~1y~0z"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s should contain %s", data, want)
+		}
+	}
+	if _, err := JSMServiceStruct("Root", string(data)); err != nil {
+		t.Errorf("exported definitions should resolve: %v", err)
+	}
+}
+
+func TestJSMServiceStruct_CyclicRef(t *testing.T) {
+	spec, err := JSMServiceStruct("Tree", `{
+		"definitions": {
+			"Node": {"className": "Node", "properties": {
+				"Children": {"items": {"$ref": "#/definitions/Node"}},
+				"Tree": {"$ref": "#"}
+			}}
+		},
+		"properties": {"Root": {"$ref": "#/definitions/Node"}}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := spec.Fields["Root"].GetSingleStruct()
+	if node.Fields["Children"].GetListStruct().ListFields[0] != node || node.Fields["Tree"].GetSingleStruct() != spec {
+		t.Error("cyclic references should resolve to the same *Struct")
+	}
+	if derived := DeriveStructWithoutServices(spec); !sameGraph(spec, derived) {
+		t.Error("DeriveStructWithoutServices should keep the cycles")
+	}
+}
+
+func TestStruct_MarshalJSON_Lossless(t *testing.T) {
+	fields := map[string]any{
+		"Single":    "Circle",
+		"List":      []string{"HTTPServer", "GRPCServer"},
+		"OneList":   []string{"HTTPServer"},
+		"EmptyList": []string{},
+		"Keyed":     map[string]string{"api": "APIHandler", "web": "WebHandler"},
+		"Fallback":  map[string]string{"api": "APIHandler", "*": "WebHandler"},
+		"Star":      map[string]string{"*": "WebHandler"},
+		"EmptyMap":  map[string]string{},
+		"Grid":      map[[2]string]string{{"r1", "k1"}: "Cell", {"*", "*"}: "Cell"},
+		"EmptyGrid": map[[2]string]string{},
+		"Nested": [][2]any{
+			{"Server", map[string]any{"Handlers": map[string]string{"a": "A", "b": "B"}}},
+			{"Proxy", map[string]any{"Upstreams": []string{"U1", "U2", "U3"}}},
+		},
+	}
+	spec, err := NewStruct("Config", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewServiceStruct("Config", map[string]any{
+		"List":  [][]string{{"HTTPServer", "httpService"}, {"GRPCServer", "grpcService"}},
+		"Keyed": map[string][]string{"api": {"APIHandler", "apiService"}, "*": {"WebHandler"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, original := range []*Struct{spec, service} {
+		data, err := json.Marshal(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var restored Struct
+		if err := json.Unmarshal(data, &restored); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(original, &restored) {
+			t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", data, &restored, original)
+		}
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"List":{"prefixItems":[{"className":"HTTPServer"},{"className":"GRPCServer"}]}`,
+		`"OneList":{"items":{"className":"HTTPServer"}}`,
+		`"Fallback":{"additionalProperties":{"className":"WebHandler"},"properties":{"api":{"className":"APIHandler"}},"x-map":true}`,
+		`"Star":{"additionalProperties":{"className":"WebHandler"}}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+}
+
+func TestJSMServiceStructWithOptions_Choices(t *testing.T) {
+	jsonSchemaStr := `{
+		"definitions": {
+			"Circle": {"properties": {"kind": {"const": "circle"}, "Center": {"className": "Point"}}},
+			"Square": {"title": "SquareShape", "properties": {"kind": {"const": "square"}}}
+		},
+		"properties": {
+			"Mapped": {
+				"oneOf": [{"$ref": "#/definitions/Circle"}, {"$ref": "#/definitions/Square"}],
+				"discriminator": {"propertyName": "type", "mapping": {"c": "#/definitions/Circle", "s": "Square"}}
+			},
+			"Const": {
+				"oneOf": [{"$ref": "#/definitions/Circle"}, {"$ref": "#/definitions/Square"}]
+			},
+			"Named": {
+				"anyOf": [{"className": "HTTPServer", "serviceName": "http"}, {"className": "GRPCServer"}]
+			},
+			"Shapes": {
+				"items": {"oneOf": [{"$ref": "#/definitions/Circle"}], "discriminator": {"propertyName": "kind"}}
+			}
+		}
+	}`
+	spec, err := JSMServiceStructWithOptions("Config", jsonSchemaStr, &JSMOptions{Choices: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapped := spec.Fields["Mapped"].GetSingleStruct()
+	if mapped.Discriminator != "type" || len(mapped.Choices) != 2 {
+		t.Fatalf("Mapped: %v", mapped)
+	}
+	circle, square := mapped.ChoiceFor("c"), mapped.ChoiceFor("s")
+	if circle.GetClassName() != "Circle" || circle.Fields["Center"].GetSingleStruct().ClassName != "Point" {
+		t.Errorf("Mapped c: %v", circle)
+	}
+	if square.GetClassName() != "SquareShape" {
+		t.Errorf("Mapped s should take its class from the title: %v", square)
+	}
+
+	byConst := spec.Fields["Const"].GetSingleStruct()
+	if byConst.Discriminator != "kind" || byConst.ChoiceFor("circle") != circle || byConst.ChoiceFor("square") != square {
+		t.Errorf("Const: %v", byConst)
+	}
+	if _, ok := circle.Fields["kind"]; ok {
+		t.Error("the discriminator property should not be a field")
+	}
+
+	named := spec.Fields["Named"].GetSingleStruct()
+	if named.Discriminator != "" || named.ChoiceFor("HTTPServer").GetServiceName() != "http" || named.ChoiceFor("GRPCServer") == nil {
+		t.Errorf("Named: %v", named)
+	}
+
+	shapes := spec.Fields["Shapes"].GetListStruct().StructAt(0)
+	if shapes.Discriminator != "kind" || shapes.ChoiceFor("circle") != circle {
+		t.Errorf("Shapes: %v", shapes)
+	}
+
+	// Without the option, oneOf is not understood.
+	plain, err := JSMServiceStruct("Config", jsonSchemaStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plain.Fields["Mapped"].GetSingleStruct(); got == nil || len(got.Choices) != 0 {
+		t.Errorf("Mapped should be an empty object without Choices: %v", got)
+	}
+}
+
+func TestJSMServiceStructWithOptions_ChoiceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			name:   "not an object",
+			schema: `{"properties": {"A": {"oneOf": [{"items": {"className": "X"}}]}}}`,
+			want:   `in property "A": in oneOf[0]: choice must be an object schema`,
+		},
+		{
+			name:   "no key",
+			schema: `{"properties": {"A": {"anyOf": [{"properties": {"B": {"className": "X"}}}]}}}`,
+			want:   `in property "A": in anyOf[0]: cannot tell the discriminator value or class name of the choice`,
+		},
+		{
+			name:   "duplicate",
+			schema: `{"properties": {"A": {"oneOf": [{"className": "X"}, {"className": "X"}]}}}`,
+			want:   `in property "A": in oneOf[1]: duplicate choice "X"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := JSMServiceStructWithOptions("Config", tt.schema, &JSMOptions{Choices: true})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStruct_MarshalJSON_Choices(t *testing.T) {
+	circle := &Struct{ClassName: "Circle", Fields: map[string]*Value{
+		"Center": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "Point"}}},
+	}}
+	shape := &Struct{
+		Discriminator: "kind",
+		Choices: map[string]*Struct{
+			"circle": circle,
+			"square": {ClassName: "Square", ServiceName: "squareService"},
+		},
+	}
+	spec := &Struct{ClassName: "Drawing", Fields: map[string]*Value{
+		"Main":    {Kind: &Value_SingleStruct{SingleStruct: shape}},
+		"Circles": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{circle}}}},
+		"Server": {Kind: &Value_SingleStruct{SingleStruct: &Struct{Choices: map[string]*Struct{
+			"HTTPServer": {ClassName: "HTTPServer"},
+			"grpc":       {ClassName: "GRPCServer"},
+		}}}},
+	}}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"discriminator":{"mapping":{"circle":"#/definitions/Circle"},"propertyName":"kind"}`,
+		`{"className":"Square","properties":{"kind":{"const":"square"}},"serviceName":"squareService"}`,
+		`"Server":{"discriminator":{"mapping":{"grpc":"#/definitions/GRPCServer"}},"oneOf":[{"className":"HTTPServer"},{"$ref":"#/definitions/GRPCServer"}]}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+
+	var restored Struct
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !sameGraph(spec, &restored) {
+		t.Errorf("choices did not round-trip:\n%s\ngot %v", data, &restored)
+	}
+}
+
+func TestJSMServiceValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		check  func(v *Value) bool
+	}{
+		{
+			name:   "list",
+			schema: `{"items": {"className": "Circle", "serviceName": "s1"}}`,
+			check: func(v *Value) bool {
+				items := v.GetListStruct().GetListFields()
+				return len(items) == 1 && items[0].ClassName == "Circle" && items[0].ServiceName == "s1"
+			},
+		},
+		{
+			name:   "map",
+			schema: `{"additionalProperties": {"className": "Handler"}}`,
+			check: func(v *Value) bool {
+				return v.GetMapStruct().GetMapFields()["*"].GetClassName() == "Handler"
+			},
+		},
+		{
+			name:   "map2",
+			schema: `{"x-map2": true, "properties": {"r1": {"properties": {"k1": {"className": "Cell"}}}}}`,
+			check: func(v *Value) bool {
+				return v.GetMap2Struct().GetMap2Fields()["r1"].GetMapFields()["k1"].GetClassName() == "Cell"
+			},
+		},
+		{
+			name:   "object",
+			schema: `{"className": "Config", "properties": {"A": {"className": "X"}}}`,
+			check: func(v *Value) bool {
+				return v.GetSingleStruct().GetClassName() == "Config"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := JSMServiceValue(tt.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(v) {
+				t.Errorf("unexpected value %v", v)
+			}
+		})
+	}
+
+	v, err := JSMValue(`{"items": {"className": "Circle", "serviceName": "s1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.GetListStruct().GetListFields()[0].GetServiceName() != "" {
+		t.Error("JSMValue should strip service names")
+	}
+	if _, err := JSMServiceValue(`{"x-map2": true, "properties": {"r1": {"properties": {}}}}`); err == nil || err.Error() != "JSON schema describes no class" {
+		t.Errorf("got %v, want an error for a schema describing no class", err)
+	}
+	if _, err := JSMServiceValue(`[1]`); err == nil || !strings.Contains(err.Error(), "failed to parse JSON Schema") {
+		t.Errorf("got %v, want a parse error", err)
+	}
+}
+
+func TestValue_MarshalJSON_RoundTrip(t *testing.T) {
+	shared := &Struct{ClassName: "Circle", ServiceName: "s1"}
+	inner := &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{{ClassName: "Point"}}}}}
+	values := map[string]*Value{
+		"list": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{shared, {ClassName: "Square"}}}}},
+		"map": {Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{
+			"api": {ClassName: "APIHandler"},
+			"*":   {ClassName: "WebHandler"},
+		}}}},
+		"map2": {Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map[string]*MapStruct{
+			"r1": {MapFields: map[string]*Struct{"k1": {ClassName: "Cell"}}},
+		}}}},
+		"nested": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{wrapValueAsStruct(inner)}}}},
+		"single": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "Config"}}},
+	}
+	for name, original := range values {
+		t.Run(name, func(t *testing.T) {
+			data, err := json.Marshal(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), wrapperClassName) {
+				t.Errorf("%s should not mention the wrapper", data)
+			}
+			var restored Value
+			if err := json.Unmarshal(data, &restored); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(original, &restored) {
+				t.Errorf("Value did not round-trip:\n%s\ngot  %v\nwant %v", data, &restored, original)
+			}
+		})
+	}
+
+	shareList := &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{shared, shared}}}}
+	data, err := json.Marshal(shareList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"definitions":{"Circle":{"className":"Circle","serviceName":"s1"}},"prefixItems":[{"$ref":"#/definitions/Circle"},{"$ref":"#/definitions/Circle"}]}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+	var restored Value
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if items := restored.GetListStruct().GetListFields(); items[0] != items[1] {
+		t.Error("shared Struct should be restored as one *Struct")
+	}
+	if err := json.Unmarshal([]byte(`{"x-map2": true, "properties": {"r1": {"properties": {}}}}`), &restored); err == nil {
+		t.Error("a schema describing no class should be an error")
+	}
+}
@@ -0,0 +1,761 @@
+// Package jtd converts between JSON Type Definition (RFC 8927) schemas and
+// schema.Struct specifications.
+//
+// JTD forms line up with the spec Values; everything JTD cannot say is kept in
+// the schema metadata:
+//
+//	╔══════════════════════════════════════════╤═══════════════════════════════════════╗
+//	║ JTD                                      │ Spec                                  ║
+//	╠══════════════════════════════════════════╪═══════════════════════════════════════╣
+//	║ properties form                          │ SingleStruct; fields as optional      ║
+//	║                                          │ properties, since data may omit them  ║
+//	║ discriminator form                       │ Struct with Discriminator and Choices ║
+//	║ ref form                                 │ the Struct of the definition, shared  ║
+//	║ elements form                            │ ListStruct with one entry             ║
+//	║ elements + metadata "prefixElements"     │ positional ListStruct                 ║
+//	║ values form                              │ MapStruct with a "*" entry            ║
+//	║ properties form + metadata "map"         │ MapStruct with explicit keys; the "*" ║
+//	║                                          │ entry in metadata "values"            ║
+//	║ values of values, or metadata "map2"     │ Map2Struct                            ║
+//	║ elements or values of elements, or       │ entry wrapped as a Struct, as in      ║
+//	║ elements of values                       │ schema.Struct.UnmarshalJSON           ║
+//	║ type, enum and empty forms               │ data, not a class: left out           ║
+//	╚══════════════════════════════════════════╧═══════════════════════════════════════╝
+//
+// ClassName and ServiceName travel in metadata "className" and "serviceName".
+// Without a className, a Struct reached through a ref takes the definition name,
+// and a discriminator mapping entry its mapping key.
+package jtd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tabilet/schema"
+	"github.com/tabilet/schema/internal/wrapper"
+)
+
+// Schema is a JTD schema. Only the root schema may have Definitions.
+type Schema struct {
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+	Metadata             *Metadata          `json:"metadata,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Ref                  string             `json:"ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Elements             *Schema            `json:"elements,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	OptionalProperties   map[string]*Schema `json:"optionalProperties,omitempty"`
+	AdditionalProperties bool               `json:"additionalProperties,omitempty"`
+	Values               *Schema            `json:"values,omitempty"`
+	Discriminator        string             `json:"discriminator,omitempty"`
+	Mapping              map[string]*Schema `json:"mapping,omitempty"`
+}
+
+// Metadata holds the spec details JTD has no form for.
+type Metadata struct {
+	ClassName   string `json:"className,omitempty"`
+	ServiceName string `json:"serviceName,omitempty"`
+	// Map marks a properties form that is a MapStruct with explicit keys,
+	// and Map2 one whose properties are the regions of a Map2Struct.
+	Map  bool `json:"map,omitempty"`
+	Map2 bool `json:"map2,omitempty"`
+	// Values is the "*" entry of a Map or Map2.
+	Values *Schema `json:"values,omitempty"`
+	// Positional marks an elements form that is a positional ListStruct,
+	// whose entries are PrefixElements.
+	Positional     bool      `json:"positional,omitempty"`
+	PrefixElements []*Schema `json:"prefixElements,omitempty"`
+	// Other holds the remaining members, such as "description", which RFC 8927
+	// allows in metadata; they are written back unchanged.
+	Other map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON reads the members above and keeps any other member in Other.
+// The schemas in "values" and "prefixElements" are read as strictly as Parse.
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	*m = Metadata{}
+	for _, key := range sortedKeys(members) {
+		var target any
+		switch key {
+		case "className":
+			target = &m.ClassName
+		case "serviceName":
+			target = &m.ServiceName
+		case "map":
+			target = &m.Map
+		case "map2":
+			target = &m.Map2
+		case "values":
+			target = &m.Values
+		case "positional":
+			target = &m.Positional
+		case "prefixElements":
+			target = &m.PrefixElements
+		default:
+			if m.Other == nil {
+				m.Other = make(map[string]json.RawMessage)
+			}
+			m.Other[key] = members[key]
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(members[key]))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(target); err != nil {
+			return fmt.Errorf("metadata %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the members above, followed by those in Other.
+func (m *Metadata) MarshalJSON() ([]byte, error) {
+	type plain Metadata
+	data, err := json.Marshal((*plain)(m))
+	if err != nil || len(m.Other) == 0 {
+		return data, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for key, raw := range m.Other {
+		if _, ok := members[key]; !ok {
+			members[key] = raw
+		}
+	}
+	return json.Marshal(members)
+}
+
+// MarshalJSON writes the schema, keeping an empty optionalProperties so that a
+// class without fields stays in the properties form.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	if s.OptionalProperties != nil && len(s.OptionalProperties) == 0 && len(s.Properties) == 0 {
+		return json.Marshal(struct {
+			*plain
+			OptionalProperties map[string]*Schema `json:"optionalProperties"`
+		}{(*plain)(s), s.OptionalProperties})
+	}
+	return json.Marshal((*plain)(s))
+}
+
+// Parse parses a JTD schema in JSON, rejecting unknown keywords and types, and schemas
+// with more than one form. Metadata may hold any members.
+func Parse(data []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s Schema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("jtd: %w", err)
+	}
+	if err := s.check(&s, ""); err != nil {
+		return nil, fmt.Errorf("jtd: %w", err)
+	}
+	return &s, nil
+}
+
+// types holds the type names of the type form.
+var types = map[string]bool{
+	"boolean": true, "string": true, "timestamp": true, "float32": true, "float64": true,
+	"int8": true, "uint8": true, "int16": true, "uint16": true, "int32": true, "uint32": true,
+}
+
+// check reports a schema that is not well-formed, at the JSON Pointer path.
+func (s *Schema) check(root *Schema, path string) error {
+	if s.Definitions != nil && s != root {
+		return fmt.Errorf("%s: definitions are only allowed at the root", pointer(path))
+	}
+	forms := 0
+	for _, used := range []bool{
+		s.Ref != "",
+		s.Type != "",
+		s.Enum != nil,
+		s.Elements != nil,
+		s.Properties != nil || s.OptionalProperties != nil,
+		s.Values != nil,
+		s.Discriminator != "",
+	} {
+		if used {
+			forms++
+		}
+	}
+	if forms > 1 {
+		return fmt.Errorf("%s: schema has more than one form", pointer(path))
+	}
+	if s.AdditionalProperties && s.Properties == nil && s.OptionalProperties == nil {
+		return fmt.Errorf("%s: additionalProperties requires the properties form", pointer(path))
+	}
+	if s.Type != "" && !types[s.Type] {
+		return fmt.Errorf("%s: unknown type %q", pointer(path), s.Type)
+	}
+	if s.Ref != "" {
+		if _, ok := root.Definitions[s.Ref]; !ok {
+			return fmt.Errorf("%s: no definition %q", pointer(path), s.Ref)
+		}
+	}
+	if s.Mapping != nil && s.Discriminator == "" {
+		return fmt.Errorf("%s: mapping requires discriminator", pointer(path))
+	}
+	for _, key := range sortedKeys(s.Mapping) {
+		m := s.Mapping[key]
+		if m.Nullable || (m.Properties == nil && m.OptionalProperties == nil) {
+			return fmt.Errorf("%s: mapping %q must be a properties form that is not nullable", pointer(path), key)
+		}
+	}
+
+	children := map[string]*Schema{"/elements": s.Elements, "/values": s.Values}
+	for group, m := range map[string]map[string]*Schema{
+		"/definitions": s.Definitions, "/properties": s.Properties,
+		"/optionalProperties": s.OptionalProperties, "/mapping": s.Mapping,
+	} {
+		for key, child := range m {
+			children[group+"/"+escape(key)] = child
+		}
+	}
+	for _, p := range sortedKeys(children) {
+		if child := children[p]; child != nil {
+			if err := child.check(root, path+p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ToStruct converts a root schema into a Struct. The schema must describe a class:
+// a properties, discriminator or ref form.
+func (s *Schema) ToStruct() (*schema.Struct, error) {
+	v, err := s.ToValue()
+	if err != nil {
+		return nil, err
+	}
+	out := v.GetSingleStruct()
+	if out == nil {
+		return nil, fmt.Errorf("jtd: root schema must describe a class")
+	}
+	return out, nil
+}
+
+// ToValue converts a root schema into a Value. It returns nil for a schema that
+// describes data without a class, such as {"type": "string"}.
+func (s *Schema) ToValue() (*schema.Value, error) {
+	c := &converter{
+		root:      s,
+		converted: make(map[*Schema]*schema.Value),
+		resolving: make(map[*Schema]bool),
+	}
+	v, err := c.value(s, "")
+	if err != nil {
+		return nil, fmt.Errorf("jtd: %w", err)
+	}
+	return v, nil
+}
+
+// converter converts a JTD document, sharing the Value of every definition.
+type converter struct {
+	root      *Schema
+	converted map[*Schema]*schema.Value
+	resolving map[*Schema]bool
+}
+
+// value converts s; name is the class name to use if s does not carry one.
+func (c *converter) value(s *Schema, name string) (*schema.Value, error) {
+	if v, ok := c.converted[s]; ok {
+		return v, nil
+	}
+	if s.Ref != "" {
+		target, ok := c.root.Definitions[s.Ref]
+		if !ok {
+			return nil, fmt.Errorf("no definition %q", s.Ref)
+		}
+		if v, ok := c.converted[target]; ok {
+			return v, nil
+		}
+		if c.resolving[target] {
+			// Only a cycle through a Struct can be represented.
+			return nil, fmt.Errorf("cyclic ref %q does not pass through a properties form", s.Ref)
+		}
+		c.resolving[target] = true
+		defer delete(c.resolving, target)
+		v, err := c.value(target, s.Ref)
+		if err != nil {
+			return nil, fmt.Errorf("in ref %q: %w", s.Ref, err)
+		}
+		return v, nil
+	}
+
+	meta := s.Metadata
+	if meta == nil {
+		meta = &Metadata{}
+	}
+	if meta.ClassName != "" {
+		name = meta.ClassName
+	}
+
+	switch {
+	case meta.Map2 || (s.Values != nil && s.Values.Values != nil):
+		return c.map2Value(s, meta)
+	case meta.Map || s.Values != nil:
+		ms, err := c.mapStruct(s, meta)
+		if err != nil || (!meta.Map && len(ms.MapFields) == 0) {
+			return nil, err // A map of data has no class.
+		}
+		return &schema.Value{Kind: &schema.Value_MapStruct{MapStruct: ms}}, nil
+
+	case meta.Positional:
+		list := make([]*schema.Struct, len(meta.PrefixElements))
+		for i, item := range meta.PrefixElements {
+			x, err := c.structOf(item, fmt.Sprintf("in prefixElements[%d]", i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = x
+		}
+		return &schema.Value{Kind: &schema.Value_ListStruct{ListStruct: &schema.ListStruct{ListFields: list}}}, nil
+	case s.Elements != nil:
+		x, err := c.structOf(s.Elements, "in elements")
+		if err != nil || x == nil {
+			return nil, err
+		}
+		return &schema.Value{Kind: &schema.Value_ListStruct{ListStruct: &schema.ListStruct{ListFields: []*schema.Struct{x}}}}, nil
+
+	case s.Discriminator != "":
+		out := &schema.Struct{ClassName: meta.ClassName, ServiceName: meta.ServiceName, Discriminator: s.Discriminator, Choices: make(map[string]*schema.Struct)}
+		v := &schema.Value{Kind: &schema.Value_SingleStruct{SingleStruct: out}}
+		c.converted[s] = v
+		for _, key := range sortedKeys(s.Mapping) {
+			cv, err := c.value(s.Mapping[key], key)
+			if err != nil {
+				return nil, fmt.Errorf("in mapping %q: %w", key, err)
+			}
+			out.Choices[key] = cv.GetSingleStruct()
+		}
+		return v, nil
+
+	case s.Properties != nil || s.OptionalProperties != nil || meta.ClassName != "" || meta.ServiceName != "":
+		out := &schema.Struct{ClassName: name, ServiceName: meta.ServiceName}
+		// Cache the Struct before its properties, so that a ref cycling
+		// back to it resolves to the same *Struct.
+		v := &schema.Value{Kind: &schema.Value_SingleStruct{SingleStruct: out}}
+		c.converted[s] = v
+		for _, props := range []map[string]*Schema{s.Properties, s.OptionalProperties} {
+			for _, field := range sortedKeys(props) {
+				fv, err := c.value(props[field], "")
+				if err != nil {
+					return nil, fmt.Errorf("in property %q: %w", field, err)
+				}
+				if fv == nil {
+					continue
+				}
+				if out.Fields == nil {
+					out.Fields = make(map[string]*schema.Value)
+				}
+				out.Fields[field] = fv
+			}
+		}
+		return v, nil
+	}
+	// type, enum and empty forms describe data.
+	return nil, nil
+}
+
+// structOf converts s for an entry of a list or map. An entry that is itself a
+// collection, such as the inner elements of elements, is wrapped as a Struct.
+func (c *converter) structOf(s *Schema, where string) (*schema.Struct, error) {
+	v, err := c.value(s, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", where, err)
+	}
+	if v == nil {
+		return nil, nil
+	}
+	if x := v.GetSingleStruct(); x != nil {
+		return x, nil
+	}
+	return wrapValue(v), nil
+}
+
+// mapStruct converts a values form, or a properties form marked as a map.
+func (c *converter) mapStruct(s *Schema, meta *Metadata) (*schema.MapStruct, error) {
+	fields := make(map[string]*schema.Struct)
+	star := s.Values
+	if star == nil {
+		star = meta.Values
+	}
+	if star != nil {
+		x, err := c.structOf(star, "in values")
+		if err != nil {
+			return nil, err
+		}
+		if x != nil {
+			fields["*"] = x
+		}
+	}
+	for _, props := range []map[string]*Schema{s.Properties, s.OptionalProperties} {
+		for _, key := range sortedKeys(props) {
+			x, err := c.structOf(props[key], fmt.Sprintf("in key %q", key))
+			if err != nil {
+				return nil, err
+			}
+			if x != nil {
+				fields[key] = x
+			}
+		}
+	}
+	return &schema.MapStruct{MapFields: fields}, nil
+}
+
+// map2Value converts a values form of values, or a properties form marked map2.
+func (c *converter) map2Value(s *Schema, meta *Metadata) (*schema.Value, error) {
+	regions := make(map[string]*Schema)
+	for _, props := range []map[string]*Schema{s.Properties, s.OptionalProperties} {
+		for key, region := range props {
+			regions[key] = region
+		}
+	}
+	if star := s.Values; star != nil {
+		regions["*"] = star
+	} else if meta.Values != nil {
+		regions["*"] = meta.Values
+	}
+
+	fields := make(map[string]*schema.MapStruct, len(regions))
+	for _, key := range sortedKeys(regions) {
+		region := regions[key]
+		regionMeta := region.Metadata
+		if regionMeta == nil {
+			regionMeta = &Metadata{}
+		}
+		ms, err := c.mapStruct(region, regionMeta)
+		if err != nil {
+			return nil, fmt.Errorf("in region %q: %w", key, err)
+		}
+		fields[key] = ms
+	}
+	return &schema.Value{Kind: &schema.Value_Map2Struct{Map2Struct: &schema.Map2Struct{Map2Fields: fields}}}, nil
+}
+
+// FromStruct converts spec into a root JTD schema. A Struct reached more than
+// once, through sharing or a cycle, is written once under definitions and
+// referenced with ref; if the root itself is referenced, the root schema is a
+// ref to its definition.
+func FromStruct(spec *schema.Struct) (*Schema, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("jtd: spec cannot be nil")
+	}
+	return FromValue(&schema.Value{Kind: &schema.Value_SingleStruct{SingleStruct: spec}})
+}
+
+// FromValue converts v into a root JTD schema, as FromStruct.
+func FromValue(v *schema.Value) (*Schema, error) {
+	e := &exporter{
+		refs:        make(map[*schema.Struct]int),
+		names:       make(map[*schema.Struct]string),
+		definitions: make(map[string]*Schema),
+	}
+	var order []*schema.Struct
+	e.countValue(v, &order)
+	used := make(map[string]bool)
+	for _, x := range order {
+		if e.refs[x] < 2 {
+			continue
+		}
+		base := x.ClassName
+		if base == "" {
+			base = "Struct"
+		}
+		name := base
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[name] = true
+		e.names[x] = name
+	}
+
+	out, err := e.valueSchema(v)
+	if err != nil {
+		return nil, fmt.Errorf("jtd: %w", err)
+	}
+	if len(e.definitions) > 0 {
+		out.Definitions = e.definitions
+	}
+	return out, nil
+}
+
+// exporter converts a possibly cyclic Struct graph into JTD.
+type exporter struct {
+	// refs counts the references to each Struct from within the graph.
+	refs map[*schema.Struct]int
+	// names holds the definition name of every Struct reached more than once.
+	names       map[*schema.Struct]string
+	definitions map[string]*Schema
+}
+
+// countValue counts the references to every Struct reachable from v, appending
+// each Struct to order on its first visit, in a deterministic order.
+func (e *exporter) countValue(v *schema.Value, order *[]*schema.Struct) {
+	switch k := v.GetKind().(type) {
+	case *schema.Value_SingleStruct:
+		e.count(k.SingleStruct, order)
+	case *schema.Value_ListStruct:
+		for _, x := range k.ListStruct.GetListFields() {
+			e.count(x, order)
+		}
+	case *schema.Value_MapStruct:
+		fields := k.MapStruct.GetMapFields()
+		for _, key := range sortedKeys(fields) {
+			e.count(fields[key], order)
+		}
+	case *schema.Value_Map2Struct:
+		fields := k.Map2Struct.GetMap2Fields()
+		for _, key1 := range sortedKeys(fields) {
+			inner := fields[key1].GetMapFields()
+			for _, key2 := range sortedKeys(inner) {
+				e.count(inner[key2], order)
+			}
+		}
+	}
+}
+
+func (e *exporter) count(s *schema.Struct, order *[]*schema.Struct) {
+	if s == nil {
+		return
+	}
+	if v, ok := unwrapValue(s); ok {
+		// A wrapper is written as the collection it holds, never as a definition.
+		e.countValue(v, order)
+		return
+	}
+	e.refs[s]++
+	if e.refs[s] > 1 {
+		return
+	}
+	*order = append(*order, s)
+	for _, name := range sortedKeys(s.Fields) {
+		e.countValue(s.Fields[name], order)
+	}
+	// Choices are written inline, as JTD requires, so they do not count as
+	// references themselves.
+	for _, key := range sortedKeys(s.Choices) {
+		choice := s.Choices[key]
+		if choice == nil {
+			continue
+		}
+		for _, name := range sortedKeys(choice.Fields) {
+			e.countValue(choice.Fields[name], order)
+		}
+	}
+}
+
+// structRef returns a ref to the definition of s, or the schema of s itself. A
+// wrapped nested collection is written as the collection, e.g. elements of elements.
+func (e *exporter) structRef(s *schema.Struct) (*Schema, error) {
+	if s == nil {
+		return &Schema{}, nil
+	}
+	if v, ok := unwrapValue(s); ok {
+		return e.valueSchema(v)
+	}
+	name, ok := e.names[s]
+	if !ok {
+		return e.structSchema(s)
+	}
+	if _, done := e.definitions[name]; !done {
+		// Reserve the name first, so that a cycle back to s ends in a ref.
+		e.definitions[name] = nil
+		def, err := e.structSchema(s)
+		if err != nil {
+			return nil, err
+		}
+		e.definitions[name] = def
+	}
+	return &Schema{Ref: name}, nil
+}
+
+// structSchema returns the properties or discriminator form of s.
+func (e *exporter) structSchema(s *schema.Struct) (*Schema, error) {
+	out := &Schema{}
+	if s.ClassName != "" || s.ServiceName != "" {
+		out.Metadata = &Metadata{ClassName: s.ClassName, ServiceName: s.ServiceName}
+	}
+	if len(s.Choices) > 0 {
+		if s.Discriminator == "" {
+			return nil, fmt.Errorf("choices of %q need a discriminator", s.ClassName)
+		}
+		out.Discriminator = s.Discriminator
+		out.Mapping = make(map[string]*Schema, len(s.Choices))
+		for _, key := range sortedKeys(s.Choices) {
+			choice := s.Choices[key]
+			if choice == nil || len(choice.Choices) > 0 {
+				return nil, fmt.Errorf("choice %q must be a class without choices", key)
+			}
+			branch, err := e.structSchema(choice)
+			if err != nil {
+				return nil, fmt.Errorf("choice %q: %w", key, err)
+			}
+			out.Mapping[key] = branch
+		}
+		return out, nil
+	}
+
+	// Data may omit any spec'd field, and hold fields the spec does not mention.
+	out.OptionalProperties = make(map[string]*Schema, len(s.Fields))
+	out.AdditionalProperties = true
+	for name, v := range s.Fields {
+		prop, err := e.valueSchema(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", name, err)
+		}
+		out.OptionalProperties[name] = prop
+	}
+	return out, nil
+}
+
+// valueSchema returns the JTD schema of the data described by v.
+func (e *exporter) valueSchema(v *schema.Value) (*Schema, error) {
+	switch k := v.GetKind().(type) {
+	case *schema.Value_SingleStruct:
+		return e.structRef(k.SingleStruct)
+
+	case *schema.Value_ListStruct:
+		fields := k.ListStruct.GetListFields()
+		if len(fields) == 1 {
+			item, err := e.structRef(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("elements: %w", err)
+			}
+			return &Schema{Elements: item}, nil
+		}
+		meta := &Metadata{Positional: true}
+		for i, x := range fields {
+			item, err := e.structRef(x)
+			if err != nil {
+				return nil, fmt.Errorf("prefixElements[%d]: %w", i, err)
+			}
+			meta.PrefixElements = append(meta.PrefixElements, item)
+		}
+		return &Schema{Elements: &Schema{}, Metadata: meta}, nil
+
+	case *schema.Value_MapStruct:
+		return e.mapSchema(k.MapStruct.GetMapFields())
+
+	case *schema.Value_Map2Struct:
+		fields := k.Map2Struct.GetMap2Fields()
+		regions := make(map[string]*Schema, len(fields))
+		for key1, ms := range fields {
+			region, err := e.mapSchema(ms.GetMapFields())
+			if err != nil {
+				return nil, fmt.Errorf("region %q: %w", key1, err)
+			}
+			regions[key1] = region
+		}
+		star, ok := regions["*"]
+		if ok && len(regions) == 1 && star.Values != nil {
+			return &Schema{Values: star}, nil
+		}
+		delete(regions, "*")
+		out := &Schema{OptionalProperties: regions, Metadata: &Metadata{Map2: true}}
+		if ok {
+			out.Metadata.Values = star
+			out.AdditionalProperties = true
+		}
+		return out, nil
+
+	case nil:
+		return &Schema{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown Value kind: %T", v.Kind)
+	}
+}
+
+// mapSchema returns a values form for a map with only a "*" entry, and otherwise
+// a properties form marked as a map.
+func (e *exporter) mapSchema(fields map[string]*schema.Struct) (*Schema, error) {
+	star, ok := fields["*"]
+	var starSchema *Schema
+	if ok {
+		var err error
+		if starSchema, err = e.structRef(star); err != nil {
+			return nil, fmt.Errorf(`key "*": %w`, err)
+		}
+		if len(fields) == 1 {
+			return &Schema{Values: starSchema}, nil
+		}
+	}
+	out := &Schema{OptionalProperties: make(map[string]*Schema, len(fields)), Metadata: &Metadata{Map: true, Values: starSchema}}
+	out.AdditionalProperties = ok
+	for key, x := range fields {
+		if key == "*" {
+			continue
+		}
+		item, err := e.structRef(x)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+		out.OptionalProperties[key] = item
+	}
+	return out, nil
+}
+
+// wrapValue returns the Struct standing for the nested collection v in a list or
+// map entry, in the shape of schema.Struct.UnmarshalJSON.
+func wrapValue(v *schema.Value) *schema.Struct {
+	return &schema.Struct{
+		ClassName:   wrapper.ClassName,
+		ServiceName: wrapper.ServiceName,
+		Fields:      map[string]*schema.Value{wrapper.FieldName: v},
+	}
+}
+
+// unwrapValue returns the collection held by a Struct from wrapValue, and false
+// for any other Struct.
+func unwrapValue(s *schema.Struct) (*schema.Value, bool) {
+	if s.GetClassName() != wrapper.ClassName || s.GetServiceName() != wrapper.ServiceName || len(s.GetFields()) != 1 {
+		return nil, false
+	}
+	v := s.Fields[wrapper.FieldName]
+	if v == nil || v.GetKind() == nil || v.GetSingleStruct() != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// escape escapes a JSON Pointer token.
+func escape(token string) string {
+	var b bytes.Buffer
+	for _, r := range token {
+		switch r {
+		case '~':
+			b.WriteString("~0")
+		case '/':
+			b.WriteString("~1")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func pointer(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
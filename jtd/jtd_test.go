@@ -0,0 +1,236 @@
+package jtd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tabilet/schema"
+	"google.golang.org/protobuf/proto"
+)
+
+func roundTrip(t *testing.T, spec *schema.Struct) (*schema.Struct, []byte) {
+	t.Helper()
+	js, err := FromStruct(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	got, err := parsed.ToStruct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got, data
+}
+
+func TestRoundTrip(t *testing.T) {
+	spec, err := schema.NewServiceStruct("Config", map[string]any{
+		"Single":    []string{"Circle", "circleService"},
+		"List":      [][]string{{"HTTPServer", "httpService"}, {"GRPCServer"}},
+		"OneList":   [][]string{{"HTTPServer"}},
+		"EmptyList": [][]string{},
+		"Keyed":     map[string][]string{"api": {"APIHandler", "apiService"}, "web": {"WebHandler"}},
+		"Fallback":  map[string][]string{"api": {"APIHandler"}, "*": {"WebHandler"}},
+		"Star":      map[string][]string{"*": {"WebHandler"}},
+		"EmptyMap":  map[string][]string{},
+		"Grid":      map[[2]string][]string{{"r1", "k1"}: {"Cell", "cellService"}, {"*", "k2"}: {"Cell"}},
+		"AnyGrid":   map[[2]string][]string{{"*", "*"}: {"Cell"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := schema.NewStruct("Config", map[string]any{
+		"Nested": [][2]any{
+			{"Server", map[string]any{"Handlers": map[string]string{"a": "A", "b": "B"}}},
+			{"Proxy", map[string]any{"Upstreams": []string{"U1", "U2", "U3"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, original := range []*schema.Struct{spec, nested} {
+		got, data := roundTrip(t, original)
+		if !proto.Equal(original, got) {
+			t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", data, got, original)
+		}
+	}
+
+	_, data := roundTrip(t, spec)
+	for _, want := range []string{
+		`"Single":{"metadata":{"className":"Circle","serviceName":"circleService"},"additionalProperties":true,"optionalProperties":{}}`,
+		`"OneList":{"elements":{"metadata":{"className":"HTTPServer"},"additionalProperties":true,"optionalProperties":{}}}`,
+		`"Star":{"values":{"metadata":{"className":"WebHandler"},"additionalProperties":true,"optionalProperties":{}}}`,
+		`"AnyGrid":{"values":{"values":`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+}
+
+func TestRoundTrip_ChoicesAndCycles(t *testing.T) {
+	tree := &schema.Struct{ClassName: "Tree"}
+	node := &schema.Struct{ClassName: "Node"}
+	node.Fields = map[string]*schema.Value{
+		"Children": {Kind: &schema.Value_ListStruct{ListStruct: &schema.ListStruct{ListFields: []*schema.Struct{node}}}},
+		"Owner":    {Kind: &schema.Value_SingleStruct{SingleStruct: tree}},
+	}
+	shape := &schema.Struct{Discriminator: "kind", Choices: map[string]*schema.Struct{
+		"circle": {ClassName: "Circle", ServiceName: "circleService"},
+		"group":  {ClassName: "Group", Fields: map[string]*schema.Value{"Root": {Kind: &schema.Value_SingleStruct{SingleStruct: node}}}},
+	}}
+	tree.Fields = map[string]*schema.Value{
+		"Root":  {Kind: &schema.Value_SingleStruct{SingleStruct: node}},
+		"Shape": {Kind: &schema.Value_SingleStruct{SingleStruct: shape}},
+	}
+
+	got, data := roundTrip(t, tree)
+	if !strings.HasPrefix(string(data), `{"definitions":{"Node":`) || !strings.Contains(string(data), `"ref":"Tree"`) {
+		t.Errorf("shared Structs should be definitions: %s", data)
+	}
+	if got.ClassName != "Tree" {
+		t.Fatalf("root: %v", got.ClassName)
+	}
+	root := got.Fields["Root"].GetSingleStruct()
+	if root.Fields["Owner"].GetSingleStruct() != got || root.Fields["Children"].GetListStruct().ListFields[0] != root {
+		t.Error("cycles should be restored")
+	}
+	gotShape := got.Fields["Shape"].GetSingleStruct()
+	if gotShape.Discriminator != "kind" || gotShape.ChoiceFor("circle").GetServiceName() != "circleService" {
+		t.Errorf("Shape: %v", gotShape)
+	}
+	if gotShape.ChoiceFor("group").GetFields()["Root"].GetSingleStruct() != root {
+		t.Error("a choice should refer to the shared Node")
+	}
+
+	if _, err := FromStruct(&schema.Struct{Choices: map[string]*schema.Struct{"a": {ClassName: "A"}}}); err == nil {
+		t.Error("expected error for choices without a discriminator")
+	}
+}
+
+func TestRoundTrip_NestedCollections(t *testing.T) {
+	list := func() *schema.Value {
+		cell := &schema.Struct{ClassName: "Cell"}
+		return &schema.Value{Kind: &schema.Value_ListStruct{ListStruct: &schema.ListStruct{ListFields: []*schema.Struct{cell}}}}
+	}
+	spec := &schema.Struct{ClassName: "Board", Fields: map[string]*schema.Value{
+		"Grid":  {Kind: &schema.Value_ListStruct{ListStruct: &schema.ListStruct{ListFields: []*schema.Struct{wrapValue(list())}}}},
+		"Index": {Kind: &schema.Value_MapStruct{MapStruct: &schema.MapStruct{MapFields: map[string]*schema.Struct{"*": wrapValue(list())}}}},
+	}}
+
+	got, data := roundTrip(t, spec)
+	if !proto.Equal(spec, got) {
+		t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", data, got, spec)
+	}
+	for _, want := range []string{
+		`"Grid":{"elements":{"elements":{"metadata":{"className":"Cell"}`,
+		`"Index":{"values":{"elements":{"metadata":{"className":"Cell"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "__schema_") {
+		t.Errorf("wrapper names should not be written:\n%s", data)
+	}
+}
+
+func TestToStruct_PlainJTD(t *testing.T) {
+	doc := `{
+		"definitions": {
+			"circle": {"properties": {"radius": {"type": "float64"}}},
+			"square": {"properties": {"side": {"type": "float64"}}, "metadata": {"className": "Square"}}
+		},
+		"properties": {
+			"name":    {"type": "string"},
+			"primary": {"ref": "circle"},
+			"shapes":  {"elements": {"ref": "square"}},
+			"byName":  {"values": {"ref": "circle"}},
+			"tags":    {"values": {"type": "string"}},
+			"shape": {
+				"discriminator": "kind",
+				"mapping": {"circle": {"properties": {"radius": {"type": "float64"}}}}
+			}
+		}
+	}`
+	parsed, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parsed.ToStruct()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Fields["name"]; ok {
+		t.Error("data fields should be left out")
+	}
+	if _, ok := got.Fields["tags"]; ok {
+		t.Error("maps of data should be left out")
+	}
+	circle := got.Fields["primary"].GetSingleStruct()
+	if circle.GetClassName() != "circle" || got.Fields["byName"].GetMapStruct().StructFor("x") != circle {
+		t.Errorf("refs should share the definition, named after it: %v", got)
+	}
+	if got.Fields["shapes"].GetListStruct().StructAt(3).GetClassName() != "Square" {
+		t.Errorf("metadata className should win: %v", got.Fields["shapes"])
+	}
+	if c := got.Fields["shape"].GetSingleStruct().ChoiceFor("circle"); c.GetClassName() != "circle" {
+		t.Errorf("a mapping entry should be named after its key: %v", c)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{`{"type": "string", "elements": {}}`, "/: schema has more than one form"},
+		{`{"properties": {"a": {"ref": "b"}}}`, `/properties/a: no definition "b"`},
+		{`{"elements": {"definitions": {}}}`, "/elements: definitions are only allowed at the root"},
+		{`{"discriminator": "k", "mapping": {"a": {"type": "string"}}}`, `/: mapping "a" must be a properties form that is not nullable`},
+		{`{"additionalProperties": true}`, "/: additionalProperties requires the properties form"},
+		{`{"colour": "red"}`, `unknown field "colour"`},
+		{`{"properties": {"a": {"type": "foo"}}}`, `/properties/a: unknown type "foo"`},
+		{`{"metadata": {"values": {"colour": "red"}}}`, `metadata "values": json: unknown field "colour"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.doc))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v should contain %q", tt.doc, err, tt.want)
+		}
+	}
+
+	parsed, err := Parse([]byte(`{"definitions": {"a": {"elements": {"ref": "a"}}}, "ref": "a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parsed.ToValue(); err == nil || !strings.Contains(err.Error(), `cyclic ref "a" does not pass through a properties form`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParse_Metadata(t *testing.T) {
+	doc := `{"metadata":{"className":"Item","description":"x","tags":["a"]},"properties":{"a":{"type":"string"}}}`
+	parsed, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Metadata.ClassName != "Item" || string(parsed.Metadata.Other["description"]) != `"x"` {
+		t.Errorf("metadata: %+v", parsed.Metadata)
+	}
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"metadata":{"className":"Item","description":"x","tags":["a"]}`) {
+		t.Errorf("other metadata should be written back: %s", data)
+	}
+}
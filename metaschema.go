@@ -0,0 +1,392 @@
+package schema
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	//go:embed struct.schema.json
+	protoMetaSchemaJSON []byte
+	//go:embed jsm.schema.json
+	jsmMetaSchemaJSON []byte
+)
+
+// DocumentError describes one violation of a meta-schema by a spec document.
+type DocumentError struct {
+	// Pointer locates the offending value as a JSON Pointer (RFC 6901), e.g.
+	// "/fields/Servers/list_struct"; "" is the whole document.
+	Pointer string
+	Message string
+}
+
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("at %q: %s", e.Pointer, e.Message)
+}
+
+// DocumentErrors is the list of violations returned by ValidateSpecDocument,
+// sorted by pointer.
+type DocumentErrors []*DocumentError
+
+func (es DocumentErrors) Error() string {
+	if len(es) == 1 {
+		return "ValidateSpecDocument: " + es[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ValidateSpecDocument: %d problems:", len(es))
+	for _, e := range es {
+		b.WriteString("\n\t")
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the individual violations for errors.As.
+func (es DocumentErrors) Unwrap() []error {
+	errs := make([]error, len(es))
+	for i, e := range es {
+		errs[i] = e
+	}
+	return errs
+}
+
+// ValidateSpecDocument checks a stored spec document against the meta-schema of
+// its form: struct.schema.json for the protobuf JSON form, and jsm.schema.json for
+// the dialect of Struct.MarshalJSON. A zero format is detected with DetectSpecFormat.
+//
+// Both meta-schemas are embedded and interpreted without a JSON Schema library,
+// which catches, before a document is loaded:
+//
+//	╔═════════════════════════════╤═════════════════════════════════════════════════════╗
+//	║ Violation                   │ Example message                                     ║
+//	╠═════════════════════════════╪═════════════════════════════════════════════════════╣
+//	║ unknown key                 │ at "/fields/A/single_strut": unknown key            ║
+//	║ wrong kind                  │ at "/ClassName": must be string, got integer        ║
+//	║ several oneof members set   │ at "/fields/A": only one of "single_struct",        ║
+//	║                             │ "list_struct" may be set                            ║
+//	║ reserved wrapper name       │ at "/ClassName": "__schema_wrapper__" is not        ║
+//	║                             │ allowed: reserved for Values wrapped as Structs     ║
+//	╚═════════════════════════════╧═════════════════════════════════════════════════════╝
+//
+// The reserved names are accepted only in the shape ToProtoJSON writes for a list
+// or map entry that is itself a list or map.
+//
+// All violations are returned as DocumentErrors. Malformed JSON is reported as
+// a plain error. The protobuf JSON form accepts both the proto field names
+// ToProtoJSON writes and their lowerCamel JSON names, as FromProtoJSON does.
+func ValidateSpecDocument(data []byte, format SpecFormat) error {
+	if format == 0 {
+		var err error
+		if format, err = DetectSpecFormat(data); err != nil {
+			return fmt.Errorf("ValidateSpecDocument: %w", err)
+		}
+	}
+	var meta []byte
+	switch format {
+	case FormatProtoJSON:
+		meta = protoMetaSchemaJSON
+	case FormatSchemaJSON:
+		meta = jsmMetaSchemaJSON
+	default:
+		return fmt.Errorf("ValidateSpecDocument: unknown format %s", format)
+	}
+
+	doc, err := decodeJSONValue(data)
+	if err != nil {
+		return fmt.Errorf("ValidateSpecDocument: %w", err)
+	}
+	root, err := decodeJSONValue(meta)
+	if err != nil {
+		return fmt.Errorf("ValidateSpecDocument: meta-schema of %s: %w", format, err)
+	}
+	errs := (&metaSchema{root: root}).check(root, doc, "")
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Pointer < errs[j].Pointer })
+	return errs
+}
+
+// decodeJSONValue decodes a single JSON value, keeping numbers as json.Number.
+func decodeJSONValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+// metaSchema interprets the keywords of draft-07 used by the meta-schemas:
+// $ref to the same document, type, const, enum, not, properties,
+// additionalProperties, propertyNames, required, items and oneOf.
+type metaSchema struct {
+	root any
+}
+
+// check returns the violations of schema by value, located at ptr.
+func (m *metaSchema) check(schema, value any, ptr string) DocumentErrors {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		if schema == false {
+			return DocumentErrors{{Pointer: ptr, Message: "no value is allowed"}}
+		}
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		// As in draft-07, $ref replaces the other keywords of its schema.
+		target, err := m.resolve(ref)
+		if err != nil {
+			return DocumentErrors{{Pointer: ptr, Message: err.Error()}}
+		}
+		return m.check(target, value, ptr)
+	}
+
+	if t, ok := s["type"]; ok && !hasJSONType(value, t) {
+		return DocumentErrors{{Pointer: ptr, Message: fmt.Sprintf("must be %s, got %s", typeNames(t), jsonType(value))}}
+	}
+	var errs DocumentErrors
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		errs = append(errs, &DocumentError{Pointer: ptr, Message: fmt.Sprintf("must be %s", jsonText(c))})
+	}
+	if enum, ok := s["enum"].([]any); ok && !containsJSON(enum, value) {
+		errs = append(errs, &DocumentError{Pointer: ptr, Message: fmt.Sprintf("must be one of %s", jsonText(enum))})
+	}
+	if not, ok := s["not"]; ok && len(m.check(not, value, ptr)) == 0 {
+		msg := fmt.Sprintf("%s is not allowed", jsonText(value))
+		if n, ok := not.(map[string]any); ok && n["description"] != nil {
+			desc, _ := n["description"].(string)
+			msg += ": " + desc
+		}
+		errs = append(errs, &DocumentError{Pointer: ptr, Message: msg})
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := s["properties"].(map[string]any)
+		for _, key := range sortedKeys(v) {
+			child := ptr + "/" + escapePointer(key)
+			if names, ok := s["propertyNames"]; ok {
+				errs = append(errs, m.check(names, key, child)...)
+			}
+			if p, ok := props[key]; ok {
+				errs = append(errs, m.check(p, v[key], child)...)
+				continue
+			}
+			switch extra := s["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					errs = append(errs, &DocumentError{Pointer: child, Message: "unknown key"})
+				}
+			case map[string]any:
+				errs = append(errs, m.check(extra, v[key], child)...)
+			}
+		}
+		required, _ := s["required"].([]any)
+		for _, r := range required {
+			if key, ok := r.(string); ok {
+				if _, ok := v[key]; !ok {
+					errs = append(errs, &DocumentError{Pointer: ptr, Message: fmt.Sprintf("missing key %q", key)})
+				}
+			}
+		}
+	case []any:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				errs = append(errs, m.check(items, item, fmt.Sprintf("%s/%d", ptr, i))...)
+			}
+		}
+	}
+
+	if branches, ok := s["oneOf"].([]any); ok {
+		errs = append(errs, m.oneOf(branches, value, ptr)...)
+	}
+	return errs
+}
+
+// oneOf returns the violations of the oneOf keyword. Branches that each require
+// a single key, like the members of a protobuf oneof, are reported by key; for
+// other branches, a value matching none gets the violations of the closest one.
+func (m *metaSchema) oneOf(branches []any, value any, ptr string) DocumentErrors {
+	var matched []int
+	results := make([]DocumentErrors, len(branches))
+	for i, b := range branches {
+		results[i] = m.check(b, value, ptr)
+		if len(results[i]) == 0 {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 1 {
+		return nil
+	}
+
+	keys := oneOfKeys(branches)
+	obj, isObject := value.(map[string]any)
+	if keys == nil || !isObject {
+		if len(matched) == 0 {
+			// Report the branch the value comes closest to, e.g. a Struct
+			// rather than a wrapped Value, preferring the first on a tie.
+			best := results[0]
+			for _, r := range results[1:] {
+				if len(r) < len(best) {
+					best = r
+				}
+			}
+			return best
+		}
+		return DocumentErrors{{Pointer: ptr, Message: fmt.Sprintf("must match exactly one of %d schemas, matched %d", len(branches), len(matched))}}
+	}
+	var set []int
+	for i, key := range keys {
+		if _, ok := obj[key]; ok {
+			set = append(set, i)
+		}
+	}
+	switch len(set) {
+	case 0:
+		errs := DocumentErrors{{Pointer: ptr, Message: fmt.Sprintf("one of %s must be set", quoteList(keys))}}
+		for _, key := range sortedKeys(obj) {
+			errs = append(errs, &DocumentError{Pointer: ptr + "/" + escapePointer(key), Message: "unknown key"})
+		}
+		return errs
+	case 1:
+		return results[set[0]]
+	default:
+		names := make([]string, len(set))
+		for i, j := range set {
+			names[i] = keys[j]
+		}
+		return DocumentErrors{{Pointer: ptr, Message: fmt.Sprintf("only one of %s may be set", quoteList(names))}}
+	}
+}
+
+// oneOfKeys returns the key required by each branch, or nil unless every
+// branch requires exactly one key.
+func oneOfKeys(branches []any) []string {
+	keys := make([]string, len(branches))
+	for i, b := range branches {
+		s, _ := b.(map[string]any)
+		required, _ := s["required"].([]any)
+		if len(required) != 1 {
+			return nil
+		}
+		if keys[i], _ = required[0].(string); keys[i] == "" {
+			return nil
+		}
+	}
+	return keys
+}
+
+// resolve returns the schema at the JSON Pointer fragment ref, e.g. "#/definitions/Value".
+func (m *metaSchema) resolve(ref string) (any, error) {
+	fragment, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("meta-schema reference %q is not local", ref)
+	}
+	node := m.root
+	if fragment == "" {
+		return node, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("meta-schema reference %q not found", ref)
+		}
+		if node, ok = obj[unescapePointer(token)]; !ok {
+			return nil, fmt.Errorf("meta-schema reference %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+// hasJSONType reports whether value is of the type t, a name or a list of names.
+func hasJSONType(value, t any) bool {
+	names, ok := t.([]any)
+	if !ok {
+		names = []any{t}
+	}
+	actual := jsonType(value)
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type name of a decoded value.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// typeNames returns the type keyword t for messages, e.g. "object or boolean".
+func typeNames(t any) string {
+	names, ok := t.([]any)
+	if !ok {
+		return fmt.Sprint(t)
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprint(name)
+	}
+	return strings.Join(parts, " or ")
+}
+
+func containsJSON(list []any, value any) bool {
+	for _, x := range list {
+		if reflect.DeepEqual(x, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonText(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func quoteList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// escapePointer escapes a key as a JSON Pointer reference token.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+func unescapePointer(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
@@ -0,0 +1,186 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestValidateSpecDocument_Valid(t *testing.T) {
+	spec, err := NewServiceStruct("Config", map[string]any{
+		"Database": []string{"PostgresDB", "dbService"},
+		"Servers":  [][]string{{"HTTPServer"}, {"GRPCServer"}},
+		"Handlers": map[string][]string{"*": {"WebHandler"}},
+		"Grid":     map[[2]string][]string{{"r1", "k1"}: {"Cell"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Fields["Shape"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{
+		Discriminator: "kind",
+		Choices:       map[string]*Struct{"circle": {ClassName: "Circle"}, "square": {ClassName: "Square"}},
+	}}}
+	protoJSON, err := ToProtoJSON(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaJSON, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cyclicJSON, err := json.Marshal(newCyclicTree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FromProtoJSON also reads the lowerCamel JSON names protojson writes by default.
+	camelJSON, err := protojson.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(camelJSON), `"listStruct"`) {
+		t.Fatalf("expected lowerCamel names: %s", camelJSON)
+	}
+	for _, data := range [][]byte{protoJSON, camelJSON, schemaJSON, cyclicJSON, []byte(`{}`)} {
+		if err := ValidateSpecDocument(data, 0); err != nil {
+			t.Errorf("%s\n%v", data, err)
+		}
+	}
+}
+
+func TestValidateSpecDocument_NestedCollections(t *testing.T) {
+	data, err := ToProtoJSON(newNestedSpec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"__schema_value__"`) {
+		t.Fatalf("nested lists should be written as wrappers: %s", data)
+	}
+	if err := ValidateSpecDocument(data, 0); err != nil {
+		t.Errorf("%s\n%v", data, err)
+	}
+}
+
+func TestValidateSpecDocument_Violations(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format SpecFormat
+		want   []string
+	}{
+		{
+			"unknown keys",
+			`{"ClassName": "Config", "fields": {"A": {"single_strut": {}}, "B": {"list_struct": {"list_fields": [{"Service": "s"}]}}}}`,
+			FormatProtoJSON,
+			[]string{
+				`at "/fields/A": one of "single_struct", "singleStruct", "list_struct", "listStruct", "map_struct", "mapStruct", "map2_struct", "map2Struct" must be set`,
+				`at "/fields/A/single_strut": unknown key`,
+				`at "/fields/B/list_struct/list_fields/0/Service": unknown key`,
+			},
+		},
+		{
+			"wrong kinds",
+			`{"ClassName": 1, "fields": {"A": {"map_struct": {"map_fields": []}}}}`,
+			FormatProtoJSON,
+			[]string{
+				`at "/ClassName": must be string, got integer`,
+				`at "/fields/A/map_struct/map_fields": must be object, got array`,
+			},
+		},
+		{
+			"oneof members",
+			`{"fields": {"A": {"single_struct": {}, "list_struct": {}}}}`,
+			FormatProtoJSON,
+			[]string{`at "/fields/A": only one of "single_struct", "list_struct" may be set`},
+		},
+		{
+			"oneof member under both names",
+			`{"fields": {"A": {"single_struct": {}, "singleStruct": {}}}}`,
+			FormatProtoJSON,
+			[]string{`at "/fields/A": only one of "single_struct", "singleStruct" may be set`},
+		},
+		{
+			"reserved names",
+			`{"ClassName": "__schema_wrapper__", "ServiceName": "__schema_wrapper_service__", "fields": {"__schema_value__": {"single_struct": {}}}}`,
+			FormatProtoJSON,
+			[]string{
+				`at "/ClassName": "__schema_wrapper__" is not allowed: reserved for Values wrapped as Structs`,
+				`at "/ServiceName": "__schema_wrapper_service__" is not allowed: reserved for Values wrapped as Structs`,
+				`at "/fields/__schema_value__": "__schema_value__" is not allowed: reserved for Values wrapped as Structs`,
+			},
+		},
+		{
+			"reserved names in an entry",
+			`{"fields": {"A": {"list_struct": {"list_fields": [
+				{"ClassName": "__schema_wrapper__", "fields": {"B": {"single_struct": {}}}},
+				{"ClassName": "__schema_wrapper__", "ServiceName": "__schema_wrapper_service__", "fields": {"__schema_value__": {"single_struct": {}}}}
+			]}}}}`,
+			FormatProtoJSON,
+			[]string{
+				`at "/fields/A/list_struct/list_fields/0/ClassName": "__schema_wrapper__" is not allowed: reserved for Values wrapped as Structs`,
+				`at "/fields/A/list_struct/list_fields/1/fields/__schema_value__": one of "list_struct", "listStruct", "map_struct", "mapStruct", "map2_struct", "map2Struct" must be set`,
+				`at "/fields/A/list_struct/list_fields/1/fields/__schema_value__/single_struct": unknown key`,
+			},
+		},
+		{
+			"dialect",
+			`{"className": "Config", "properties": {"a/b": {"classname": "X"}, "C": {"items": [true]}, "D": {"x-map": "yes"}}, "discriminator": {"property": "kind"}}`,
+			0,
+			[]string{
+				`at "/discriminator/property": unknown key`,
+				`at "/properties/C/items": must be object or boolean, got array`,
+				`at "/properties/D/x-map": must be boolean, got string`,
+				`at "/properties/a~1b/classname": unknown key`,
+			},
+		},
+		{
+			"dialect reserved names",
+			`{"className": "__schema_wrapper__", "properties": {"__schema_value__": {}}}`,
+			FormatSchemaJSON,
+			[]string{
+				`at "/className": "__schema_wrapper__" is not allowed: reserved for Values wrapped as Structs`,
+				`at "/properties/__schema_value__": "__schema_value__" is not allowed: reserved for Values wrapped as Structs`,
+			},
+		},
+		{
+			"document kind",
+			`[]`,
+			FormatSchemaJSON,
+			[]string{`at "": must be object or boolean, got array`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSpecDocument([]byte(tt.data), tt.format)
+			var errs DocumentErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("got %v, want DocumentErrors", err)
+			}
+			got := make([]string, len(errs))
+			for i, e := range errs {
+				got[i] = e.Error()
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateSpecDocument_Errors(t *testing.T) {
+	err := ValidateSpecDocument([]byte(`{"ClassName": 1, "ServiceName": 2}`), FormatProtoJSON)
+	want := "ValidateSpecDocument: 2 problems:\n" +
+		"\tat \"/ClassName\": must be string, got integer\n" +
+		"\tat \"/ServiceName\": must be string, got integer"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+	if err := ValidateSpecDocument([]byte(`{"ClassName": `), FormatProtoJSON); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+	if err := ValidateSpecDocument([]byte(`{}`), SpecFormat(7)); err == nil || err.Error() != "ValidateSpecDocument: unknown format SpecFormat(7)" {
+		t.Errorf("got %v", err)
+	}
+}
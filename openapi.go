@@ -0,0 +1,230 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// openAPIDocument is the part of an OpenAPI 3.0 or 3.1 document read by OpenAPIStructs.
+type openAPIDocument struct {
+	OpenAPI    string `json:"openapi"`
+	Components struct {
+		Schemas map[string]*jsonSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// OpenAPIStructs builds a Struct for each named schema in components.schemas of
+// an OpenAPI 3.0 or 3.1 document in JSON. Without names, every component schema
+// that describes a class is converted. The result is keyed by component name.
+//
+// Component schemas are read as in JSMServiceStructWithOptions with Choices, with
+// these OpenAPI conventions:
+//   - the class name of a component schema is its component name, unless it has
+//     a className or is of a primitive type, e.g. {"type": "string"}
+//   - a $ref to "#/components/schemas/X" is the class X, shared between Structs
+//   - allOf members are merged into their schema: a subschema such as
+//     {"allOf": [{"$ref": "#/components/schemas/Pet"}, {"properties": {...}}]}
+//     has the properties of Pet and its own, but not Pet's discriminator
+//   - discriminator.mapping gives the choices of a oneOf or anyOf; on a schema
+//     without them, such as the base schema of an allOf hierarchy, the mapped
+//     schemas are the choices. Bare mapping values, e.g. "Dog", name components
+//   - the x-service-name extension sets ServiceName
+//   - schemas that name no class, e.g. {"type": "string"}, are left out
+func OpenAPIStructs(data []byte, names ...string) (map[string]*Struct, error) {
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") && doc.OpenAPI != "" {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", doc.OpenAPI)
+	}
+	schemas := doc.Components.Schemas
+	explicit := len(names) > 0
+	if !explicit {
+		names = sortedKeys(schemas)
+	}
+
+	seen := make(map[*jsonSchema]bool)
+	for _, name := range sortedKeys(schemas) {
+		js := schemas[name]
+		if js == nil {
+			return nil, fmt.Errorf("component %q: nil schema", name)
+		}
+		if js.ClassName == "" && !isPrimitiveType(js.Type) {
+			js.ClassName = name
+		}
+		walkSchemas(js, seen, func(x *jsonSchema) {
+			if x.ServiceName == "" {
+				x.ServiceName = x.XServiceName
+			}
+		})
+	}
+	var err error
+	seen = make(map[*jsonSchema]bool)
+	for _, name := range sortedKeys(schemas) {
+		walkSchemas(schemas[name], seen, func(x *jsonSchema) {
+			if e := mergeAllOf(x, schemas); e != nil && err == nil {
+				err = fmt.Errorf("component %q: %w", name, e)
+			}
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &schemaConverter{
+		root:       &jsonSchema{},
+		choices:    true,
+		openAPI:    true,
+		components: schemas,
+		converted:  make(map[*jsonSchema]*Value),
+		resolving:  make(map[*jsonSchema]bool),
+	}
+	structs := make(map[string]*Struct, len(names))
+	for _, name := range names {
+		js, ok := schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("component %q not found", name)
+		}
+		v, err := c.toValue(js)
+		if err != nil {
+			return nil, fmt.Errorf("component %q: %w", name, err)
+		}
+		s := v.GetSingleStruct()
+		if s == nil {
+			if !explicit {
+				continue
+			}
+			return nil, fmt.Errorf("component %q must be an object schema", name)
+		}
+		structs[name] = s
+	}
+	return structs, nil
+}
+
+// OpenAPIStructsFromFile reads an OpenAPI document in JSON from path and calls
+// OpenAPIStructs.
+func OpenAPIStructsFromFile(path string, names ...string) (map[string]*Struct, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenAPIStructs(data, names...)
+}
+
+// ToOpenAPIComponents writes specs as the component schemas of an OpenAPI 3.1
+// document, {"components": {"schemas": {...}}}, to be merged into a full document.
+//
+// Each spec is the component named by its ClassName, and every class it refers to
+// becomes a component too, as in ToStandardJSONSchema. ServiceName is written as
+// x-service-name, and Choices as a oneOf with a discriminator whose mapping lists
+// the component of each choice. Maps with explicit keys are marked x-map, and
+// two-level maps x-map2, so that OpenAPIStructs reads them back as maps.
+func ToOpenAPIComponents(specs ...*Struct) ([]byte, error) {
+	e := newStandardExporter(nil, &StandardOptions{}, "#/components/schemas/")
+	e.openAPI = true
+	for _, s := range specs {
+		if s == nil || s.ClassName == "" {
+			return nil, fmt.Errorf("every spec needs a class name to name its component")
+		}
+		if _, ok := unwrapValueFromStruct(s); ok {
+			return nil, fmt.Errorf("a wrapped Value cannot be a component")
+		}
+		if _, done := e.names[s]; done {
+			continue
+		}
+		if name := e.nameAs(s, s.ClassName); name != s.ClassName {
+			return nil, fmt.Errorf("specs of class %q differ", s.ClassName)
+		}
+	}
+	e.assignNames(specs...)
+	for _, s := range specs {
+		if _, err := e.structRef(s); err != nil {
+			return nil, fmt.Errorf("component %q: %w", s.ClassName, err)
+		}
+	}
+
+	schemas := make(map[string]any, len(e.definitions))
+	for name, def := range e.definitions {
+		schemas[name] = def
+	}
+	return json.Marshal(map[string]any{"components": map[string]any{"schemas": schemas}})
+}
+
+// isPrimitiveType reports whether the JSON Schema "type" raw is a type other
+// than object and array, or a list of such types.
+func isPrimitiveType(raw json.RawMessage) bool {
+	if len(raw) == 0 {
+		return false
+	}
+	var types []string
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		types = []string{single}
+	} else if err := json.Unmarshal(raw, &types); err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == "object" || t == "array" {
+			return false
+		}
+	}
+	return len(types) > 0
+}
+
+// walkSchemas calls fn on js and every schema nested in it, once each.
+func walkSchemas(js *jsonSchema, seen map[*jsonSchema]bool, fn func(*jsonSchema)) {
+	if js == nil || seen[js] {
+		return
+	}
+	seen[js] = true
+	fn(js)
+	for _, group := range []map[string]*jsonSchema{js.Properties, js.Definitions, js.Defs} {
+		for _, x := range group {
+			walkSchemas(x, seen, fn)
+		}
+	}
+	walkSchemas(js.Items, seen, fn)
+	walkSchemas(js.AdditionalProperties, seen, fn)
+	for _, list := range [][]*jsonSchema{js.PrefixItems, js.OneOf, js.AnyOf, js.AllOf} {
+		for _, x := range list {
+			walkSchemas(x, seen, fn)
+		}
+	}
+}
+
+// mergeAllOf merges the members of the allOf of js into js: a component $ref or
+// an inline schema, whose own allOf is merged first. Properties of js win over
+// those of its members, and earlier members over later ones; class names,
+// service names and discriminators are not inherited.
+func mergeAllOf(js *jsonSchema, schemas map[string]*jsonSchema) error {
+	members := js.AllOf
+	// Clear allOf first, so that a cycle of allOf ends.
+	js.AllOf = nil
+	for i, m := range members {
+		if m == nil {
+			continue
+		}
+		if m.Ref != "" {
+			name, ok := strings.CutPrefix(m.Ref, "#/components/schemas/")
+			if m = schemas[name]; !ok || m == nil {
+				return fmt.Errorf("allOf[%d]: $ref %q is not a component", i, members[i].Ref)
+			}
+		}
+		if err := mergeAllOf(m, schemas); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(m.Properties) {
+			if _, ok := js.Properties[key]; ok {
+				continue
+			}
+			if js.Properties == nil {
+				js.Properties = make(map[string]*jsonSchema)
+			}
+			js.Properties[key] = m.Properties[key]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const petStoreOpenAPI = `{
+	"openapi": "3.1.0",
+	"info": {"title": "Pets", "version": "1"},
+	"paths": {},
+	"components": {
+		"schemas": {
+			"Id": {"type": "string"},
+			"Owner": {
+				"type": "object",
+				"x-service-name": "ownerService",
+				"properties": {
+					"id": {"$ref": "#/components/schemas/Id"},
+					"pet": {
+						"oneOf": [{"$ref": "#/components/schemas/Dog"}, {"$ref": "#/components/schemas/Cat"}],
+						"discriminator": {"propertyName": "petType", "mapping": {"dog": "#/components/schemas/Dog", "cat": "Cat"}}
+					},
+					"pets": {"type": "array", "items": {"$ref": "#/components/schemas/Pet"}},
+					"tags": {"type": "object", "additionalProperties": {"type": "string"}}
+				}
+			},
+			"Pet": {
+				"type": "object",
+				"properties": {"petType": {"type": "string"}, "name": {"type": "string"}},
+				"discriminator": {"propertyName": "petType", "mapping": {"dog": "Dog", "cat": "#/components/schemas/Cat"}}
+			},
+			"Dog": {"allOf": [{"$ref": "#/components/schemas/Pet"}, {"properties": {"bark": {"type": "boolean"}}}]},
+			"Cat": {"type": "object", "x-service-name": "catService", "properties": {"petType": {"const": "cat"}}}
+		}
+	}
+}`
+
+func TestOpenAPIStructs(t *testing.T) {
+	structs, err := OpenAPIStructs([]byte(petStoreOpenAPI), "Owner", "Pet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(structs) != 2 {
+		t.Fatalf("got %d structs", len(structs))
+	}
+
+	owner := structs["Owner"]
+	if owner.ClassName != "Owner" || owner.ServiceName != "ownerService" {
+		t.Errorf("Owner: %v", owner)
+	}
+	for _, name := range []string{"id", "tags"} {
+		if _, ok := owner.Fields[name]; ok {
+			t.Errorf("data field %q should be left out", name)
+		}
+	}
+	pet := owner.Fields["pet"].GetSingleStruct()
+	if pet.Discriminator != "petType" || pet.ChoiceFor("dog").GetClassName() != "Dog" {
+		t.Errorf("pet: %v", pet)
+	}
+	if cat := pet.ChoiceFor("cat"); cat.GetClassName() != "Cat" || cat.ServiceName != "catService" {
+		t.Errorf("pet cat: %v", cat)
+	}
+
+	base := structs["Pet"]
+	if owner.Fields["pets"].GetListStruct().StructAt(0) != base {
+		t.Error("a $ref to a component should share its Struct")
+	}
+	if base.ClassName != "Pet" || base.Discriminator != "petType" || base.ChoiceFor("dog") != pet.ChoiceFor("dog") || base.ChoiceFor("cat") != pet.ChoiceFor("cat") {
+		t.Errorf("a base schema should take its choices from the mapping: %v", base)
+	}
+
+	all, err := OpenAPIStructs([]byte(petStoreOpenAPI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all["Id"]; ok || len(all) != 4 {
+		t.Errorf("every object component should be converted, got %v", sortedKeys(all))
+	}
+}
+
+func TestOpenAPIStructs_AllOf(t *testing.T) {
+	doc := `{
+		"openapi": "3.1.0",
+		"components": {
+			"schemas": {
+				"Pet": {
+					"properties": {"owner": {"$ref": "#/components/schemas/Owner"}, "home": {"$ref": "#/components/schemas/Owner"}},
+					"discriminator": {"propertyName": "petType", "mapping": {"dog": "Dog"}}
+				},
+				"Dog": {
+					"x-service-name": "dogService",
+					"properties": {"home": {"$ref": "#/components/schemas/Kennel"}},
+					"allOf": [
+						{"$ref": "#/components/schemas/Pet"},
+						{"properties": {"toy": {"$ref": "#/components/schemas/Toy"}}}
+					]
+				},
+				"Owner": {"type": "object"},
+				"Kennel": {"type": "object"},
+				"Toy": {"type": "object"}
+			}
+		}
+	}`
+	structs, err := OpenAPIStructs([]byte(doc), "Dog", "Pet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog := structs["Dog"]
+	if dog.ClassName != "Dog" || dog.ServiceName != "dogService" || len(dog.Choices) != 0 {
+		t.Errorf("Dog should keep its own class without the base discriminator: %v", dog)
+	}
+	for field, class := range map[string]string{"owner": "Owner", "home": "Kennel", "toy": "Toy"} {
+		if got := dog.Fields[field].GetSingleStruct().GetClassName(); got != class {
+			t.Errorf("Dog.%s = %q, want %q", field, got, class)
+		}
+	}
+	if structs["Pet"].ChoiceFor("dog") != dog {
+		t.Errorf("the base should still take its choices from the mapping: %v", structs["Pet"])
+	}
+}
+
+func TestOpenAPIStructs_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		names []string
+		want  string
+	}{
+		{"version", `{"openapi": "2.0"}`, nil, `unsupported OpenAPI version "2.0"`},
+		{"missing", petStoreOpenAPI, []string{"Bird"}, `component "Bird" not found`},
+		{"primitive", petStoreOpenAPI, []string{"Id"}, `component "Id" must be an object schema`},
+		{
+			"bad ref",
+			`{"openapi": "3.0.3", "components": {"schemas": {"A": {"properties": {"b": {"$ref": "#/components/schemas/B"}}}}}}`,
+			nil,
+			`component "A": in property "b": cannot resolve $ref "#/components/schemas/B": no schema at /components/schemas/B`,
+		},
+		{
+			"bad allOf",
+			`{"openapi": "3.0.3", "components": {"schemas": {"A": {"allOf": [{"$ref": "#/definitions/B"}]}}}}`,
+			nil,
+			`component "A": allOf[0]: $ref "#/definitions/B" is not a component`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := OpenAPIStructs([]byte(tt.doc), tt.names...)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestToOpenAPIComponents_RoundTrip(t *testing.T) {
+	circle := &Struct{ClassName: "Circle", ServiceName: "circleService"}
+	shape := &Struct{Discriminator: "kind", Choices: map[string]*Struct{
+		"circle": circle,
+		"square": {ClassName: "Square"},
+	}}
+	drawing := &Struct{ClassName: "Drawing", Fields: map[string]*Value{
+		"Main":    {Kind: &Value_SingleStruct{SingleStruct: shape}},
+		"Circles": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{circle}}}},
+		"Layers":  {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{circle, {ClassName: "Square"}}}}},
+		"ByName":  {Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"c": circle, "*": {ClassName: "Square"}}}}},
+		"Any":     {Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"*": circle}}}},
+		"Grid": {Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map[string]*MapStruct{
+			"r1": {MapFields: map[string]*Struct{"k1": circle}},
+			"*":  {MapFields: map[string]*Struct{"*": {ClassName: "Square"}}},
+		}}}},
+	}}
+	canvas := &Struct{ClassName: "Canvas", Fields: map[string]*Value{
+		"Drawing": {Kind: &Value_SingleStruct{SingleStruct: drawing}},
+	}}
+
+	data, err := ToOpenAPIComponents(drawing, canvas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"discriminator":{"mapping":{"circle":"#/components/schemas/Circle","square":"#/components/schemas/Square"},"propertyName":"kind"}`,
+		`"x-service-name":"circleService"`,
+		`"Drawing":{"$ref":"#/components/schemas/Drawing"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "openapi.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	structs, err := OpenAPIStructsFromFile(path, "Drawing", "Canvas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := structs["Drawing"]
+	if structs["Canvas"].Fields["Drawing"].GetSingleStruct() != got {
+		t.Error("Canvas should refer to the same Drawing")
+	}
+	// The "*" fallback of the "*" region is copied into region "r1", which
+	// Map2Struct.StructFor resolves the same way.
+	want := &Struct{ClassName: "Drawing", Fields: map[string]*Value{}}
+	for name, v := range drawing.Fields {
+		want.Fields[name] = v
+	}
+	want.Fields["Grid"] = &Value{Kind: &Value_Map2Struct{Map2Struct: &Map2Struct{Map2Fields: map[string]*MapStruct{
+		"r1": {MapFields: map[string]*Struct{"k1": circle, "*": {ClassName: "Square"}}},
+		"*":  {MapFields: map[string]*Struct{"*": {ClassName: "Square"}}},
+	}}}}
+	if !sameSpec(want, got) {
+		t.Errorf("components did not round-trip:\n%s\ngot %v", data, got)
+	}
+	if got.Fields["Circles"].GetListStruct().StructAt(0).ServiceName != "circleService" {
+		t.Error("x-service-name should round-trip")
+	}
+
+	if _, err := ToOpenAPIComponents(&Struct{}); err == nil {
+		t.Error("expected error for a spec without class name")
+	}
+	if _, err := ToOpenAPIComponents(circle, &Struct{ClassName: "Circle", Fields: map[string]*Value{"A": {}}}); err == nil {
+		t.Error("expected error for different specs of one class")
+	}
+}
+
+func TestToOpenAPIComponents_NestedCollections(t *testing.T) {
+	data, err := ToOpenAPIComponents(newNestedSpec(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for name := range doc.Components.Schemas {
+		if name != "Board" && name != "Cell" {
+			t.Errorf("unexpected component %q", name)
+		}
+	}
+	for _, reserved := range []string{wrapperClassName, wrapperServiceName, wrapperFieldName} {
+		if strings.Contains(string(data), reserved) {
+			t.Errorf("components should not contain %s: %s", reserved, data)
+		}
+	}
+	if !strings.Contains(string(data), `"Grid":{"items":{"items":{"$ref":"#/components/schemas/Cell"},"type":"array"},"type":"array"}`) {
+		t.Errorf("Grid should be an array of arrays: %s", data)
+	}
+
+	structs, err := OpenAPIStructs(data, "Board")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameSpec(newNestedSpec(t), structs["Board"]) {
+		t.Errorf("nested collections did not round-trip: %v", structs["Board"])
+	}
+
+	if _, err := ToOpenAPIComponents(wrapValueAsStruct(newNestedSpec(t).Fields["Grid"])); err == nil {
+		t.Error("expected error for a wrapped Value as a component")
+	}
+}
@@ -0,0 +1,196 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ProtoOptions configures UnmarshalProtoWithSpec.
+type ProtoOptions struct {
+	// Registry instantiates the classes; nil means DefaultRegistry.
+	Registry *Registry
+	// TypeURLs maps the type URL of an Any, e.g. "type.googleapis.com/shapes.v1.Circle",
+	// to a class name. Type URLs not in the map name the class after their full
+	// message name, "shapes.v1.Circle", as StructFromDescriptor does.
+	TypeURLs map[string]string
+	// JSON renders the message before decoding: with UseProtoNames for Go fields
+	// tagged with the proto field names, or with a Resolver of Any payloads not
+	// in protoregistry.GlobalTypes.
+	JSON protojson.MarshalOptions
+}
+
+// UnmarshalProtoWithSpec decodes protobuf data, typically a *structpb.Struct or
+// an *anypb.Any from an upstream gRPC service, into target, using spec to choose
+// the concrete class of every interface field as UnmarshalJSONWithSpec does.
+//
+// The message is decoded from its protobuf JSON form, so the same spec and Go
+// types serve JSON and protobuf data. An Any is an object with an "@type"
+// member and the fields of its payload; where the spec has a Struct without a
+// ClassName, or Choices without a Discriminator, the type URL names the class
+// through opts.TypeURLs:
+//
+//	spec, _ := ParseSpec(`Config{Shape: ""}`)
+//	// {"Shape": {"@type": "type.googleapis.com/shapes.v1.Circle", "radius": 2}}
+//	err := UnmarshalProtoWithSpec(data, &cfg, spec, nil) // cfg.Shape is a *Circle
+//
+// The 64-bit integers that protojson quotes are decoded as numbers, so they fill
+// Go integer fields. Members are matched to Go fields as by encoding/json: the
+// lowerCamel names protojson writes by default match untagged fields
+// case-insensitively. The payload of an Any is decoded into its class without
+// nested specs. With a nil spec, only an Any at the top is resolved.
+func UnmarshalProtoWithSpec(m proto.Message, target any, spec *Struct, opts *ProtoOptions) error {
+	if m == nil || !m.ProtoReflect().IsValid() {
+		return fmt.Errorf("UnmarshalProtoWithSpec: nil message")
+	}
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("UnmarshalProtoWithSpec: target must be a non-nil pointer, got %T", target)
+	}
+	if opts == nil {
+		opts = &ProtoOptions{}
+	}
+	data, err := opts.JSON.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("UnmarshalProtoWithSpec: %w", err)
+	}
+	if data, err = unquoteInt64s(data, m.ProtoReflect(), opts.JSON); err != nil {
+		return fmt.Errorf("UnmarshalProtoWithSpec: %w", err)
+	}
+
+	d := &jsonSpecDecoder{reg: registryOrDefault(opts.Registry), typeURLs: opts.TypeURLs}
+	if spec == nil {
+		spec = &Struct{}
+	}
+	if err := d.decodeSingle(data, rv.Elem(), spec, rootPath(spec)); err != nil {
+		return fmt.Errorf("UnmarshalProtoWithSpec: %w", err)
+	}
+	return nil
+}
+
+// unquoteInt64s rewrites the quoted 64-bit integers in data, the protobuf JSON
+// form of m written with o, as JSON numbers.
+func unquoteInt64s(data []byte, m protoreflect.Message, o protojson.MarshalOptions) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	resolver := o.Resolver
+	if resolver == nil {
+		resolver = protoregistry.GlobalTypes
+	}
+	u := &int64Unquoter{resolver: resolver, protoNames: o.UseProtoNames}
+	return json.Marshal(u.message(v, m))
+}
+
+// int64Unquoter walks the protobuf JSON form of a message along with the message.
+type int64Unquoter struct {
+	resolver interface {
+		FindMessageByURL(string) (protoreflect.MessageType, error)
+	}
+	protoNames bool
+}
+
+func (u *int64Unquoter) message(v any, m protoreflect.Message) any {
+	md := m.Descriptor()
+	switch md.FullName() {
+	case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return number(v)
+	case "google.protobuf.Any":
+		obj, ok := v.(map[string]any)
+		payload := u.anyPayload(m)
+		if !ok || payload == nil {
+			return v
+		}
+		if strings.HasPrefix(string(payload.Descriptor().FullName()), "google.protobuf.") {
+			// Well-known payloads are written in "value".
+			if x, ok := obj["value"]; ok {
+				obj["value"] = u.message(x, payload)
+				return obj
+			}
+		}
+		u.fields(obj, payload)
+		return obj
+	}
+	if obj, ok := v.(map[string]any); ok {
+		u.fields(obj, m)
+	}
+	return v
+}
+
+// anyPayload returns the message held by the Any m, or nil if it cannot be resolved.
+func (u *int64Unquoter) anyPayload(m protoreflect.Message) protoreflect.Message {
+	fields := m.Descriptor().Fields()
+	mt, err := u.resolver.FindMessageByURL(m.Get(fields.ByName("type_url")).String())
+	if err != nil {
+		return nil
+	}
+	payload := mt.New()
+	if err := proto.Unmarshal(m.Get(fields.ByName("value")).Bytes(), payload.Interface()); err != nil {
+		return nil
+	}
+	return payload
+}
+
+func (u *int64Unquoter) fields(obj map[string]any, m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		name := fd.JSONName()
+		if u.protoNames {
+			name = fd.TextName()
+		}
+		x, ok := obj[name]
+		if !ok || !m.Has(fd) {
+			continue
+		}
+		switch val := m.Get(fd); {
+		case fd.IsList():
+			items, _ := x.([]any)
+			list := val.List()
+			for j := range items {
+				if j < list.Len() {
+					items[j] = u.single(items[j], fd, list.Get(j))
+				}
+			}
+		case fd.IsMap():
+			entries, _ := x.(map[string]any)
+			val.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				if e, ok := entries[k.String()]; ok {
+					entries[k.String()] = u.single(e, fd.MapValue(), mv)
+				}
+				return true
+			})
+		default:
+			obj[name] = u.single(x, fd, val)
+		}
+	}
+}
+
+func (u *int64Unquoter) single(v any, fd protoreflect.FieldDescriptor, val protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return number(v)
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return u.message(v, val.Message())
+	}
+	return v
+}
+
+// number returns the quoted integer v as a JSON number.
+func number(v any) any {
+	if s, ok := v.(string); ok {
+		return json.Number(s)
+	}
+	return v
+}
@@ -0,0 +1,219 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type protoPoint struct {
+	X int32 `json:"x"`
+}
+
+func TestUnmarshalProtoWithSpec_Structpb(t *testing.T) {
+	reg := newDecRegistry(t)
+	if err := RegisterTo[decCircle](reg, "shapes.v1.Circle"); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := NewStruct("Geo", map[string]any{"Fallback": "Square"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Structs without a class, as for the Any fields of StructFromDescriptor.
+	spec.Fields["Primary"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{}}}
+	spec.Fields["Shapes"] = &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{{}}}}}
+	spec.Fields["Canvas"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "Canvas", Fields: map[string]*Value{
+		"Shape": {Kind: &Value_SingleStruct{SingleStruct: &Struct{Choices: map[string]*Struct{
+			"round": {ClassName: "Circle"},
+			"box":   {ClassName: "Square"},
+		}}}},
+	}}}}
+	data, err := structpb.NewStruct(map[string]any{
+		"Title":   "demo",
+		"Primary": map[string]any{"@type": "type.googleapis.com/shapes.v1.Circle", "radius": 2},
+		"Shapes": []any{
+			map[string]any{"@type": "type.googleapis.com/legacy.Box", "Side": 3},
+			map[string]any{"@type": "Circle", "radius": 1},
+		},
+		"Canvas":   map[string]any{"Name": "c", "shape": map[string]any{"@type": "example.com/Square", "Side": 5}},
+		"Fallback": map[string]any{"Side": 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var geo decGeo
+	opts := &ProtoOptions{Registry: reg, TypeURLs: map[string]string{"type.googleapis.com/legacy.Box": "Square"}}
+	if err := UnmarshalProtoWithSpec(data, &geo, spec, opts); err != nil {
+		t.Fatal(err)
+	}
+	if geo.Title != "demo" {
+		t.Errorf("Title = %q", geo.Title)
+	}
+	if c, ok := geo.Primary.(*decCircle); !ok || c.Radius != 2 {
+		t.Errorf("Primary = %#v", geo.Primary)
+	}
+	if len(geo.Shapes) != 2 {
+		t.Fatalf("Shapes = %#v", geo.Shapes)
+	}
+	if s, ok := geo.Shapes[0].(*decSquare); !ok || s.Side != 3 {
+		t.Errorf("Shapes[0] = %#v", geo.Shapes[0])
+	}
+	if c, ok := geo.Shapes[1].(*decCircle); !ok || c.Radius != 1 {
+		t.Errorf("Shapes[1] = %#v", geo.Shapes[1])
+	}
+	if s, ok := geo.Canvas.Shape.(*decSquare); !ok || s.Side != 5 {
+		t.Errorf("Canvas.Shape = %#v", geo.Canvas.Shape)
+	}
+	if s, ok := geo.Fallback.(*decSquare); !ok || s.Side != 4 {
+		t.Errorf("Fallback = %#v", geo.Fallback)
+	}
+}
+
+func TestUnmarshalProtoWithSpec_Any(t *testing.T) {
+	file, _, _ := newShapesFile(t, nil)
+	md := file.Messages().ByName("Point")
+	point := dynamicpb.NewMessage(md)
+	point.Set(md.Fields().ByName("x"), protoreflect.ValueOfInt32(3))
+	payload, err := anypb.New(point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := new(protoregistry.Types)
+	if err := types.RegisterMessage(dynamicpb.NewMessageType(md)); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry()
+	if err := RegisterTo[protoPoint](reg, "Point"); err != nil {
+		t.Fatal(err)
+	}
+	opts := &ProtoOptions{
+		Registry: reg,
+		TypeURLs: map[string]string{"type.googleapis.com/shapes.v1.Point": "Point"},
+		JSON:     protojson.MarshalOptions{Resolver: types},
+	}
+
+	var out any
+	if err := UnmarshalProtoWithSpec(payload, &out, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := out.(*protoPoint); !ok || p.X != 3 {
+		t.Errorf("got %#v", out)
+	}
+
+	// Without a mapping, the class is the full message name.
+	opts.TypeURLs = nil
+	err = UnmarshalProtoWithSpec(payload, &out, nil, opts)
+	want := `UnmarshalProtoWithSpec: <root>(shapes.v1.Point): class not registered: "shapes.v1.Point"`
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestUnmarshalProtoWithSpec_Int64(t *testing.T) {
+	type part struct {
+		NamePart string
+	}
+	type option struct {
+		Name             []part
+		PositiveIntValue uint64
+		NegativeIntValue int64
+	}
+	type taggedOption struct {
+		PositiveIntValue uint64 `json:"positive_int_value"`
+		NegativeIntValue int64  `json:"negative_int_value"`
+	}
+	reg := NewRegistry()
+	if err := RegisterTo[option](reg, "google.protobuf.UninterpretedOption"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTo[taggedOption](reg, "Tagged"); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := anypb.New(&descriptorpb.UninterpretedOption{
+		Name:             []*descriptorpb.UninterpretedOption_NamePart{{NamePart: proto.String("a"), IsExtension: proto.Bool(false)}},
+		PositiveIntValue: proto.Uint64(1 << 62),
+		NegativeIntValue: proto.Int64(-5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out any
+	if err := UnmarshalProtoWithSpec(payload, &out, nil, &ProtoOptions{Registry: reg}); err != nil {
+		t.Fatal(err)
+	}
+	if o, ok := out.(*option); !ok || o.PositiveIntValue != 1<<62 || o.NegativeIntValue != -5 || len(o.Name) != 1 || o.Name[0].NamePart != "a" {
+		t.Errorf("got %#v", out)
+	}
+
+	opts := &ProtoOptions{
+		Registry: reg,
+		TypeURLs: map[string]string{"type.googleapis.com/google.protobuf.UninterpretedOption": "Tagged"},
+		JSON:     protojson.MarshalOptions{UseProtoNames: true},
+	}
+	if err := UnmarshalProtoWithSpec(payload, &out, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if o, ok := out.(*taggedOption); !ok || o.PositiveIntValue != 1<<62 || o.NegativeIntValue != -5 {
+		t.Errorf("got %#v", out)
+	}
+
+	var n int64
+	if err := UnmarshalProtoWithSpec(wrapperspb.Int64(7), &n, nil, nil); err != nil || n != 7 {
+		t.Errorf("got %d, %v", n, err)
+	}
+}
+
+func TestUnmarshalProtoWithSpec_Errors(t *testing.T) {
+	reg := newDecRegistry(t)
+	choices := &Struct{ClassName: "Geo", Fields: map[string]*Value{
+		"Primary": {Kind: &Value_SingleStruct{SingleStruct: &Struct{Choices: map[string]*Struct{"c": {ClassName: "Circle"}}}}},
+	}}
+	tests := []struct {
+		name string
+		data map[string]any
+		spec *Struct
+		want string
+	}{
+		{"no choice of class", map[string]any{"Primary": map[string]any{"@type": "x/Square"}}, choices,
+			`Geo.Primary: no choice of class "Square" for type URL "x/Square"`},
+		{"no @type for choices", map[string]any{"Primary": map[string]any{}}, choices,
+			"Geo.Primary: spec has choices but no discriminator"},
+		{"@type not a string", map[string]any{"Primary": map[string]any{"@type": 1}}, choices,
+			"Geo.Primary: @type must be a string"},
+		{"empty class", map[string]any{"Primary": map[string]any{"@type": "x/"}}, &Struct{ClassName: "Geo", Fields: map[string]*Value{
+			"Primary": {Kind: &Value_SingleStruct{SingleStruct: &Struct{}}},
+		}}, `Geo.Primary: no class for type URL "x/"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := structpb.NewStruct(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var geo decGeo
+			err = UnmarshalProtoWithSpec(data, &geo, tt.spec, &ProtoOptions{Registry: reg})
+			if err == nil || !strings.HasPrefix(err.Error(), "UnmarshalProtoWithSpec: "+tt.want) {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	var geo decGeo
+	if err := UnmarshalProtoWithSpec(nil, &geo, nil, nil); err == nil {
+		t.Error("expected an error for a nil message")
+	}
+	if err := UnmarshalProtoWithSpec(&structpb.Struct{}, geo, nil, nil); err == nil {
+		t.Error("expected an error for a non-pointer target")
+	}
+}
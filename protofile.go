@@ -0,0 +1,458 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/anypb" // registers google/protobuf/any.proto
+)
+
+// ToFileDescriptorProto generates a proto3 file describing the data shaped by
+// specs, so that it can be read with dynamicpb and protojson. The file is named
+// after pkg, e.g. "shapes/v1.proto" for "shapes.v1".
+//
+//	╔═════════════════════════════════╤═════════════════════════════════════════════╗
+//	║ Spec                            │ Protobuf                                    ║
+//	╠═════════════════════════════════╪═════════════════════════════════════════════╣
+//	║ Struct with a ClassName         │ message ClassName, with a field per Field   ║
+//	║ Struct without a ClassName      │ google.protobuf.Any                         ║
+//	║ Struct with Choices             │ oneof named after the field, a member per   ║
+//	║                                 │ choice named after its key                  ║
+//	║ ListStruct                      │ repeated                                    ║
+//	║ MapStruct                       │ map<string, ...>                            ║
+//	║ Map2Struct                      │ map<string, FieldRow>, with a nested        ║
+//	║                                 │ message FieldRow {map<string, ...> values}  ║
+//	╚═════════════════════════════════╧═════════════════════════════════════════════╝
+//
+// Fields are numbered in sorted order. A collection whose entries have different
+// classes, such as a positional list, holds a nested message with a oneof of
+// those classes, e.g. FieldItem {oneof value {...}}; so does one of choices or of
+// nested collections. Class names starting with pkg are written without it, and
+// other characters not allowed in protobuf names become '_'.
+//
+// Every spec and every class it refers to becomes a message. Specs of the same
+// class must describe the same data; a spec needs a class name. ServiceName and
+// Discriminator do not describe data and are left out.
+func ToFileDescriptorProto(pkg string, specs ...*Struct) (*descriptorpb.FileDescriptorProto, error) {
+	name := "schema.proto"
+	if pkg != "" {
+		name = strings.ReplaceAll(pkg, ".", "/") + ".proto"
+	}
+	b := &protoBuilder{
+		pkg:      pkg,
+		messages: make(map[*Struct]string),
+		byClass:  make(map[string]*Struct),
+		used:     make(map[string]bool),
+		file: &descriptorpb.FileDescriptorProto{
+			Name:   proto.String(name),
+			Syntax: proto.String("proto3"),
+		},
+	}
+	if pkg != "" {
+		b.file.Package = proto.String(pkg)
+	}
+	for _, s := range specs {
+		if s == nil || s.ClassName == "" {
+			return nil, fmt.Errorf("every spec needs a class name to name its message")
+		}
+		if _, err := b.typeName(s); err != nil {
+			return nil, fmt.Errorf("message %q: %w", s.ClassName, err)
+		}
+	}
+	if b.usesAny {
+		b.file.Dependency = []string{"google/protobuf/any.proto"}
+	}
+	if _, err := protodesc.NewFile(b.file, protoregistry.GlobalFiles); err != nil {
+		return nil, fmt.Errorf("invalid descriptor: %w", err)
+	}
+	return b.file, nil
+}
+
+// ToProtoFile generates the .proto source of ToFileDescriptorProto.
+func ToProtoFile(pkg string, specs ...*Struct) ([]byte, error) {
+	file, err := ToFileDescriptorProto(pkg, specs...)
+	if err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	sb.WriteString("syntax = \"proto3\";\n")
+	if pkg != "" {
+		fmt.Fprintf(&sb, "\npackage %s;\n", pkg)
+	}
+	if len(file.Dependency) > 0 {
+		sb.WriteString("\n")
+		for _, dep := range file.Dependency {
+			fmt.Fprintf(&sb, "import %q;\n", dep)
+		}
+	}
+	for _, md := range file.MessageType {
+		sb.WriteString("\n")
+		writeProtoMessage(&sb, md, pkg, "")
+	}
+	return []byte(sb.String()), nil
+}
+
+const anyTypeName = ".google.protobuf.Any"
+
+// protoBuilder builds the messages of a FileDescriptorProto from specs.
+type protoBuilder struct {
+	pkg string
+	// messages holds the full type name of every Struct made a message, and
+	// byClass the first Struct of each class.
+	messages map[*Struct]string
+	byClass  map[string]*Struct
+	// used holds the names of the top-level messages.
+	used    map[string]bool
+	usesAny bool
+	file    *descriptorpb.FileDescriptorProto
+}
+
+// fullName returns the full type name of name in scope, e.g. ".shapes.v1.Circle".
+func (b *protoBuilder) fullName(scope, name string) string {
+	if scope == "" && b.pkg != "" {
+		scope = "." + b.pkg
+	}
+	return scope + "." + name
+}
+
+// typeName returns the full type name of the message of class s, building the
+// message on first use; a Struct without a class is an Any.
+func (b *protoBuilder) typeName(s *Struct) (string, error) {
+	if s.ClassName == "" {
+		b.usesAny = true
+		return anyTypeName, nil
+	}
+	if name, ok := b.messages[s]; ok {
+		return name, nil
+	}
+	if first, ok := b.byClass[s.ClassName]; ok {
+		if !sameSpec(first, s) {
+			return "", fmt.Errorf("specs of class %q differ", s.ClassName)
+		}
+		b.messages[s] = b.messages[first]
+		return b.messages[s], nil
+	}
+
+	base := protoName(strings.TrimPrefix(s.ClassName, b.pkg+"."))
+	name := base
+	for i := 2; b.used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	b.used[name] = true
+	full := b.fullName("", name)
+	b.messages[s] = full
+	b.byClass[s.ClassName] = s
+
+	// Add the message before its fields, so that messages are in the order
+	// they are first used and a cycle back to s finds it.
+	md := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	b.file.MessageType = append(b.file.MessageType, md)
+	if err := b.fields(md, full, s.Fields); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// fields adds a field to md for each of fields, in sorted order.
+func (b *protoBuilder) fields(md *descriptorpb.DescriptorProto, scope string, fields map[string]*Value) error {
+	used := make(map[string]bool)
+	for _, key := range sortedKeys(fields) {
+		if err := b.field(md, scope, used, key, fields[key]); err != nil {
+			return fmt.Errorf("field %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// field adds the field key holding v to md.
+func (b *protoBuilder) field(md *descriptorpb.DescriptorProto, scope string, used map[string]bool, key string, v *Value) error {
+	name := uniqueProtoName(used, key)
+	nested := camelName(name)
+	add := func(name, key, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(int32(len(md.Field) + 1)),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			TypeName: proto.String(typeName),
+		}
+		if repeated {
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		if name != key {
+			fd.JsonName = proto.String(key)
+		}
+		md.Field = append(md.Field, fd)
+		return fd
+	}
+
+	switch k := v.GetKind().(type) {
+	case *Value_SingleStruct:
+		s := k.SingleStruct
+		if len(s.Choices) > 0 {
+			index := int32(len(md.OneofDecl))
+			md.OneofDecl = append(md.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(name)})
+			for i, choiceKey := range sortedKeys(s.Choices) {
+				typeName, err := b.elemType(md, scope, fmt.Sprintf("%s%d", nested, i+1), []*Struct{s.Choices[choiceKey]})
+				if err != nil {
+					return fmt.Errorf("choice %q: %w", choiceKey, err)
+				}
+				member := uniqueProtoName(used, choiceKey)
+				add(member, choiceKey, typeName, false).OneofIndex = proto.Int32(index)
+			}
+			return nil
+		}
+		typeName, err := b.elemType(md, scope, nested+"Value", []*Struct{s})
+		if err != nil {
+			return err
+		}
+		add(name, key, typeName, false)
+
+	case *Value_ListStruct:
+		typeName, err := b.elemType(md, scope, nested+"Item", k.ListStruct.GetListFields())
+		if err != nil {
+			return err
+		}
+		add(name, key, typeName, true)
+
+	case *Value_MapStruct:
+		fields := k.MapStruct.GetMapFields()
+		values := make([]*Struct, 0, len(fields))
+		for _, key := range sortedKeys(fields) {
+			values = append(values, fields[key])
+		}
+		typeName, err := b.elemType(md, scope, nested+"Value", values)
+		if err != nil {
+			return err
+		}
+		add(name, key, b.mapEntry(md, scope, name, typeName), true)
+
+	case *Value_Map2Struct:
+		var values []*Struct
+		fields := k.Map2Struct.GetMap2Fields()
+		for _, key1 := range sortedKeys(fields) {
+			inner := fields[key1].GetMapFields()
+			for _, key2 := range sortedKeys(inner) {
+				values = append(values, inner[key2])
+			}
+		}
+		row := &descriptorpb.DescriptorProto{Name: proto.String(nested + "Row")}
+		md.NestedType = append(md.NestedType, row)
+		rowName := scope + "." + row.GetName()
+		typeName, err := b.elemType(row, rowName, "ValuesValue", values)
+		if err != nil {
+			return err
+		}
+		row.Field = append(row.Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String("values"),
+			Number:   proto.Int32(1),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			TypeName: proto.String(b.mapEntry(row, rowName, "values", typeName)),
+		})
+		add(name, key, b.mapEntry(md, scope, name, rowName), true)
+
+	default:
+		return fmt.Errorf("unknown Value kind: %T", v.GetKind())
+	}
+	return nil
+}
+
+// elemType returns the type of a field holding any of structs. Entries of
+// one class have its message; a nested message named nested holds choices,
+// nested collections, and entries of different classes as a oneof.
+func (b *protoBuilder) elemType(md *descriptorpb.DescriptorProto, scope, nested string, structs []*Struct) (string, error) {
+	if len(structs) == 0 {
+		b.usesAny = true
+		return anyTypeName, nil
+	}
+	if len(structs) == 1 {
+		s := structs[0]
+		if s == nil {
+			return "", fmt.Errorf("nil Struct")
+		}
+		inner, wrapped := unwrapValueFromStruct(s)
+		if !wrapped && len(s.Choices) == 0 {
+			return b.typeName(s)
+		}
+		msg := &descriptorpb.DescriptorProto{Name: proto.String(nested)}
+		md.NestedType = append(md.NestedType, msg)
+		full := scope + "." + nested
+		if !wrapped {
+			inner = &Value{Kind: &Value_SingleStruct{SingleStruct: s}}
+		}
+		if err := b.field(msg, full, make(map[string]bool), "value", inner); err != nil {
+			return "", err
+		}
+		return full, nil
+	}
+
+	var types []string
+	seen := make(map[string]bool)
+	for i, s := range structs {
+		typeName, err := b.elemType(md, scope, fmt.Sprintf("%s%d", nested, i+1), []*Struct{s})
+		if err != nil {
+			return "", fmt.Errorf("index %d: %w", i, err)
+		}
+		if !seen[typeName] {
+			seen[typeName] = true
+			types = append(types, typeName)
+		}
+	}
+	if len(types) == 1 {
+		return types[0], nil
+	}
+	msg := &descriptorpb.DescriptorProto{
+		Name:      proto.String(nested),
+		OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("value")}},
+	}
+	md.NestedType = append(md.NestedType, msg)
+	used := map[string]bool{"value": true}
+	for i, typeName := range types {
+		member := uniqueProtoName(used, strings.ToLower(typeName[strings.LastIndex(typeName, ".")+1:]))
+		msg.Field = append(msg.Field, &descriptorpb.FieldDescriptorProto{
+			Name:       proto.String(member),
+			Number:     proto.Int32(int32(i + 1)),
+			Type:       descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			Label:      descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			TypeName:   proto.String(typeName),
+			OneofIndex: proto.Int32(0),
+		})
+	}
+	return scope + "." + nested, nil
+}
+
+// mapEntry adds to md the entry message of the map field name with values of
+// type valueType, and returns its full name.
+func (b *protoBuilder) mapEntry(md *descriptorpb.DescriptorProto, scope, name, valueType string) string {
+	entry := &descriptorpb.DescriptorProto{
+		Name:    proto.String(camelName(name) + "Entry"),
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		Field: []*descriptorpb.FieldDescriptorProto{{
+			Name:   proto.String("key"),
+			Number: proto.Int32(1),
+			Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}, {
+			Name:     proto.String("value"),
+			Number:   proto.Int32(2),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			TypeName: proto.String(valueType),
+		}},
+	}
+	md.NestedType = append(md.NestedType, entry)
+	return scope + "." + entry.GetName()
+}
+
+// protoName turns name into a protobuf identifier: letters, digits and '_',
+// not starting with a digit.
+func protoName(name string) string {
+	out := strings.Map(func(r rune) rune {
+		if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+	if out == "" || unicode.IsDigit(rune(out[0])) {
+		out = "_" + out
+	}
+	return out
+}
+
+// uniqueProtoName returns protoName(key), with a suffix if it is in used.
+func uniqueProtoName(used map[string]bool, key string) string {
+	base := protoName(key)
+	name := base
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+// camelName converts a field name into the CamelCase of its nested messages,
+// as protoc names map entries: "by_name" gives "ByName".
+func camelName(name string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper:
+			sb.WriteRune(unicode.ToUpper(r))
+			upper = false
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// writeProtoMessage writes md as .proto source, indented by indent.
+func writeProtoMessage(sb *strings.Builder, md *descriptorpb.DescriptorProto, pkg, indent string) {
+	if len(md.Field) == 0 && len(md.NestedType) == 0 {
+		fmt.Fprintf(sb, "%smessage %s {}\n", indent, md.GetName())
+		return
+	}
+	fmt.Fprintf(sb, "%smessage %s {\n", indent, md.GetName())
+	entries := make(map[string]*descriptorpb.DescriptorProto)
+	for _, nested := range md.NestedType {
+		if nested.GetOptions().GetMapEntry() {
+			entries[nested.GetName()] = nested
+			continue
+		}
+		writeProtoMessage(sb, nested, pkg, indent+"  ")
+	}
+
+	typeName := func(full string) string {
+		full = strings.TrimPrefix(full, ".")
+		if pkg != "" {
+			full = strings.TrimPrefix(full, pkg+".")
+		}
+		return full
+	}
+	field := func(fd *descriptorpb.FieldDescriptorProto, indent string) {
+		t := typeName(fd.GetTypeName())
+		if entry, ok := entries[t[strings.LastIndex(t, ".")+1:]]; ok && fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			t = fmt.Sprintf("map<string, %s>", typeName(entry.Field[1].GetTypeName()))
+		} else if fd.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			t = "repeated " + t
+		}
+		fmt.Fprintf(sb, "%s%s %s = %d", indent, t, fd.GetName(), fd.GetNumber())
+		if fd.JsonName != nil && fd.GetJsonName() != fd.GetName() {
+			fmt.Fprintf(sb, " [json_name = %q]", fd.GetJsonName())
+		}
+		sb.WriteString(";\n")
+	}
+
+	fields := append([]*descriptorpb.FieldDescriptorProto(nil), md.Field...)
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].GetNumber() < fields[j].GetNumber() })
+	written := make(map[int32]bool)
+	for _, fd := range fields {
+		if fd.OneofIndex == nil {
+			field(fd, indent+"  ")
+			continue
+		}
+		index := fd.GetOneofIndex()
+		if written[index] {
+			continue
+		}
+		written[index] = true
+		fmt.Fprintf(sb, "%s  oneof %s {\n", indent, md.OneofDecl[index].GetName())
+		for _, member := range fields {
+			if member.OneofIndex != nil && member.GetOneofIndex() == index {
+				field(member, indent+"    ")
+			}
+		}
+		fmt.Fprintf(sb, "%s  }\n", indent)
+	}
+	fmt.Fprintf(sb, "%s}\n", indent)
+}
@@ -0,0 +1,164 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestToProtoFile(t *testing.T) {
+	spec, err := ParseSpec(`Drawing&d{
+		Main: <kind>(circle: Circle{Center: Point}, square: Square@squareService),
+		Layers: [HTTPServer, GRPCServer],
+		Keyed: {api: APIHandler, *: WebHandler},
+		Grid: {{r1, k1}: Cell, {*, *}: Cell},
+		Unknown: "",
+		"odd name": Point,
+		Parent: *d,
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToProtoFile("shapes.v1", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `syntax = "proto3";
+
+package shapes.v1;
+
+import "google/protobuf/any.proto";
+
+message Drawing {
+  message GridRow {
+    map<string, Cell> values = 1;
+  }
+  message KeyedValue {
+    oneof value {
+      WebHandler webhandler = 1;
+      APIHandler apihandler = 2;
+    }
+  }
+  message LayersItem {
+    oneof value {
+      HTTPServer httpserver = 1;
+      GRPCServer grpcserver = 2;
+    }
+  }
+  map<string, Drawing.GridRow> Grid = 1;
+  map<string, Drawing.KeyedValue> Keyed = 2;
+  repeated Drawing.LayersItem Layers = 3;
+  oneof Main {
+    Circle circle = 4;
+    Square square = 5;
+  }
+  Drawing Parent = 6;
+  google.protobuf.Any Unknown = 7;
+  Point odd_name = 8 [json_name = "odd name"];
+}
+
+message Cell {}
+
+message WebHandler {}
+
+message APIHandler {}
+
+message HTTPServer {}
+
+message GRPCServer {}
+
+message Circle {
+  Point Center = 1;
+}
+
+message Point {}
+
+message Square {}
+`
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestToFileDescriptorProto_DynamicMessage(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"Servers": []string{"HTTPServer"},
+		"Grid":    map[[2]string]string{{"*", "*"}: "Cell"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdp, err := ToFileDescriptorProto("app", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fdp.GetName() != "app.proto" || fdp.GetPackage() != "app" {
+		t.Errorf("file %s, package %s", fdp.GetName(), fdp.GetPackage())
+	}
+	file, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dynamicpb.NewMessage(file.Messages().ByName("Config"))
+	data := `{"Servers": [{}, {}], "Grid": {"r1": {"values": {"k1": {}}}}}`
+	if err := protojson.Unmarshal([]byte(data), msg); err != nil {
+		t.Fatal(err)
+	}
+	servers := msg.Get(file.Messages().ByName("Config").Fields().ByName("Servers")).List()
+	if servers.Len() != 2 {
+		t.Errorf("got %d servers, want 2", servers.Len())
+	}
+}
+
+func TestToFileDescriptorProto_DescriptorRoundTrip(t *testing.T) {
+	point := &Struct{ClassName: "geo.Point"}
+	spec := &Struct{ClassName: "geo.Shape", Fields: map[string]*Value{
+		"center":  {Kind: &Value_SingleStruct{SingleStruct: point}},
+		"corners": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{point}}}},
+		"labels":  {Kind: &Value_MapStruct{MapStruct: &MapStruct{MapFields: map[string]*Struct{"*": point}}}},
+		"extra":   {Kind: &Value_SingleStruct{SingleStruct: &Struct{}}},
+		"kind": {Kind: &Value_SingleStruct{SingleStruct: &Struct{Choices: map[string]*Struct{
+			"origin": point,
+			"other":  {ClassName: "geo.Other"},
+		}}}},
+	}}
+	fdp, err := ToFileDescriptorProto("geo", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, unresolved, err := StructFromDescriptor(file.Messages().ByName("Shape"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, restored) {
+		t.Errorf("got  %v\nwant %v", restored, spec)
+	}
+	if strings.Join(unresolved, ",") != "geo.Shape.extra" {
+		t.Errorf("unresolved = %v", unresolved)
+	}
+}
+
+func TestToFileDescriptorProto_Errors(t *testing.T) {
+	if _, err := ToFileDescriptorProto("app", &Struct{}); err == nil || err.Error() != "every spec needs a class name to name its message" {
+		t.Errorf("got %v", err)
+	}
+	spec := &Struct{ClassName: "Config", Fields: map[string]*Value{
+		"A": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "X"}}},
+		"B": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "X", Fields: map[string]*Value{
+			"C": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "Y"}}},
+		}}}},
+	}}
+	want := `message "Config": field "B": specs of class "X" differ`
+	if _, err := ToFileDescriptorProto("app", spec); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
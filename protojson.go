@@ -0,0 +1,181 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// SpecFormat is the JSON form of a stored spec.
+type SpecFormat int
+
+const (
+	// FormatSchemaJSON is the JSON Schema dialect of Struct.MarshalJSON, with
+	// className, properties, items, x-map and x-map2.
+	FormatSchemaJSON SpecFormat = iota + 1
+	// FormatProtoJSON is the protobuf JSON form of struct.schema.json, with
+	// ClassName, fields, single_struct, list_fields and map_fields.
+	FormatProtoJSON
+)
+
+// String returns the name of the format.
+func (f SpecFormat) String() string {
+	switch f {
+	case FormatSchemaJSON:
+		return "schema JSON"
+	case FormatProtoJSON:
+		return "protobuf JSON"
+	default:
+		return fmt.Sprintf("SpecFormat(%d)", int(f))
+	}
+}
+
+// ToProtoJSON writes s in the protobuf JSON form described by struct.schema.json,
+// with proto field names and sorted map keys:
+//
+//	{"ClassName":"Config","fields":{"Servers":{"list_struct":{"list_fields":[{"ClassName":"HTTPServer"}]}}}}
+//
+// Struct.MarshalJSON writes the JSON Schema dialect instead. Shared Structs are
+// written in full at each use; cyclic specs cannot be written in this form.
+func ToProtoJSON(s *Struct) ([]byte, error) {
+	if s == nil {
+		return nil, fmt.Errorf("ToProtoJSON: nil Struct")
+	}
+	if path := cyclePath(s, rootPath(s), make(map[*Struct]bool)); path != "" {
+		return nil, fmt.Errorf("ToProtoJSON: spec is cyclic at %s", path)
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("ToProtoJSON: %w", err)
+	}
+	// protojson varies its whitespace between runs; compact it for stable output.
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, fmt.Errorf("ToProtoJSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// FromProtoJSON reads a Struct written in the protobuf JSON form, such as the
+// output of ToProtoJSON. Field names may be proto names or their JSON names.
+func FromProtoJSON(data []byte) (*Struct, error) {
+	s := new(Struct)
+	if err := protojson.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("FromProtoJSON: %w", err)
+	}
+	return s, nil
+}
+
+// DetectSpecFormat reports the form of the spec document data. A document with
+// ClassName, ServiceName, fields or choices, or a string discriminator, is in
+// the protobuf JSON form; any other object, including {}, is in the JSON Schema
+// dialect, where the same names are written className, serviceName and properties.
+func DetectSpecFormat(data []byte) (SpecFormat, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return 0, fmt.Errorf("spec must be a JSON object: %w", err)
+	}
+	for key, raw := range members {
+		switch key {
+		case "ClassName", "ServiceName", "fields", "choices":
+			return FormatProtoJSON, nil
+		case "discriminator":
+			if bytes.HasPrefix(raw, []byte(`"`)) {
+				return FormatProtoJSON, nil
+			}
+		}
+	}
+	return FormatSchemaJSON, nil
+}
+
+// LoadStruct reads a spec stored in either JSON form, and returns the form it
+// was in. Writing the result with ToProtoJSON or json.Marshal migrates it:
+//
+//	spec, format, err := LoadStruct(data)
+//	if err == nil && format == FormatProtoJSON {
+//	    data, err = json.Marshal(spec)
+//	}
+func LoadStruct(data []byte) (*Struct, SpecFormat, error) {
+	format, err := DetectSpecFormat(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("LoadStruct: %w", err)
+	}
+	var s *Struct
+	if format == FormatProtoJSON {
+		s, err = FromProtoJSON(data)
+	} else {
+		s = new(Struct)
+		err = s.UnmarshalJSON(data)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("LoadStruct: %s: %w", format, err)
+	}
+	return s, format, nil
+}
+
+// LoadStructFromFile reads a spec in either JSON form from path and calls
+// LoadStruct.
+func LoadStructFromFile(path string) (*Struct, SpecFormat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return LoadStruct(data)
+}
+
+// cyclePath returns the path of a Struct reachable from s that refers back to
+// one of its ancestors, or "" if there is none. onPath holds the ancestors of s.
+func cyclePath(s *Struct, path string, onPath map[*Struct]bool) string {
+	if s == nil {
+		return ""
+	}
+	if onPath[s] {
+		return path
+	}
+	onPath[s] = true
+	defer delete(onPath, s)
+	for _, key := range sortedKeys(s.Choices) {
+		if p := cyclePath(s.Choices[key], fmt.Sprintf("%s(%s)", path, key), onPath); p != "" {
+			return p
+		}
+	}
+	for _, name := range sortedKeys(s.Fields) {
+		fieldPath := path + "." + name
+		var p string
+		switch k := s.Fields[name].GetKind().(type) {
+		case *Value_SingleStruct:
+			p = cyclePath(k.SingleStruct, fieldPath, onPath)
+		case *Value_ListStruct:
+			for i, x := range k.ListStruct.GetListFields() {
+				if p = cyclePath(x, fmt.Sprintf("%s[%d]", fieldPath, i), onPath); p != "" {
+					break
+				}
+			}
+		case *Value_MapStruct:
+			fields := k.MapStruct.GetMapFields()
+			for _, key := range sortedKeys(fields) {
+				if p = cyclePath(fields[key], fmt.Sprintf("%s[%q]", fieldPath, key), onPath); p != "" {
+					break
+				}
+			}
+		case *Value_Map2Struct:
+			fields := k.Map2Struct.GetMap2Fields()
+		rows:
+			for _, key1 := range sortedKeys(fields) {
+				inner := fields[key1].GetMapFields()
+				for _, key2 := range sortedKeys(inner) {
+					if p = cyclePath(inner[key2], fmt.Sprintf("%s[%q][%q]", fieldPath, key1, key2), onPath); p != "" {
+						break rows
+					}
+				}
+			}
+		}
+		if p != "" {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestToProtoJSON(t *testing.T) {
+	spec, err := NewServiceStruct("Config", map[string]any{
+		"Servers":  [][]string{{"HTTPServer"}},
+		"Database": []string{"PostgresDB", "dbService"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToProtoJSON(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"ClassName":"Config","fields":{` +
+		`"Database":{"single_struct":{"ClassName":"PostgresDB","ServiceName":"dbService"}},` +
+		`"Servers":{"list_struct":{"list_fields":[{"ClassName":"HTTPServer"}]}}}}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+
+	restored, err := FromProtoJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, restored) {
+		t.Errorf("got  %v\nwant %v", restored, spec)
+	}
+	// JSON names are accepted too.
+	camel := strings.NewReplacer("single_struct", "singleStruct", "list_struct", "listStruct", "list_fields", "listFields").Replace(want)
+	restored, err = FromProtoJSON([]byte(camel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, restored) {
+		t.Errorf("got  %v\nwant %v", restored, spec)
+	}
+}
+
+func TestToProtoJSON_Cyclic(t *testing.T) {
+	_, err := ToProtoJSON(newCyclicTree())
+	want := "ToProtoJSON: spec is cyclic at Tree.Root.Children[0]"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+
+	// Shared Structs are not cycles.
+	point := &Struct{ClassName: "Point"}
+	shared := &Struct{ClassName: "Line", Fields: map[string]*Value{
+		"From": {Kind: &Value_SingleStruct{SingleStruct: point}},
+		"To":   {Kind: &Value_SingleStruct{SingleStruct: point}},
+	}}
+	if _, err := ToProtoJSON(shared); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDetectSpecFormat(t *testing.T) {
+	tests := []struct {
+		data string
+		want SpecFormat
+	}{
+		{`{"ClassName": "Config"}`, FormatProtoJSON},
+		{`{"fields": {}}`, FormatProtoJSON},
+		{`{"discriminator": "kind", "choices": {}}`, FormatProtoJSON},
+		{`{"className": "Config", "properties": {}}`, FormatSchemaJSON},
+		{`{"discriminator": {"propertyName": "kind"}, "oneOf": []}`, FormatSchemaJSON},
+		{`{}`, FormatSchemaJSON},
+	}
+	for _, tt := range tests {
+		got, err := DetectSpecFormat([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.data, got, tt.want)
+		}
+	}
+	if _, err := DetectSpecFormat([]byte(`[]`)); err == nil {
+		t.Error("expected an error for a JSON array")
+	}
+}
+
+func TestLoadStruct(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"Servers":  []string{"HTTPServer", "GRPCServer"},
+		"Handlers": map[string]string{"*": "WebHandler"},
+		"Grid":     map[[2]string]string{{"r1", "k1"}: "Cell"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.Fields["Shape"] = &Value{Kind: &Value_SingleStruct{SingleStruct: &Struct{
+		Discriminator: "kind",
+		Choices:       map[string]*Struct{"circle": {ClassName: "Circle"}, "square": {ClassName: "Square"}},
+	}}}
+	protoJSON, err := ToProtoJSON(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaJSON, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for format, data := range map[SpecFormat][]byte{FormatProtoJSON: protoJSON, FormatSchemaJSON: schemaJSON} {
+		path := filepath.Join(dir, "spec.json")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		loaded, got, err := LoadStructFromFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got != format {
+			t.Errorf("detected %s, want %s", got, format)
+		}
+		if !proto.Equal(spec, loaded) {
+			t.Errorf("%s: got  %v\nwant %v", format, loaded, spec)
+		}
+	}
+
+	_, _, err = LoadStruct([]byte(`{"ClassName": "Config", "properties": {}}`))
+	if err == nil || !strings.HasPrefix(err.Error(), "LoadStruct: protobuf JSON: FromProtoJSON: ") {
+		t.Errorf("got %v", err)
+	}
+}
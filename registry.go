@@ -0,0 +1,229 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrClassNotRegistered is returned (wrapped) when a ClassName has no entry in a Registry.
+var ErrClassNotRegistered = errors.New("class not registered")
+
+// Registry maps Struct.ClassName to concrete Go types.
+//
+// It replaces the per-package map[string]reflect.Type (or factory maps) that
+// consumers of Struct used to maintain, so that every package aliasing
+// schema.Struct can share one lookup table. A Registry is safe for concurrent use.
+//
+// Each class is backed either by a Go type, registered with Register, RegisterTo
+// or RegisterType, or by a factory function, registered with RegisterFactory.
+type Registry struct {
+	mu        sync.RWMutex
+	types     map[string]reflect.Type
+	factories map[string]func() any
+	// factoryTypes holds the type each factory was found to create at registration.
+	factoryTypes map[string]reflect.Type
+}
+
+// DefaultRegistry is the Registry used when a nil Registry is passed to
+// functions of this package.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		types:        make(map[string]reflect.Type),
+		factories:    make(map[string]func() any),
+		factoryTypes: make(map[string]reflect.Type),
+	}
+}
+
+// Register registers type T under name in DefaultRegistry.
+//
+// Example:
+//
+//	schema.Register[Circle]("Circle")
+//	schema.Register[*Square]("Square") // pointer types are stored by their element type
+func Register[T any](name string) error {
+	return RegisterTo[T](DefaultRegistry, name)
+}
+
+// RegisterTo registers type T under name in registry r.
+func RegisterTo[T any](r *Registry, name string) error {
+	return r.RegisterType(name, reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// RegisterType registers the Go type t under name.
+// A pointer type is stored by its element type, so New always returns a pointer.
+// Registering the same name twice with a different type is an error.
+func (r *Registry) RegisterType(name string, t reflect.Type) error {
+	if name == "" {
+		return fmt.Errorf("class name cannot be empty")
+	}
+	if t == nil {
+		return fmt.Errorf("nil type for class %q", name)
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return fmt.Errorf("class %q: cannot register interface type %v", name, t)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.types[name]; ok && old != t {
+		return fmt.Errorf("class %q already registered as %v", name, old)
+	}
+	if _, ok := r.factories[name]; ok {
+		return fmt.Errorf("class %q already registered with a factory", name)
+	}
+	r.types[name] = t
+	return nil
+}
+
+// RegisterFactory registers a factory function under name.
+// The factory should return a pointer to a new instance, so that decoders can fill it.
+// It is called once here to learn the type it creates, and must not return nil.
+// Registering a name twice is an error.
+func (r *Registry) RegisterFactory(name string, factory func() any) error {
+	if name == "" {
+		return fmt.Errorf("class name cannot be empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("nil factory for class %q", name)
+	}
+	obj := factory()
+	if obj == nil {
+		return fmt.Errorf("factory for class %q returned nil", name)
+	}
+	t := reflect.TypeOf(obj)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.types[name]; ok {
+		return fmt.Errorf("class %q already registered as %v", name, old)
+	}
+	if _, ok := r.factories[name]; ok {
+		return fmt.Errorf("class %q already registered with a factory", name)
+	}
+	r.factories[name] = factory
+	r.factoryTypes[name] = t
+	return nil
+}
+
+// Lookup returns the Go type registered under name.
+// For a factory registration, the type is that of the value the factory returned
+// when it was registered, dereferenced if it is a pointer.
+func (r *Registry) Lookup(name string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if t, ok := r.types[name]; ok {
+		return t, true
+	}
+	t, ok := r.factoryTypes[name]
+	return t, ok
+}
+
+// Has reports whether name is registered.
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.types[name]
+	if !ok {
+		_, ok = r.factories[name]
+	}
+	return ok
+}
+
+// Names returns all registered class names in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.types)+len(r.factories))
+	for name := range r.types {
+		names = append(names, name)
+	}
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Implementing returns, in sorted order, the registered class names whose type
+// T or *T implements the interface type iface.
+func (r *Registry) Implementing(iface reflect.Type) []string {
+	if iface == nil || iface.Kind() != reflect.Interface {
+		return nil
+	}
+	var names []string
+	for _, name := range r.Names() {
+		t, ok := r.Lookup(name)
+		if !ok {
+			continue
+		}
+		if implements(t, iface) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// implements reports whether t or *t implements the interface type iface.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+// New creates a new instance of the class registered under className.
+// For a registered type T it returns a *T pointing to the zero value;
+// for a factory it returns whatever the factory returns.
+func (r *Registry) New(className string) (any, error) {
+	r.mu.RLock()
+	t, ok := r.types[className]
+	factory := r.factories[className]
+	r.mu.RUnlock()
+	if ok {
+		return reflect.New(t).Interface(), nil
+	}
+	if factory != nil {
+		obj := factory()
+		if obj == nil {
+			return nil, fmt.Errorf("factory for class %q returned nil", className)
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrClassNotRegistered, className)
+}
+
+// NewInstance creates a new instance of the class named by x.ClassName using reg.
+// If reg is nil, DefaultRegistry is used.
+func (x *Struct) NewInstance(reg *Registry) (any, error) {
+	if x == nil {
+		return nil, fmt.Errorf("cannot create instance from nil Struct")
+	}
+	return registryOrDefault(reg).New(x.ClassName)
+}
+
+// NewInstance creates a new instance of the class of a SingleStruct value using reg.
+// If reg is nil, DefaultRegistry is used. Collection values have no single class
+// and return an error.
+func (x *Value) NewInstance(reg *Registry) (any, error) {
+	s := x.GetSingleStruct()
+	if s == nil {
+		return nil, fmt.Errorf("value is not a SingleStruct: %T", x.GetKind())
+	}
+	return s.NewInstance(reg)
+}
+
+func registryOrDefault(reg *Registry) *Registry {
+	if reg == nil {
+		return DefaultRegistry
+	}
+	return reg
+}
@@ -0,0 +1,160 @@
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type regCircle struct {
+	Radius float64
+}
+
+type regSquare struct {
+	Side float64
+}
+
+func TestRegistry_RegisterAndNew(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[regCircle](reg, "Circle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTo[*regSquare](reg, "Square"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := reg.New("Circle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := obj.(*regCircle); !ok {
+		t.Errorf("expected *regCircle, got %T", obj)
+	}
+
+	obj, err = reg.New("Square")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := obj.(*regSquare); !ok {
+		t.Errorf("expected *regSquare, got %T", obj)
+	}
+
+	typ, ok := reg.Lookup("Square")
+	if !ok || typ != reflect.TypeOf(regSquare{}) {
+		t.Errorf("Lookup(Square) = %v, %v", typ, ok)
+	}
+	if got := reg.Names(); !reflect.DeepEqual(got, []string{"Circle", "Square"}) {
+		t.Errorf("Names() = %v", got)
+	}
+}
+
+func TestRegistry_Factory(t *testing.T) {
+	reg := NewRegistry()
+	err := reg.RegisterFactory("Circle", func() any { return &regCircle{Radius: 1} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := reg.New("Circle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := obj.(*regCircle); !ok || c.Radius != 1 {
+		t.Errorf("unexpected factory result %#v", obj)
+	}
+	if typ, ok := reg.Lookup("Circle"); !ok || typ != reflect.TypeOf(regCircle{}) {
+		t.Errorf("Lookup(Circle) = %v, %v", typ, ok)
+	}
+	if err := RegisterTo[regCircle](reg, "Circle"); err == nil {
+		t.Error("expected error registering a type over a factory")
+	}
+	if err := reg.RegisterFactory("Circle", func() any { return &regCircle{} }); err == nil {
+		t.Error("expected error registering a factory twice")
+	}
+	if err := reg.RegisterFactory("Nil", func() any { return nil }); err == nil {
+		t.Error("expected error for a factory returning nil")
+	}
+
+	// Lookup reports the type found at registration without calling the factory.
+	calls := 0
+	if err := reg.RegisterFactory("Counted", func() any { calls++; return &regSquare{} }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if typ, ok := reg.Lookup("Counted"); !ok || typ != reflect.TypeOf(regSquare{}) {
+			t.Errorf("Lookup(Counted) = %v, %v", typ, ok)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("factory called %d times, want 1", calls)
+	}
+}
+
+func TestRegistry_Errors(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[regCircle](reg, ""); err == nil {
+		t.Error("expected error for empty name")
+	}
+	if err := RegisterTo[error](reg, "Err"); err == nil {
+		t.Error("expected error for interface type")
+	}
+	if err := RegisterTo[regCircle](reg, "Circle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTo[regCircle](reg, "Circle"); err != nil {
+		t.Errorf("re-registering the same type should succeed, got %v", err)
+	}
+	if err := RegisterTo[regSquare](reg, "Circle"); err == nil {
+		t.Error("expected error registering a different type under the same name")
+	}
+	_, err := reg.New("Unknown")
+	if !errors.Is(err, ErrClassNotRegistered) {
+		t.Errorf("expected ErrClassNotRegistered, got %v", err)
+	}
+}
+
+func TestRegistry_StructAndValue(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[regCircle](reg, "Circle"); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := NewStruct("Geo", map[string]any{"Shape": "Circle", "Shapes": []string{"Circle"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := spec.Fields["Shape"].NewInstance(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := obj.(*regCircle); !ok {
+		t.Errorf("expected *regCircle, got %T", obj)
+	}
+	if _, err := spec.Fields["Shapes"].NewInstance(reg); err == nil {
+		t.Error("expected error for ListStruct value")
+	}
+	if _, err := spec.NewInstance(reg); !errors.Is(err, ErrClassNotRegistered) {
+		t.Errorf("expected ErrClassNotRegistered for Geo, got %v", err)
+	}
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	reg := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = RegisterTo[regCircle](reg, "Circle")
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = reg.New("Circle")
+			_ = reg.Names()
+		}()
+	}
+	wg.Wait()
+	if !reg.Has("Circle") {
+		t.Error("Circle should be registered")
+	}
+}
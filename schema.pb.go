@@ -27,11 +27,15 @@ const (
 //   - ClassName: The Go struct type name (also serves as ObjectName for service orchestration)
 //   - ServiceName: The service to delegate read/write operations to
 //   - Fields: Nested field specifications
+//   - Discriminator: The property whose value selects one of Choices
+//   - Choices: The allowed classes, keyed by discriminator value (or by class name)
 type Struct struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ClassName     string                 `protobuf:"bytes,1,opt,name=ClassName,proto3" json:"ClassName,omitempty"`     // Go struct type name / object identifier
 	ServiceName   string                 `protobuf:"bytes,2,opt,name=ServiceName,proto3" json:"ServiceName,omitempty"` // Service name for delegation (read/write operations)
 	Fields        map[string]*Value      `protobuf:"bytes,3,rep,name=fields,proto3" json:"fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Discriminator string                 `protobuf:"bytes,4,opt,name=discriminator,proto3" json:"discriminator,omitempty"`                                                               // Property selecting a choice, e.g. "type"
+	Choices       map[string]*Struct     `protobuf:"bytes,5,rep,name=choices,proto3" json:"choices,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Allowed classes for a polymorphic field
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -87,6 +91,20 @@ func (x *Struct) GetFields() map[string]*Value {
 	return nil
 }
 
+func (x *Struct) GetDiscriminator() string {
+	if x != nil {
+		return x.Discriminator
+	}
+	return ""
+}
+
+func (x *Struct) GetChoices() map[string]*Struct {
+	if x != nil {
+		return x.Choices
+	}
+	return nil
+}
+
 // Value represents a typed field specification.
 // It can be one of four kinds: SingleStruct, ListStruct, MapStruct, or Map2Struct.
 type Value struct {
@@ -343,14 +361,19 @@ var File_proto_schema_proto protoreflect.FileDescriptor
 
 const file_proto_schema_proto_rawDesc = "" +
 	"\n" +
-	"\x12proto/schema.proto\x12\x06schema\"\xc6\x01\n" +
+	"\x12proto/schema.proto\x12\x06schema\"\xef\x02\n" +
 	"\x06Struct\x12\x1c\n" +
 	"\tClassName\x18\x01 \x01(\tR\tClassName\x12 \n" +
 	"\vServiceName\x18\x02 \x01(\tR\vServiceName\x122\n" +
-	"\x06fields\x18\x03 \x03(\v2\x1a.schema.Struct.FieldsEntryR\x06fields\x1aH\n" +
+	"\x06fields\x18\x03 \x03(\v2\x1a.schema.Struct.FieldsEntryR\x06fields\x12$\n" +
+	"\rdiscriminator\x18\x04 \x01(\tR\rdiscriminator\x125\n" +
+	"\achoices\x18\x05 \x03(\v2\x1b.schema.Struct.ChoicesEntryR\achoices\x1aH\n" +
 	"\vFieldsEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12#\n" +
-	"\x05value\x18\x02 \x01(\v2\r.schema.ValueR\x05value:\x028\x01\"\xe8\x01\n" +
+	"\x05value\x18\x02 \x01(\v2\r.schema.ValueR\x05value:\x028\x01\x1aJ\n" +
+	"\fChoicesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12$\n" +
+	"\x05value\x18\x02 \x01(\v2\x0e.schema.StructR\x05value:\x028\x01\"\xe8\x01\n" +
 	"\x05Value\x125\n" +
 	"\rsingle_struct\x18\x01 \x01(\v2\x0e.schema.StructH\x00R\fsingleStruct\x125\n" +
 	"\vlist_struct\x18\x02 \x01(\v2\x12.schema.ListStructH\x00R\n" +
@@ -390,7 +413,7 @@ func file_proto_schema_proto_rawDescGZIP() []byte {
 	return file_proto_schema_proto_rawDescData
 }
 
-var file_proto_schema_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_proto_schema_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_proto_schema_proto_goTypes = []any{
 	(*Struct)(nil),     // 0: schema.Struct
 	(*Value)(nil),      // 1: schema.Value
@@ -398,26 +421,29 @@ var file_proto_schema_proto_goTypes = []any{
 	(*MapStruct)(nil),  // 3: schema.MapStruct
 	(*Map2Struct)(nil), // 4: schema.Map2Struct
 	nil,                // 5: schema.Struct.FieldsEntry
-	nil,                // 6: schema.MapStruct.MapFieldsEntry
-	nil,                // 7: schema.Map2Struct.Map2FieldsEntry
+	nil,                // 6: schema.Struct.ChoicesEntry
+	nil,                // 7: schema.MapStruct.MapFieldsEntry
+	nil,                // 8: schema.Map2Struct.Map2FieldsEntry
 }
 var file_proto_schema_proto_depIdxs = []int32{
 	5,  // 0: schema.Struct.fields:type_name -> schema.Struct.FieldsEntry
-	0,  // 1: schema.Value.single_struct:type_name -> schema.Struct
-	2,  // 2: schema.Value.list_struct:type_name -> schema.ListStruct
-	3,  // 3: schema.Value.map_struct:type_name -> schema.MapStruct
-	4,  // 4: schema.Value.map2_struct:type_name -> schema.Map2Struct
-	0,  // 5: schema.ListStruct.list_fields:type_name -> schema.Struct
-	6,  // 6: schema.MapStruct.map_fields:type_name -> schema.MapStruct.MapFieldsEntry
-	7,  // 7: schema.Map2Struct.map2_fields:type_name -> schema.Map2Struct.Map2FieldsEntry
-	1,  // 8: schema.Struct.FieldsEntry.value:type_name -> schema.Value
-	0,  // 9: schema.MapStruct.MapFieldsEntry.value:type_name -> schema.Struct
-	3,  // 10: schema.Map2Struct.Map2FieldsEntry.value:type_name -> schema.MapStruct
-	11, // [11:11] is the sub-list for method output_type
-	11, // [11:11] is the sub-list for method input_type
-	11, // [11:11] is the sub-list for extension type_name
-	11, // [11:11] is the sub-list for extension extendee
-	0,  // [0:11] is the sub-list for field type_name
+	6,  // 1: schema.Struct.choices:type_name -> schema.Struct.ChoicesEntry
+	0,  // 2: schema.Value.single_struct:type_name -> schema.Struct
+	2,  // 3: schema.Value.list_struct:type_name -> schema.ListStruct
+	3,  // 4: schema.Value.map_struct:type_name -> schema.MapStruct
+	4,  // 5: schema.Value.map2_struct:type_name -> schema.Map2Struct
+	0,  // 6: schema.ListStruct.list_fields:type_name -> schema.Struct
+	7,  // 7: schema.MapStruct.map_fields:type_name -> schema.MapStruct.MapFieldsEntry
+	8,  // 8: schema.Map2Struct.map2_fields:type_name -> schema.Map2Struct.Map2FieldsEntry
+	1,  // 9: schema.Struct.FieldsEntry.value:type_name -> schema.Value
+	0,  // 10: schema.Struct.ChoicesEntry.value:type_name -> schema.Struct
+	0,  // 11: schema.MapStruct.MapFieldsEntry.value:type_name -> schema.Struct
+	3,  // 12: schema.Map2Struct.Map2FieldsEntry.value:type_name -> schema.MapStruct
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_proto_schema_proto_init() }
@@ -437,7 +463,7 @@ func file_proto_schema_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_schema_proto_rawDesc), len(file_proto_schema_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   8,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,524 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// StandardSchemaDraft is the dialect of the documents built by ToStandardJSONSchema.
+const StandardSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// StandardOptions configures ToStandardJSONSchema.
+type StandardOptions struct {
+	// ID is written as the $id of the document, if not empty.
+	ID string
+	// Discriminator, if not empty, is a property every class object must have,
+	// holding its class name as a const, e.g. "@class".
+	Discriminator string
+	// NameTag is a struct tag key, such as "json" or "hcl". If set, the object of
+	// a class registered in Registry has a property for every exported field of
+	// its Go type, named as by the tag (the part before the first comma, or the Go
+	// field name), and allows no other property. Spec field names may be Go field
+	// or tag names, as with ValidateOptions.NameTag. Fields tagged "-" are left out.
+	NameTag string
+	// Registry maps ClassName to Go types for NameTag; nil means DefaultRegistry.
+	Registry *Registry
+}
+
+// ToStandardJSONSchema converts spec into a Draft 2020-12 JSON Schema document
+// describing the data the spec decodes, so that any JSON Schema validator can
+// check a configuration file against it. MarshalJSON, by contrast, writes the
+// spec itself in the package's own dialect.
+//
+//	╔══════════════════════════╤═══════════════════════════════════════════════════════════════╗
+//	║ Spec                     │ Standard JSON Schema                                          ║
+//	╠══════════════════════════╪═══════════════════════════════════════════════════════════════╣
+//	║ root Struct              │ the document: "type": "object", "title" and "properties"      ║
+//	║ Struct with ClassName    │ {"$ref": "#/$defs/Class"}, with "title": "Class" in $defs     ║
+//	║ Struct without ClassName │ inline "type": "object" with "properties"                     ║
+//	║ Struct with Choices      │ "oneOf" of the choices, each with its discriminator as const  ║
+//	║ ListStruct, one entry    │ "type": "array", "items"                                      ║
+//	║ ListStruct, positional   │ "type": "array", "prefixItems", "items": false                ║
+//	║ MapStruct                │ "type": "object", "properties" by key, "*" as                 ║
+//	║                          │ "additionalProperties" (false without "*")                    ║
+//	║ Map2Struct               │ two levels of MapStruct, with the "*" fallbacks of StructFor  ║
+//	╚══════════════════════════╧═══════════════════════════════════════════════════════════════╝
+//
+// Field names are the spec names, i.e. Go field names, unless opts.NameTag is
+// set. Classes with the same
+// ClassName and the same nested spec share one entry in $defs; otherwise the
+// names are made unique with a suffix, e.g. "Server_2". Class objects allow
+// other properties, since the Go struct may have fields the spec does not
+// mention, except with opts.NameTag. ServiceName does not describe data and is
+// not written.
+func ToStandardJSONSchema(spec *Struct, opts *StandardOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &StandardOptions{}
+	}
+	doc, err := standardDocument(spec, opts, "#/$defs/")
+	if err != nil {
+		return nil, err
+	}
+	doc["$schema"] = StandardSchemaDraft
+	if opts.ID != "" {
+		doc["$id"] = opts.ID
+	}
+	if defs, ok := doc["definitions"]; ok {
+		delete(doc, "definitions")
+		doc["$defs"] = defs
+	}
+	return json.Marshal(doc)
+}
+
+// standardDocument returns the schema of spec, with the definitions it refers
+// to under "definitions". prefix is the $ref prefix of a definition.
+func standardDocument(spec *Struct, opts *StandardOptions, prefix string) (map[string]any, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("spec cannot be nil")
+	}
+	e := newStandardExporter(spec, opts, prefix)
+	e.assignNames(spec)
+
+	var doc map[string]any
+	var err error
+	if inner, ok := unwrapValueFromStruct(spec); ok {
+		doc, err = e.valueSchema(inner)
+	} else {
+		doc, err = e.structSchema(spec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(e.definitions) > 0 {
+		defs := make(map[string]any, len(e.definitions))
+		for name, def := range e.definitions {
+			defs[name] = def
+		}
+		doc["definitions"] = defs
+	}
+	return doc, nil
+}
+
+// standardExporter converts a possibly cyclic Struct graph into standard JSON Schema.
+type standardExporter struct {
+	// root is the Struct of the document itself, if any.
+	root   *Struct
+	opts   *StandardOptions
+	prefix string
+	// openAPI writes ServiceName as x-service-name and Choices with an
+	// OpenAPI discriminator.
+	openAPI bool
+	// names holds the definition name of every Struct written under definitions.
+	names       map[*Struct]string
+	byName      map[string][]*Struct
+	used        map[string]bool
+	definitions map[string]map[string]any
+}
+
+func newStandardExporter(root *Struct, opts *StandardOptions, prefix string) *standardExporter {
+	return &standardExporter{
+		root:        root,
+		opts:        opts,
+		prefix:      prefix,
+		names:       make(map[*Struct]string),
+		byName:      make(map[string][]*Struct),
+		used:        make(map[string]bool),
+		definitions: make(map[string]map[string]any),
+	}
+}
+
+// nameAs gives s the definition name base, or the name of an equal Struct of the
+// same base, or base with a numeric suffix.
+func (e *standardExporter) nameAs(s *Struct, base string) string {
+	name := ""
+	for _, y := range e.byName[base] {
+		if sameSpec(s, y) {
+			name = e.names[y]
+			break
+		}
+	}
+	if name == "" {
+		name = base
+		for i := 2; e.used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		e.used[name] = true
+	}
+	e.byName[base] = append(e.byName[base], s)
+	e.names[s] = name
+	return name
+}
+
+// assignNames names the definitions of the Structs reachable from roots: every
+// class gets one, and so does any other Struct reached more than once, which may
+// be part of a cycle. Structs already named keep their names.
+func (e *standardExporter) assignNames(roots ...*Struct) {
+	counter := &schemaExporter{root: e.root, refs: make(map[*Struct]int)}
+	for _, r := range roots {
+		// Count the roots once, so that a root reached from another one
+		// is not walked twice.
+		counter.refs[r] = 1
+	}
+	var order []*Struct
+	for _, r := range roots {
+		counter.count(r, &order)
+	}
+	for _, x := range order {
+		if x == e.root || (x.ClassName == "" && counter.refs[x] < 2) {
+			continue
+		}
+		if _, ok := unwrapValueFromStruct(x); ok {
+			// A wrapped Value is written inline as the collection it holds.
+			continue
+		}
+		if _, done := e.names[x]; done {
+			continue
+		}
+		base := x.ClassName
+		if base == "" {
+			base = "Struct"
+		}
+		e.nameAs(x, base)
+	}
+}
+
+// structRef returns a $ref to the definition of s, or the schema of s itself if
+// it has none. A Struct wrapping a nested collection is the schema of that
+// collection, e.g. an array of arrays.
+func (e *standardExporter) structRef(s *Struct) (map[string]any, error) {
+	if s == nil {
+		return map[string]any{}, nil
+	}
+	if inner, ok := unwrapValueFromStruct(s); ok {
+		return e.valueSchema(inner)
+	}
+	if s == e.root {
+		return map[string]any{"$ref": "#"}, nil
+	}
+	name, ok := e.names[s]
+	if !ok {
+		return e.structSchema(s)
+	}
+	if _, done := e.definitions[name]; !done {
+		// Reserve the name first, so that a cycle back to s ends in a $ref.
+		e.definitions[name] = nil
+		def, err := e.structSchema(s)
+		if err != nil {
+			return nil, err
+		}
+		e.definitions[name] = def
+	}
+	return map[string]any{"$ref": e.prefix + url.PathEscape(pointerEscaper.Replace(name))}, nil
+}
+
+// structSchema returns the schema of the data described by s.
+func (e *standardExporter) structSchema(s *Struct) (map[string]any, error) {
+	if len(s.Choices) > 0 {
+		return e.choicesSchema(s)
+	}
+	out := map[string]any{"type": "object"}
+	if s.ClassName != "" {
+		out["title"] = s.ClassName
+	}
+	props := make(map[string]any)
+	names, closed := e.propertyNames(s.ClassName)
+	if closed {
+		for _, name := range names {
+			props[name] = map[string]any{}
+		}
+		out["additionalProperties"] = false
+	}
+	for name, v := range s.Fields {
+		if closed {
+			f, ok := names[name]
+			if !ok {
+				return nil, fmt.Errorf("field %q not found in the Go type of class %q", name, s.ClassName)
+			}
+			name = f
+		}
+		prop, err := e.valueSchema(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", name, err)
+		}
+		props[name] = prop
+	}
+	if e.opts.Discriminator != "" && s.ClassName != "" {
+		props[e.opts.Discriminator] = map[string]any{"const": s.ClassName}
+		out["required"] = []string{e.opts.Discriminator}
+	}
+	if len(props) > 0 {
+		out["properties"] = props
+	}
+	if e.openAPI && s.ServiceName != "" {
+		out["x-service-name"] = s.ServiceName
+	}
+	return out, nil
+}
+
+// propertyNames maps the names a spec may use for the fields of the Go type of
+// class, Go field and tag names, to their property names under opts.NameTag. It
+// reports false without a NameTag or a registered struct type.
+func (e *standardExporter) propertyNames(class string) (map[string]string, bool) {
+	if e.opts.NameTag == "" || class == "" {
+		return nil, false
+	}
+	t, ok := registryOrDefault(e.opts.Registry).Lookup(class)
+	if ok && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !ok || t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	v := &validator{nameTag: e.opts.NameTag, embedded: true}
+	names := make(map[string]string)
+	for name, field := range v.structFields(t) {
+		tagName, _, _ := strings.Cut(field.Tag.Get(e.opts.NameTag), ",")
+		if tagName == "-" {
+			continue
+		}
+		if tagName == "" {
+			tagName = field.Name
+		}
+		names[name] = tagName
+	}
+	return names, true
+}
+
+// choicesSchema returns a oneOf of the choices of s. With a discriminator, each
+// branch requires the discriminator property to hold its key. For OpenAPI, the
+// $ref branches are listed in the discriminator mapping instead.
+func (e *standardExporter) choicesSchema(s *Struct) (map[string]any, error) {
+	var branches []any
+	mapping := make(map[string]any)
+	for _, key := range sortedKeys(s.Choices) {
+		branch, err := e.structRef(s.Choices[key])
+		if err != nil {
+			return nil, fmt.Errorf("choice %q: %w", key, err)
+		}
+		if ref, ok := branch["$ref"]; ok && e.openAPI {
+			mapping[key] = ref
+		} else if s.Discriminator != "" {
+			props, _ := branch["properties"].(map[string]any)
+			if props == nil {
+				props = make(map[string]any)
+				branch["properties"] = props
+			}
+			props[s.Discriminator] = map[string]any{"const": key}
+			required, _ := branch["required"].([]string)
+			branch["required"] = append(required, s.Discriminator)
+		}
+		branches = append(branches, branch)
+	}
+	out := map[string]any{"oneOf": branches}
+	if s.ClassName != "" {
+		out["title"] = s.ClassName
+	}
+	if e.openAPI && (s.Discriminator != "" || len(mapping) > 0) {
+		d := map[string]any{"mapping": mapping}
+		if s.Discriminator != "" {
+			d["propertyName"] = s.Discriminator
+		}
+		out["discriminator"] = d
+	}
+	return out, nil
+}
+
+// valueSchema returns the schema of the data described by v.
+func (e *standardExporter) valueSchema(v *Value) (map[string]any, error) {
+	switch k := v.GetKind().(type) {
+	case *Value_SingleStruct:
+		return e.structRef(k.SingleStruct)
+
+	case *Value_ListStruct:
+		out := map[string]any{"type": "array"}
+		fields := k.ListStruct.GetListFields()
+		if len(fields) == 1 {
+			item, err := e.structRef(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("items: %w", err)
+			}
+			out["items"] = item
+			return out, nil
+		}
+		if len(fields) > 0 {
+			items := make([]any, len(fields))
+			for i, x := range fields {
+				item, err := e.structRef(x)
+				if err != nil {
+					return nil, fmt.Errorf("prefixItems[%d]: %w", i, err)
+				}
+				items[i] = item
+			}
+			out["prefixItems"] = items
+			out["items"] = false
+		}
+		return out, nil
+
+	case *Value_MapStruct:
+		fields := k.MapStruct.GetMapFields()
+		out, err := e.mapSchema(fields, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, star := fields["*"]; e.openAPI && (len(fields) == 0 || len(fields) > 1 || !star) {
+			// Without the mark, explicit keys would read back as fields.
+			out["x-map"] = true
+		}
+		return out, nil
+
+	case *Value_Map2Struct:
+		fields := k.Map2Struct.GetMap2Fields()
+		star := fields["*"].GetMapFields()
+		out := map[string]any{"type": "object"}
+		if e.openAPI {
+			out["x-map2"] = true
+		}
+		props := make(map[string]any)
+		for _, key1 := range sortedKeys(fields) {
+			if key1 == "*" {
+				continue
+			}
+			// Map2Struct.StructFor falls back to the "*" region only if the
+			// region has no "*" entry of its own.
+			inner := fields[key1].GetMapFields()
+			fallback := star
+			if _, ok := inner["*"]; ok {
+				fallback = nil
+			}
+			region, err := e.mapSchema(inner, fallback)
+			if err != nil {
+				return nil, fmt.Errorf("region %q: %w", key1, err)
+			}
+			props[key1] = region
+		}
+		if len(props) > 0 {
+			out["properties"] = props
+		}
+		if star != nil {
+			region, err := e.mapSchema(star, nil)
+			if err != nil {
+				return nil, fmt.Errorf(`region "*": %w`, err)
+			}
+			out["additionalProperties"] = region
+		} else {
+			out["additionalProperties"] = false
+		}
+		return out, nil
+
+	case nil:
+		return map[string]any{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown Value kind: %T", v.Kind)
+	}
+}
+
+// mapSchema returns the schema of a JSON object whose members are described by
+// fields, then by fallback. A "*" key describes the members without an entry;
+// members with no entry at all are not allowed.
+func (e *standardExporter) mapSchema(fields, fallback map[string]*Struct) (map[string]any, error) {
+	merged := make(map[string]*Struct, len(fields)+len(fallback))
+	for key, x := range fallback {
+		merged[key] = x
+	}
+	for key, x := range fields {
+		merged[key] = x
+	}
+
+	out := map[string]any{"type": "object", "additionalProperties": false}
+	props := make(map[string]any)
+	for _, key := range sortedKeys(merged) {
+		schema, err := e.structRef(merged[key])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+		if key == "*" {
+			out["additionalProperties"] = schema
+		} else {
+			props[key] = schema
+		}
+	}
+	if len(props) > 0 {
+		out["properties"] = props
+	}
+	return out, nil
+}
+
+// sameSpec reports whether the Struct graphs at a and b describe the same data.
+// ServiceName is ignored.
+func sameSpec(a, b *Struct) bool {
+	pairs := make(map[*Struct]*Struct)
+	var same func(a, b *Struct) bool
+	sameMap := func(a, b map[string]*Struct) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for key, x := range a {
+			y, ok := b[key]
+			if !ok || !same(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	same = func(a, b *Struct) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		if p, ok := pairs[a]; ok {
+			return p == b
+		}
+		pairs[a] = b
+		if a.ClassName != b.ClassName || a.Discriminator != b.Discriminator || len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		if !sameMap(a.Choices, b.Choices) {
+			return false
+		}
+		for name, va := range a.Fields {
+			vb, ok := b.Fields[name]
+			if !ok {
+				return false
+			}
+			switch ka := va.GetKind().(type) {
+			case *Value_SingleStruct:
+				if !same(ka.SingleStruct, vb.GetSingleStruct()) {
+					return false
+				}
+			case *Value_ListStruct:
+				la, lb := ka.ListStruct.GetListFields(), vb.GetListStruct().GetListFields()
+				if vb.GetListStruct() == nil || len(la) != len(lb) {
+					return false
+				}
+				for i := range la {
+					if !same(la[i], lb[i]) {
+						return false
+					}
+				}
+			case *Value_MapStruct:
+				if vb.GetMapStruct() == nil || !sameMap(ka.MapStruct.GetMapFields(), vb.GetMapStruct().GetMapFields()) {
+					return false
+				}
+			case *Value_Map2Struct:
+				ma, mb := ka.Map2Struct.GetMap2Fields(), vb.GetMap2Struct().GetMap2Fields()
+				if vb.GetMap2Struct() == nil || len(ma) != len(mb) {
+					return false
+				}
+				for key1, inner := range ma {
+					if !sameMap(inner.GetMapFields(), mb[key1].GetMapFields()) {
+						return false
+					}
+				}
+			default:
+				if vb.GetKind() != nil {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return same(a, b)
+}
@@ -0,0 +1,204 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestToStandardJSONSchema(t *testing.T) {
+	spec, err := NewServiceStruct("Config", map[string]any{
+		"Database": []string{"Postgres", "dbService"},
+		"Servers":  [][]string{{"HTTPServer"}, {"GRPCServer"}},
+		"Backups":  [][]string{{"Postgres"}},
+		"Handlers": map[string][]string{"api": {"APIHandler"}, "*": {"WebHandler"}},
+		"Fixed":    map[string][]string{"api": {"APIHandler"}},
+		"Grid":     map[[2]string][]string{{"r1", "k1"}: {"Cell"}, {"*", "k2"}: {"Cell"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToStandardJSONSchema(spec, &StandardOptions{ID: "https://example.com/config.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"$schema":"https://json-schema.org/draft/2020-12/schema"`,
+		`"$id":"https://example.com/config.json"`,
+		`"$defs":{"APIHandler":{"title":"APIHandler","type":"object"}`,
+		`"Database":{"$ref":"#/$defs/Postgres"}`,
+		`"Backups":{"items":{"$ref":"#/$defs/Postgres"},"type":"array"}`,
+		`"Servers":{"items":false,"prefixItems":[{"$ref":"#/$defs/HTTPServer"},{"$ref":"#/$defs/GRPCServer"}],"type":"array"}`,
+		`"Handlers":{"additionalProperties":{"$ref":"#/$defs/WebHandler"},"properties":{"api":{"$ref":"#/$defs/APIHandler"}},"type":"object"}`,
+		`"Fixed":{"additionalProperties":false,"properties":{"api":{"$ref":"#/$defs/APIHandler"}},"type":"object"}`,
+		`"Grid":{"additionalProperties":{"additionalProperties":false,"properties":{"k2":{"$ref":"#/$defs/Cell"}},"type":"object"},` +
+			`"properties":{"r1":{"additionalProperties":false,"properties":{"k1":{"$ref":"#/$defs/Cell"},"k2":{"$ref":"#/$defs/Cell"}},"type":"object"}},"type":"object"}`,
+		`"title":"Config","type":"object"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "dbService") {
+		t.Errorf("service names should not be written: %s", data)
+	}
+}
+
+func TestToStandardJSONSchema_Definitions(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"A": [2]any{"Server", map[string]any{"Handler": "APIHandler"}},
+		"B": [2]any{"Server", map[string]any{"Handler": "APIHandler"}},
+		"C": [2]any{"Server", map[string]any{"Handler": "WebHandler"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToStandardJSONSchema(spec, &StandardOptions{Discriminator: "@class"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Defs       map[string]map[string]any `json:"$defs"`
+		Properties map[string]map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Properties["A"]["$ref"] != "#/$defs/Server" || doc.Properties["B"]["$ref"] != "#/$defs/Server" {
+		t.Errorf("equal classes should share a definition: %s", data)
+	}
+	if doc.Properties["C"]["$ref"] != "#/$defs/Server_2" {
+		t.Errorf("different classes of the same name should get distinct definitions: %s", data)
+	}
+	if !strings.Contains(string(data), `"properties":{"@class":{"const":"APIHandler"}},"required":["@class"]`) {
+		t.Errorf("class objects should require the discriminator: %s", data)
+	}
+}
+
+func TestToStandardJSONSchema_ChoicesAndCycles(t *testing.T) {
+	data, err := ToStandardJSONSchema(newCyclicTree(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"Owner":{"$ref":"#"}`,
+		`"Children":{"items":{"$ref":"#/$defs/Node"},"type":"array"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+
+	shape := &Struct{Discriminator: "kind", Choices: map[string]*Struct{
+		"circle": {ClassName: "Circle"},
+		"square": {ClassName: "Square"},
+	}}
+	spec := &Struct{ClassName: "Drawing", Fields: map[string]*Value{
+		"Shape": {Kind: &Value_SingleStruct{SingleStruct: shape}},
+	}}
+	data, err = ToStandardJSONSchema(spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"Shape":{"oneOf":[` +
+		`{"$ref":"#/$defs/Circle","properties":{"kind":{"const":"circle"}},"required":["kind"]},` +
+		`{"$ref":"#/$defs/Square","properties":{"kind":{"const":"square"}},"required":["kind"]}]}`
+	if !strings.Contains(string(data), want) {
+		t.Errorf("%s\nshould contain %s", data, want)
+	}
+
+	if _, err := ToStandardJSONSchema(nil, nil); err == nil {
+		t.Error("expected error for nil spec")
+	}
+}
+
+// newNestedSpec returns a spec of nested collections, whose inner collections
+// are held by wrapper Structs.
+func newNestedSpec(t *testing.T) *Struct {
+	t.Helper()
+	var spec Struct
+	err := json.Unmarshal([]byte(`{"className": "Board", "properties": {
+		"Grid": {"items": {"items": {"className": "Cell"}}},
+		"Index": {"additionalProperties": {"items": {"className": "Cell"}}}
+	}}`), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := unwrapValueFromStruct(spec.Fields["Grid"].GetListStruct().StructAt(0)); !ok {
+		t.Fatal("Grid should hold wrapped lists")
+	}
+	return &spec
+}
+
+func TestToStandardJSONSchema_NestedCollections(t *testing.T) {
+	data, err := ToStandardJSONSchema(newNestedSpec(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"Grid":{"items":{"items":{"$ref":"#/$defs/Cell"},"type":"array"},"type":"array"}`,
+		`"Index":{"additionalProperties":{"items":{"$ref":"#/$defs/Cell"},"type":"array"},"type":"object"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "__schema_") {
+		t.Errorf("wrapper names should not be written: %s", data)
+	}
+}
+
+func TestToStandardJSONSchema_NameTag(t *testing.T) {
+	type stdServer struct {
+		Host    string `json:"host"`
+		Port    int    `json:"port,omitempty"`
+		Secret  string `json:"-"`
+		Handler decShape
+	}
+	type stdConfig struct {
+		Servers []stdServer `json:"servers"`
+		Shape   decShape    `json:"shape"`
+	}
+	reg := newDecRegistry(t)
+	for name, register := range map[string]func(*Registry, string) error{
+		"Config": RegisterTo[stdConfig],
+		"Server": RegisterTo[stdServer],
+	} {
+		if err := register(reg, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec, err := ParseSpec(`Config{Servers: [Server{Handler: Circle}], shape: Square}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToStandardJSONSchema(spec, &StandardOptions{NameTag: "json", Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"additionalProperties":false,"properties":{"servers":{"items":{"$ref":"#/$defs/Server"},"type":"array"},"shape":{"$ref":"#/$defs/Square"}}`,
+		`"Server":{"additionalProperties":false,"properties":{"Handler":{"$ref":"#/$defs/Circle"},"host":{},"port":{}}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s\nshould contain %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "Secret") {
+		t.Errorf("a field tagged \"-\" should be left out: %s", data)
+	}
+
+	// Unregistered classes stay open.
+	open, _ := ParseSpec(`Config{shape: Hexagon}`)
+	if data, err = ToStandardJSONSchema(open, &StandardOptions{NameTag: "json", Registry: reg}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Hexagon":{"title":"Hexagon","type":"object"}`) {
+		t.Errorf("%s\nHexagon should allow any property", data)
+	}
+
+	bad, _ := ParseSpec(`Config{Servers: [Server{Handlr: Circle}]}`)
+	if _, err := ToStandardJSONSchema(bad, &StandardOptions{NameTag: "json", Registry: reg}); err == nil || !strings.Contains(err.Error(), `field "Handlr" not found in the Go type of class "Server"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// schemaTag is a parsed schema struct tag.
+//
+// A tag is a comma-separated list of key=value pairs:
+//
+//	╔═══════════════════════════════════════════╤══════════════════════════════════════╗
+//	║ Tag                                       │ Meaning                              ║
+//	╠═══════════════════════════════════════════╪══════════════════════════════════════╣
+//	║ schema:"class=Circle"                     │ class of the field (or every element)║
+//	║ schema:"class=Circle,service=shapeSvc"    │ class with its service name          ║
+//	║ schema:"list=Circle|Square@squareSvc"     │ positional ListStruct                ║
+//	║ schema:"map=api:APIHandler|*:WebHandler"  │ keyed MapStruct, "*" as fallback     ║
+//	║ schema:"map2=r1/k1:Cell|*/*:Cell@cellSvc" │ keyed Map2Struct                     ║
+//	║ schema:"-"                                │ field is skipped                     ║
+//	╚═══════════════════════════════════════════╧══════════════════════════════════════╝
+//
+// In list, map and map2 entries, a class may carry its service name after "@".
+type schemaTag struct {
+	elem    tagClass
+	list    []tagClass
+	mapping map[string]tagClass
+	map2    map[[2]string]tagClass
+}
+
+// tagClass is a class name with an optional service name.
+type tagClass struct {
+	class   string
+	service string
+}
+
+// parseSchemaTag parses the value of a schema struct tag.
+func parseSchemaTag(raw string) (schemaTag, error) {
+	var tag schemaTag
+	if raw == "" {
+		return tag, nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || value == "" {
+			return tag, fmt.Errorf("invalid schema tag %q: expected key=value", raw)
+		}
+		var err error
+		switch key {
+		case "class":
+			tag.elem.class = value
+		case "service":
+			tag.elem.service = value
+		case "list":
+			tag.list, err = parseTagList(value)
+		case "map":
+			tag.mapping = make(map[string]tagClass)
+			err = parseTagEntries(value, func(key string, c tagClass) error {
+				tag.mapping[key] = c
+				return nil
+			})
+		case "map2":
+			tag.map2 = make(map[[2]string]tagClass)
+			err = parseTagEntries(value, func(key string, c tagClass) error {
+				key1, key2, ok := strings.Cut(key, "/")
+				if !ok || key1 == "" || key2 == "" {
+					return fmt.Errorf("map2 key %q must have the form key1/key2", key)
+				}
+				tag.map2[[2]string{key1, key2}] = c
+				return nil
+			})
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return tag, fmt.Errorf("invalid schema tag %q: %w", raw, err)
+		}
+	}
+
+	collections := 0
+	for _, set := range []bool{tag.list != nil, tag.mapping != nil, tag.map2 != nil} {
+		if set {
+			collections++
+		}
+	}
+	if collections > 1 {
+		return tag, fmt.Errorf("invalid schema tag %q: list, map and map2 are mutually exclusive", raw)
+	}
+	if collections == 1 && (tag.elem.class != "" || tag.elem.service != "") {
+		return tag, fmt.Errorf("invalid schema tag %q: class and service cannot be combined with list, map or map2", raw)
+	}
+	return tag, nil
+}
+
+// parseTagList parses "Class@service" entries separated by "|".
+func parseTagList(value string) ([]tagClass, error) {
+	var list []tagClass
+	for _, entry := range strings.Split(value, "|") {
+		c, err := parseTagClass(entry)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+// parseTagEntries parses "key:Class@service" entries separated by "|".
+func parseTagEntries(value string, add func(key string, c tagClass) error) error {
+	for _, entry := range strings.Split(value, "|") {
+		key, class, ok := strings.Cut(entry, ":")
+		if !ok || key == "" {
+			return fmt.Errorf("entry %q must have the form key:Class", entry)
+		}
+		c, err := parseTagClass(class)
+		if err != nil {
+			return err
+		}
+		if err := add(key, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTagClass parses "Class" or "Class@service".
+func parseTagClass(entry string) (tagClass, error) {
+	class, service, _ := strings.Cut(strings.TrimSpace(entry), "@")
+	if class == "" {
+		return tagClass{}, fmt.Errorf("empty class name in %q", entry)
+	}
+	return tagClass{class: class, service: service}, nil
+}
+
+// check verifies that the tag fits a field of type t.
+func (tag schemaTag) check(t reflect.Type) error {
+	switch {
+	case tag.list != nil && t.Kind() != reflect.Slice && t.Kind() != reflect.Array:
+		return fmt.Errorf("list tag requires slice or array type, got %v", t)
+	case tag.mapping != nil && (t.Kind() != reflect.Map || !isStringKey(t.Key()) || isMap2Nested(t)):
+		return fmt.Errorf("map tag requires map[string]T type, got %v", t)
+	case tag.map2 != nil && (t.Kind() != reflect.Map || !isStringPairKey(t.Key()) && !isMap2Nested(t)):
+		return fmt.Errorf("map2 tag requires map[[2]string]T or map[string]map[string]T type, got %v", t)
+	}
+	return nil
+}
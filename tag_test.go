@@ -0,0 +1,145 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type tagDB interface {
+	Query(q string) error
+}
+
+type tagServer interface {
+	Serve() error
+}
+
+type tagHandler interface {
+	Handle() error
+}
+
+type tagCell interface {
+	Value() int
+}
+
+type tagConfig struct {
+	Database tagDB                 `schema:"class=PostgresDB,service=dbService"`
+	Servers  []tagServer           `schema:"list=HTTPServer@httpService|GRPCServer@grpcService"`
+	Handlers map[string]tagHandler `schema:"map=api:APIHandler@apiService|web:WebHandler"`
+	Grid     map[[2]string]tagCell `schema:"map2=r1/k1:Cell@cellService|r1/k2:Cell"`
+	Backups  []tagDB               `schema:"class=MySQL,service=backupService"`
+	Caches   map[string]tagDB      `schema:"class=Redis"`
+	Plain    *tagPlain             `schema:"service=plainService"`
+	Ignored  tagDB                 `schema:"-"`
+}
+
+type tagPlain struct {
+	Name string
+}
+
+func TestStructFor_TagsMatchNewServiceStruct(t *testing.T) {
+	got, unresolved, err := StructFor[tagConfig](&TypeOptions{Registry: NewRegistry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unresolved) != 0 {
+		t.Errorf("unexpected unresolved paths %v", unresolved)
+	}
+
+	want, err := NewServiceStruct("tagConfig", map[string]any{
+		"Database": []string{"PostgresDB", "dbService"},
+		"Servers": [][]string{
+			{"HTTPServer", "httpService"},
+			{"GRPCServer", "grpcService"},
+		},
+		"Handlers": map[string][]string{
+			"api": {"APIHandler", "apiService"},
+			"web": {"WebHandler"},
+		},
+		"Grid": map[[2]string][]string{
+			{"r1", "k1"}: {"Cell", "cellService"},
+			{"r1", "k2"}: {"Cell"},
+		},
+		"Backups": [][]string{{"MySQL", "backupService"}},
+		"Caches":  map[string][]string{"*": {"Redis"}},
+		"Plain":   []string{"tagPlain", "plainService"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("tag-derived spec differs from NewServiceStruct:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+type tagLeafDB struct {
+	Conn tagServer `schema:"class=TCPConn"`
+}
+
+func (d *tagLeafDB) Query(string) error { return nil }
+
+func TestStructFor_ServiceMustBeLeaf(t *testing.T) {
+	reg := NewRegistry()
+	if err := RegisterTo[tagLeafDB](reg, "LeafDB"); err != nil {
+		t.Fatal(err)
+	}
+	type config struct {
+		Database tagDB `schema:"class=LeafDB,service=dbService"`
+	}
+	_, _, err := StructFor[config](&TypeOptions{Registry: reg})
+	if err == nil {
+		t.Fatal("expected error for service on a non-leaf class")
+	}
+	if !strings.Contains(err.Error(), "service name must be on leaf struct at config.Database") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	type leafConfig struct {
+		Database tagDB `schema:"class=LeafDB"`
+	}
+	got, _, err := StructFor[leafConfig](&TypeOptions{Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := got.Fields["Database"].GetSingleStruct()
+	if db.ClassName != "LeafDB" || db.Fields["Conn"].GetSingleStruct().ClassName != "TCPConn" {
+		t.Errorf("nested class not walked: %v", db)
+	}
+}
+
+func TestParseSchemaTag_Errors(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"class", "expected key=value"},
+		{"colour=red", `unknown key "colour"`},
+		{"list=A|", "empty class name"},
+		{"map=A", "must have the form key:Class"},
+		{"map2=r1:A", "must have the form key1/key2"},
+		{"list=A,map=k:B", "mutually exclusive"},
+		{"class=A,list=B", "cannot be combined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			_, err := parseSchemaTag(tt.tag)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructFor_TagTypeMismatch(t *testing.T) {
+	type config struct {
+		Database tagDB `schema:"list=A|B"`
+	}
+	_, _, err := StructFor[config]()
+	if err == nil || !strings.Contains(err.Error(), "config.Database: list tag requires slice or array type") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
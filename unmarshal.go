@@ -0,0 +1,491 @@
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UnmarshalJSONWithSpec decodes JSON data into target, using spec to choose the
+// concrete class of every interface field.
+//
+// The spec is walked alongside the data:
+//   - SingleStruct: the named class is instantiated from the registry and decoded into
+//   - ListStruct: each array element i is decoded into the class of ListFields[i],
+//     or of ListFields[0] if the list has a single entry
+//   - MapStruct: each object member is decoded into the class of MapFields[key],
+//     or of MapFields["*"]
+//   - Map2Struct: a two-level JSON object is decoded into a map[[2]string]T
+//     or map[string]map[string]T field
+//
+// A Struct with Choices picks the choice named by the string member Discriminator
+// of the JSON object, then decodes as that choice.
+//
+// Objects in the protobuf JSON form of google.protobuf.Any carry their type in an
+// "@type" member, e.g. "type.googleapis.com/shapes.v1.Circle". For a Struct with
+// no ClassName, such as one from an Any field of StructFromDescriptor, the full
+// message name after the last '/' is the class to instantiate; for Choices without
+// a Discriminator, it picks the choice of that class. UnmarshalProtoWithSpec maps
+// type URLs to classes in other ways.
+//
+// Nested Fields are applied recursively. Fields that are not in the spec are decoded
+// by encoding/json as usual. Spec field names are Go field names; the JSON member is
+// found through the field's json tag, or its name matched case-insensitively.
+//
+// If reg is omitted, DefaultRegistry is used. Concrete (non-interface) struct and
+// pointer fields do not need a registered class. Errors report the location with the
+// same path format as NewServiceStruct, e.g. "Config.Servers[1]".
+func UnmarshalJSONWithSpec(data []byte, target any, spec *Struct, reg ...*Registry) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("UnmarshalJSONWithSpec: target must be a non-nil pointer, got %T", target)
+	}
+	if spec == nil {
+		return json.Unmarshal(data, target)
+	}
+
+	d := &jsonSpecDecoder{reg: DefaultRegistry}
+	if len(reg) > 0 && reg[0] != nil {
+		d.reg = reg[0]
+	}
+	if err := d.decodeSingle(data, rv.Elem(), spec, rootPath(spec)); err != nil {
+		return fmt.Errorf("UnmarshalJSONWithSpec: %w", err)
+	}
+	return nil
+}
+
+type jsonSpecDecoder struct {
+	reg *Registry
+	// typeURLs maps Any type URLs to class names, before their full message names.
+	typeURLs map[string]string
+}
+
+// decodeSingle decodes raw into dst, whose type may be an interface,
+// a pointer or a struct, according to the class described by s.
+func (d *jsonSpecDecoder) decodeSingle(raw json.RawMessage, dst reflect.Value, s *Struct, path string) error {
+	if isJSONNull(raw) {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if s == nil {
+		return atPath(path, json.Unmarshal(raw, dst.Addr().Interface()))
+	}
+	if s.Discriminator == "" && (s.ClassName == "" || len(s.Choices) > 0) {
+		if resolved, key, err := d.typedStruct(raw, s); err != nil {
+			return atPath(path, err)
+		} else if resolved != nil {
+			return d.decodeSingle(raw, dst, resolved, fmt.Sprintf("%s(%s)", path, key))
+		}
+	}
+	if len(s.Choices) > 0 {
+		key, err := discriminatorValue(raw, s.Discriminator)
+		if err != nil {
+			return atPath(path, err)
+		}
+		choice := s.ChoiceFor(key)
+		if choice == nil {
+			return atPath(path, fmt.Errorf("no choice for %s %q", s.Discriminator, key))
+		}
+		return d.decodeSingle(raw, dst, choice, fmt.Sprintf("%s(%s)", path, key))
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		obj, err := d.reg.New(s.ClassName)
+		if err != nil {
+			return atPath(path, err)
+		}
+		ptr := reflect.ValueOf(obj)
+		if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+			return atPath(path, fmt.Errorf("class %q must be instantiated as a non-nil pointer, got %T", s.ClassName, obj))
+		}
+		if err := d.decodeObject(raw, ptr.Elem(), s, path); err != nil {
+			return err
+		}
+		return atPath(path, assignInstance(dst, ptr, s.ClassName))
+	case reflect.Ptr:
+		ptr := reflect.New(dst.Type().Elem())
+		if err := d.decodeObject(raw, ptr.Elem(), s, path); err != nil {
+			return err
+		}
+		dst.Set(ptr)
+		return nil
+	default:
+		return d.decodeObject(raw, dst, s, path)
+	}
+}
+
+// typedStruct returns the Struct of the class named by the "@type" member of
+// the JSON object raw, and the key of the choice or the class name to report in
+// paths. It returns nil if raw has no such member.
+func (d *jsonSpecDecoder) typedStruct(raw json.RawMessage, s *Struct) (*Struct, string, error) {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(raw, &members) != nil {
+		return nil, "", nil
+	}
+	member, ok := members["@type"]
+	if !ok {
+		return nil, "", nil
+	}
+	var typeURL string
+	if err := json.Unmarshal(member, &typeURL); err != nil {
+		return nil, "", fmt.Errorf("@type must be a string: %w", err)
+	}
+	class, ok := d.typeURLs[typeURL]
+	if !ok {
+		class = typeURL[strings.LastIndex(typeURL, "/")+1:]
+	}
+	if class == "" {
+		return nil, "", fmt.Errorf("no class for type URL %q", typeURL)
+	}
+	if len(s.Choices) == 0 {
+		return &Struct{ClassName: class, ServiceName: s.ServiceName, Fields: s.Fields}, class, nil
+	}
+	for _, key := range sortedKeys(s.Choices) {
+		if s.Choices[key].GetClassName() == class {
+			return s.Choices[key], key, nil
+		}
+	}
+	return nil, "", fmt.Errorf("no choice of class %q for type URL %q", class, typeURL)
+}
+
+// discriminatorValue reads the string member name of the JSON object raw.
+func discriminatorValue(raw json.RawMessage, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("spec has choices but no discriminator")
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return "", err
+	}
+	member, ok := members[name]
+	if !ok {
+		return "", fmt.Errorf("discriminator %q not found", name)
+	}
+	var key string
+	if err := json.Unmarshal(member, &key); err != nil {
+		return "", fmt.Errorf("discriminator %q must be a string: %w", name, err)
+	}
+	return key, nil
+}
+
+// decodeObject decodes raw into the addressable value rv, applying the nested
+// field specifications of s if rv is a struct.
+func (d *jsonSpecDecoder) decodeObject(raw json.RawMessage, rv reflect.Value, s *Struct, path string) error {
+	if rv.Kind() != reflect.Struct || len(s.GetFields()) == 0 {
+		return atPath(path, json.Unmarshal(raw, rv.Addr().Interface()))
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return atPath(path, err)
+	}
+
+	type pending struct {
+		name  string
+		field reflect.StructField
+		raw   json.RawMessage
+	}
+	var specFields []pending
+	t := rv.Type()
+	for _, name := range sortedKeys(s.Fields) {
+		field, ok := t.FieldByName(name)
+		if !ok || !field.IsExported() {
+			return atPath(path, fmt.Errorf("field %q in spec not found in struct %s", name, t.Name()))
+		}
+		jsonName, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		key, ok := findJSONMember(members, jsonName)
+		if !ok {
+			continue
+		}
+		specFields = append(specFields, pending{name: name, field: field, raw: members[key]})
+		// Members differing only in case would reach the field through encoding/json.
+		for other := range members {
+			if strings.EqualFold(other, jsonName) {
+				delete(members, other)
+			}
+		}
+	}
+
+	rest, err := json.Marshal(members)
+	if err != nil {
+		return atPath(path, err)
+	}
+	if err := json.Unmarshal(rest, rv.Addr().Interface()); err != nil {
+		return atPath(path, err)
+	}
+
+	for _, p := range specFields {
+		field, err := fieldByIndexAlloc(rv, p.field.Index)
+		if err != nil {
+			return atPath(path+"."+p.name, err)
+		}
+		if err := d.decodeValue(p.raw, field, s.Fields[p.name], path+"."+p.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndexAlloc returns the field of the struct rv at index, allocating nil
+// pointers to embedded structs on the way, as encoding/json does.
+func fieldByIndexAlloc(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %v", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
+
+// decodeValue decodes raw into dst according to the kind of v.
+func (d *jsonSpecDecoder) decodeValue(raw json.RawMessage, dst reflect.Value, v *Value, path string) error {
+	if v == nil || v.GetKind() == nil {
+		return atPath(path, json.Unmarshal(raw, dst.Addr().Interface()))
+	}
+	if isJSONNull(raw) {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	switch k := v.Kind.(type) {
+	case *Value_SingleStruct:
+		return d.decodeSingle(raw, dst, k.SingleStruct, path)
+	case *Value_ListStruct:
+		return d.decodeList(raw, dst, k.ListStruct, path)
+	case *Value_MapStruct:
+		return d.decodeMap(raw, dst, k.MapStruct, path)
+	case *Value_Map2Struct:
+		return d.decodeMap2(raw, dst, k.Map2Struct, path)
+	default:
+		return atPath(path, fmt.Errorf("unknown Value kind: %T", v.Kind))
+	}
+}
+
+func (d *jsonSpecDecoder) decodeList(raw json.RawMessage, dst reflect.Value, ls *ListStruct, path string) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return atPath(path, err)
+	}
+
+	switch dst.Kind() {
+	case reflect.Slice:
+		dst.Set(reflect.MakeSlice(dst.Type(), len(items), len(items)))
+	case reflect.Array:
+		if len(items) > dst.Len() {
+			return atPath(path, fmt.Errorf("array of length %d cannot hold %d elements", dst.Len(), len(items)))
+		}
+	default:
+		return atPath(path, fmt.Errorf("ListStruct requires slice or array type, got %v", dst.Kind()))
+	}
+
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		s := ls.StructAt(i)
+		if s == nil {
+			return atPath(itemPath, fmt.Errorf("no class specified for list index %d", i))
+		}
+		if err := d.decodeSingle(item, dst.Index(i), s, itemPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *jsonSpecDecoder) decodeMap(raw json.RawMessage, dst reflect.Value, ms *MapStruct, path string) error {
+	if dst.Kind() != reflect.Map {
+		return atPath(path, fmt.Errorf("MapStruct requires map type, got %v", dst.Kind()))
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return atPath(path, err)
+	}
+
+	t := dst.Type()
+	out := reflect.MakeMapWithSize(t, len(members))
+	for _, key := range sortedKeys(members) {
+		itemPath := fmt.Sprintf("%s[%q]", path, key)
+		s := ms.StructFor(key)
+		if s == nil {
+			return atPath(itemPath, fmt.Errorf("no class specified for map key %q", key))
+		}
+		keyValue, err := mapKey(t.Key(), key)
+		if err != nil {
+			return atPath(itemPath, err)
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := d.decodeSingle(members[key], elem, s, itemPath); err != nil {
+			return err
+		}
+		out.SetMapIndex(keyValue, elem)
+	}
+	dst.Set(out)
+	return nil
+}
+
+func (d *jsonSpecDecoder) decodeMap2(raw json.RawMessage, dst reflect.Value, m2s *Map2Struct, path string) error {
+	if dst.Kind() != reflect.Map {
+		return atPath(path, fmt.Errorf("Map2Struct requires map type, got %v", dst.Kind()))
+	}
+	var members map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return atPath(path, err)
+	}
+
+	t := dst.Type()
+	nested := t.Elem().Kind() == reflect.Map
+	if !nested && !isStringPairKey(t.Key()) {
+		return atPath(path, fmt.Errorf("Map2Struct requires map[[2]string]T or map[string]map[string]T, got %v", t))
+	}
+
+	out := reflect.MakeMap(t)
+	for _, key1 := range sortedKeys(members) {
+		var inner reflect.Value
+		if nested {
+			inner = reflect.MakeMapWithSize(t.Elem(), len(members[key1]))
+		}
+		for _, key2 := range sortedKeys(members[key1]) {
+			itemPath := fmt.Sprintf("%s[%q][%q]", path, key1, key2)
+			s := m2s.StructFor(key1, key2)
+			if s == nil {
+				return atPath(itemPath, fmt.Errorf("no class specified for map keys [%q, %q]", key1, key2))
+			}
+			if nested {
+				keyValue, err := mapKey(t.Elem().Key(), key2)
+				if err != nil {
+					return atPath(itemPath, err)
+				}
+				elem := reflect.New(t.Elem().Elem()).Elem()
+				if err := d.decodeSingle(members[key1][key2], elem, s, itemPath); err != nil {
+					return err
+				}
+				inner.SetMapIndex(keyValue, elem)
+				continue
+			}
+			keyValue := reflect.New(t.Key()).Elem()
+			keyValue.Index(0).SetString(key1)
+			keyValue.Index(1).SetString(key2)
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.decodeSingle(members[key1][key2], elem, s, itemPath); err != nil {
+				return err
+			}
+			out.SetMapIndex(keyValue, elem)
+		}
+		if nested {
+			keyValue, err := mapKey(t.Key(), key1)
+			if err != nil {
+				return atPath(path, err)
+			}
+			out.SetMapIndex(keyValue, inner)
+		}
+	}
+	dst.Set(out)
+	return nil
+}
+
+// assignInstance stores ptr, or the value it points to, into dst,
+// whichever is assignable to the type of dst.
+func assignInstance(dst reflect.Value, ptr reflect.Value, className string) error {
+	if ptr.Type().AssignableTo(dst.Type()) {
+		dst.Set(ptr)
+		return nil
+	}
+	if ptr.Kind() == reflect.Ptr && ptr.Elem().Type().AssignableTo(dst.Type()) {
+		dst.Set(ptr.Elem())
+		return nil
+	}
+	return fmt.Errorf("class %q (%v) is not assignable to %v", className, ptr.Type(), dst.Type())
+}
+
+// mapKey converts a string key into a value of the map key type t.
+func mapKey(t reflect.Type, key string) (reflect.Value, error) {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		kv := reflect.New(t)
+		if err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			return reflect.Value{}, err
+		}
+		return kv.Elem(), nil
+	}
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(key).Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported map key type %v", t)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isStringPairKey reports whether t is [2]string or a type defined on it.
+func isStringPairKey(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Len() == 2 && t.Elem().Kind() == reflect.String
+}
+
+// jsonFieldName returns the JSON member name of a struct field, or false if
+// the field is tagged `json:"-"` and so never decoded, as in encoding/json.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		if tag == "-" {
+			return "", false
+		}
+		if name, _, _ := strings.Cut(tag, ","); name != "" {
+			return name, true
+		}
+	}
+	return field.Name, true
+}
+
+// findJSONMember finds name in members, preferring an exact match
+// and falling back to a case-insensitive one like encoding/json. Of several
+// case-insensitive matches, the first in sorted order is taken.
+func findJSONMember(members map[string]json.RawMessage, name string) (string, bool) {
+	if _, ok := members[name]; ok {
+		return name, true
+	}
+	for _, key := range sortedKeys(members) {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+// rootPath returns the path prefix used in error messages for a top-level Struct.
+func rootPath(s *Struct) string {
+	if s != nil && s.ClassName != "" {
+		return s.ClassName
+	}
+	return "<root>"
+}
+
+// atPath prefixes a non-nil error with its location in the spec.
+func atPath(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,337 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+type decShape interface {
+	Area() float64
+}
+
+type decCircle struct {
+	Radius float64 `json:"radius"`
+}
+
+func (c *decCircle) Area() float64 { return 3.14 * c.Radius * c.Radius }
+
+type decSquare struct {
+	Side float64
+}
+
+func (s decSquare) Area() float64 { return s.Side * s.Side }
+
+type decCanvas struct {
+	Name   string
+	Shape  decShape `json:"shape"`
+	Layers []decShape
+}
+
+func (c *decCanvas) Area() float64 { return 0 }
+
+type decGeo struct {
+	Title    string
+	Primary  decShape
+	Shapes   []decShape
+	ByName   map[string]decShape
+	Grid     map[[2]string]decShape
+	Nested   map[string]map[string]decShape
+	Canvas   *decCanvas
+	Fallback decShape
+}
+
+func newDecRegistry(t *testing.T) *Registry {
+	t.Helper()
+	reg := NewRegistry()
+	for name, register := range map[string]func(*Registry, string) error{
+		"Circle": RegisterTo[decCircle],
+		"Square": RegisterTo[decSquare],
+		"Canvas": RegisterTo[decCanvas],
+		"Plain":  RegisterTo[regCircle],
+	} {
+		if err := register(reg, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reg
+}
+
+func TestUnmarshalJSONWithSpec_AllKinds(t *testing.T) {
+	reg := newDecRegistry(t)
+	spec, err := NewStruct("Geo", map[string]any{
+		"Primary": "Circle",
+		"Shapes":  []string{"Square", "Circle"},
+		"ByName":  map[string]string{"*": "Square", "round": "Circle"},
+		"Grid":    map[[2]string]string{{"r1", "k1"}: "Circle", {"r1", "k2"}: "Square"},
+		"Nested":  map[[2]string]string{{"a", "b"}: "Square"},
+		"Canvas": [2]any{"Canvas", map[string]any{
+			"Shape":  "Circle",
+			"Layers": []string{"Square"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `{
+		"Title": "demo",
+		"Primary": {"radius": 2},
+		"Shapes": [{"Side": 3}, {"radius": 1}],
+		"ByName": {"box": {"Side": 4}, "round": {"radius": 5}},
+		"Grid": {"r1": {"k1": {"radius": 6}, "k2": {"Side": 7}}},
+		"Nested": {"a": {"b": {"Side": 8}}},
+		"Canvas": {"Name": "c", "shape": {"radius": 9}, "Layers": [{"Side": 1}, {"Side": 2}]}
+	}`
+
+	var geo decGeo
+	if err := UnmarshalJSONWithSpec([]byte(data), &geo, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+
+	if geo.Title != "demo" {
+		t.Errorf("Title = %q", geo.Title)
+	}
+	if c, ok := geo.Primary.(*decCircle); !ok || c.Radius != 2 {
+		t.Errorf("Primary = %#v", geo.Primary)
+	}
+	if s, ok := geo.Shapes[0].(*decSquare); !ok || s.Side != 3 {
+		t.Errorf("Shapes[0] = %#v", geo.Shapes[0])
+	}
+	if c, ok := geo.Shapes[1].(*decCircle); !ok || c.Radius != 1 {
+		t.Errorf("Shapes[1] = %#v", geo.Shapes[1])
+	}
+	if s, ok := geo.ByName["box"].(*decSquare); !ok || s.Side != 4 {
+		t.Errorf("ByName[box] = %#v", geo.ByName["box"])
+	}
+	if c, ok := geo.ByName["round"].(*decCircle); !ok || c.Radius != 5 {
+		t.Errorf("ByName[round] = %#v", geo.ByName["round"])
+	}
+	if c, ok := geo.Grid[[2]string{"r1", "k1"}].(*decCircle); !ok || c.Radius != 6 {
+		t.Errorf("Grid[r1,k1] = %#v", geo.Grid[[2]string{"r1", "k1"}])
+	}
+	if s, ok := geo.Grid[[2]string{"r1", "k2"}].(*decSquare); !ok || s.Side != 7 {
+		t.Errorf("Grid[r1,k2] = %#v", geo.Grid[[2]string{"r1", "k2"}])
+	}
+	if s, ok := geo.Nested["a"]["b"].(*decSquare); !ok || s.Side != 8 {
+		t.Errorf("Nested[a][b] = %#v", geo.Nested["a"]["b"])
+	}
+	if geo.Canvas == nil || geo.Canvas.Name != "c" {
+		t.Fatalf("Canvas = %#v", geo.Canvas)
+	}
+	if c, ok := geo.Canvas.Shape.(*decCircle); !ok || c.Radius != 9 {
+		t.Errorf("Canvas.Shape = %#v", geo.Canvas.Shape)
+	}
+	if len(geo.Canvas.Layers) != 2 {
+		t.Fatalf("Canvas.Layers = %#v", geo.Canvas.Layers)
+	}
+	if s, ok := geo.Canvas.Layers[1].(*decSquare); !ok || s.Side != 2 {
+		t.Errorf("Canvas.Layers[1] = %#v", geo.Canvas.Layers[1])
+	}
+}
+
+func TestUnmarshalJSONWithSpec_InterfaceTarget(t *testing.T) {
+	reg := newDecRegistry(t)
+	spec, err := NewStruct("Canvas", map[string]any{"Shape": "Square"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shape decShape
+	if err := UnmarshalJSONWithSpec([]byte(`{"shape": {"Side": 2}}`), &shape, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+	canvas, ok := shape.(*decCanvas)
+	if !ok {
+		t.Fatalf("expected *decCanvas, got %T", shape)
+	}
+	if s, ok := canvas.Shape.(*decSquare); !ok || s.Side != 2 {
+		t.Errorf("Shape = %#v", canvas.Shape)
+	}
+}
+
+func TestUnmarshalJSONWithSpec_Null(t *testing.T) {
+	reg := newDecRegistry(t)
+	spec, err := NewStruct("Geo", map[string]any{"Primary": "Circle", "Shapes": []string{"Circle"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo := decGeo{Primary: &decCircle{Radius: 1}}
+	if err := UnmarshalJSONWithSpec([]byte(`{"Primary": null, "Shapes": null}`), &geo, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+	if geo.Primary != nil || geo.Shapes != nil {
+		t.Errorf("expected nil fields, got %#v", geo)
+	}
+}
+
+func TestUnmarshalJSONWithSpec_FieldNames(t *testing.T) {
+	type tagged struct {
+		Shape  decShape `json:"shape"`
+		Hidden decShape `json:"-"`
+	}
+	reg := newDecRegistry(t)
+	spec, err := NewStruct("Tagged", map[string]any{"Shape": "Circle", "Hidden": "Circle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Of the case-insensitive matches, the first in sorted order is decoded;
+	// a field tagged "-" is skipped.
+	data := `{"Shape": {"radius": 2}, "SHAPE": {"radius": 1}, "Hidden": {"radius": 3}}`
+	for i := 0; i < 10; i++ {
+		var v tagged
+		if err := UnmarshalJSONWithSpec([]byte(data), &v, spec, reg); err != nil {
+			t.Fatal(err)
+		}
+		if c, ok := v.Shape.(*decCircle); !ok || c.Radius != 1 {
+			t.Fatalf("Shape = %#v", v.Shape)
+		}
+		if v.Hidden != nil {
+			t.Fatalf("Hidden = %#v, want nil", v.Hidden)
+		}
+	}
+}
+
+func TestUnmarshalJSONWithSpec_EmbeddedPointer(t *testing.T) {
+	type Base struct {
+		Shape decShape
+	}
+	type derived struct {
+		*Base
+		Name string
+	}
+	reg := newDecRegistry(t)
+	spec, err := NewStruct("Derived", map[string]any{"Shape": "Circle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v derived
+	if err := UnmarshalJSONWithSpec([]byte(`{"Name": "d", "Shape": {"radius": 2}}`), &v, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+	if v.Base == nil {
+		t.Fatal("the embedded pointer should be allocated")
+	}
+	if c, ok := v.Shape.(*decCircle); !ok || c.Radius != 2 || v.Name != "d" {
+		t.Errorf("got %#v", v)
+	}
+}
+
+func TestUnmarshalJSONWithSpec_Errors(t *testing.T) {
+	reg := newDecRegistry(t)
+	tests := []struct {
+		name string
+		spec map[string]any
+		data string
+		want string
+	}{
+		{
+			name: "unregistered class",
+			spec: map[string]any{"Primary": "Triangle"},
+			data: `{"Primary": {}}`,
+			want: `Geo.Primary: class not registered: "Triangle"`,
+		},
+		{
+			name: "list index path",
+			spec: map[string]any{"Shapes": []string{"Circle", "Hexagon"}},
+			data: `{"Shapes": [{}, {}]}`,
+			want: `Geo.Shapes[1]: class not registered: "Hexagon"`,
+		},
+		{
+			name: "list index out of range",
+			spec: map[string]any{"Shapes": []string{"Circle", "Square"}},
+			data: `{"Shapes": [{}, {}, {}]}`,
+			want: `Geo.Shapes[2]: no class specified`,
+		},
+		{
+			name: "map key without class",
+			spec: map[string]any{"ByName": map[string]string{"a": "Circle"}},
+			data: `{"ByName": {"b": {}}}`,
+			want: `Geo.ByName["b"]: no class specified`,
+		},
+		{
+			name: "nested field path",
+			spec: map[string]any{"Canvas": [2]any{"Canvas", map[string]any{"Layers": []string{"Octagon"}}}},
+			data: `{"Canvas": {"Layers": [{}]}}`,
+			want: `Geo.Canvas.Layers[0]: class not registered`,
+		},
+		{
+			name: "missing Go field",
+			spec: map[string]any{"Missing": "Circle"},
+			data: `{}`,
+			want: `field "Missing" in spec not found`,
+		},
+		{
+			name: "not assignable",
+			spec: map[string]any{"Fallback": "Plain"},
+			data: `{"Fallback": {}}`,
+			want: `Geo.Fallback: class "Plain" (*schema.regCircle) is not assignable to schema.decShape`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := NewStruct("Geo", tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var geo decGeo
+			err = UnmarshalJSONWithSpec([]byte(tt.data), &geo, spec, reg)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONWithSpec_NonPointer(t *testing.T) {
+	if err := UnmarshalJSONWithSpec([]byte(`{}`), decGeo{}, &Struct{ClassName: "Geo"}); err == nil {
+		t.Error("expected error for non-pointer target")
+	}
+}
+
+func TestUnmarshalJSONWithSpec_Choices(t *testing.T) {
+	reg := newDecRegistry(t)
+	shape := &Struct{
+		Discriminator: "kind",
+		Choices: map[string]*Struct{
+			"circle": {ClassName: "Circle"},
+			"square": {ClassName: "Square"},
+		},
+	}
+	spec := &Struct{ClassName: "Geo", Fields: map[string]*Value{
+		"Primary": {Kind: &Value_SingleStruct{SingleStruct: shape}},
+		"Shapes":  {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{shape}}}},
+	}}
+
+	data := `{
+		"Primary": {"kind": "square", "Side": 2},
+		"Shapes": [{"kind": "circle", "radius": 1}, {"kind": "square", "Side": 3}]
+	}`
+	var geo decGeo
+	if err := UnmarshalJSONWithSpec([]byte(data), &geo, spec, reg); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := geo.Primary.(*decSquare); !ok || s.Side != 2 {
+		t.Errorf("Primary = %#v", geo.Primary)
+	}
+	if c, ok := geo.Shapes[0].(*decCircle); !ok || c.Radius != 1 {
+		t.Errorf("Shapes[0] = %#v", geo.Shapes[0])
+	}
+	if s, ok := geo.Shapes[1].(*decSquare); !ok || s.Side != 3 {
+		t.Errorf("Shapes[1] = %#v", geo.Shapes[1])
+	}
+
+	for data, want := range map[string]string{
+		`{"Primary": {"Side": 2}}`:                        `Geo.Primary: discriminator "kind" not found`,
+		`{"Primary": {"kind": "hexagon"}}`:                `Geo.Primary: no choice for kind "hexagon"`,
+		`{"Shapes": [{"kind": 1}]}`:                       `Geo.Shapes[0]: discriminator "kind" must be a string`,
+		`{"Shapes": [{"kind": "circle", "radius": "x"}]}`: `Geo.Shapes[0](circle): json: cannot unmarshal`,
+	} {
+		err := UnmarshalJSONWithSpec([]byte(data), &geo, spec, reg)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v should contain %q", data, err, want)
+		}
+	}
+}
@@ -3,28 +3,108 @@ package schema
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"unicode"
 )
 
+// ValidateOptions configures ValidateStructWithOptions.
+type ValidateOptions struct {
+	// Registry maps ClassName to Go types. When set, validation descends into the
+	// nested Struct of every SingleStruct, ListStruct, MapStruct and Map2Struct entry
+	// and checks it against its own concrete type: the Go field (or element) type
+	// if it is a struct, or else the type registered for the entry's ClassName.
+	// A registered class assigned to an interface field (or to the elements of a
+	// slice, map or map2 of interfaces) must implement that interface, with either
+	// pointer or value receivers.
+	Registry *Registry
+	// NameTag is a struct tag key, such as "json" or "hcl", whose name (the part
+	// before the first comma) is accepted as a spec field name in addition to the
+	// Go field name, so that specs authored from the wire view validate as well.
+	NameTag string
+	// Embedded promotes the exported fields of anonymous embedded structs (or
+	// pointers to structs) the way encoding/json does: the shallowest field wins,
+	// and of several at the same depth, only a single tagged one wins.
+	Embedded bool
+}
+
 // ValidateStruct checks that spec.Fields align with the Go struct fields.
 // This provides early failure detection during service initialization.
 //
 // Validation rules:
 //   - Each field name in spec.Fields must exist as an exported field in obj
 //   - The Go field type must be compatible with the spec Value type:
-//     - Map2Struct requires map type
-//     - MapStruct requires map type
-//     - ListStruct requires slice, array, or map type
-//     - SingleStruct requires struct, pointer, or interface type
+//   - Map2Struct requires map type, keyed by [2]string or map[string]map[string]T
+//   - MapStruct requires map type, keyed by a string or encoding.TextUnmarshaler
+//   - ListStruct requires slice or array type
+//   - SingleStruct requires struct, pointer, or interface type
+//   - Explicit MapStruct and Map2Struct keys (other than "*") must be accepted
+//     by the UnmarshalText method of an encoding.TextUnmarshaler key type
+//
+// Only the top level of spec.Fields is checked; use ValidateStructWithOptions
+// with a Registry to validate nested classes as well. Fields are checked in
+// sorted order, so the reported error is deterministic.
 //
 // Returns nil if spec is nil, has no fields, or all fields validate successfully.
 // Returns an error describing the first validation failure found.
 func ValidateStruct(obj any, spec *Struct) error {
+	return ValidateStructWithOptions(obj, spec, nil)
+}
+
+// ValidateStructWithOptions checks spec against obj like ValidateStruct.
+// If opts.Registry is set, validation is recursive: a wrong field name three
+// levels down is reported with its full path, e.g.
+//
+//	ValidateStruct: field "Config.Database.Connection.Hots" in spec not found in struct TCPConnection
+//
+// An interface field whose nested Struct has Fields but whose ClassName is not
+// registered is an error, since it cannot be checked.
+//
+// The returned error wraps a *FieldError.
+func ValidateStructWithOptions(obj any, spec *Struct, opts *ValidateOptions) error {
+	errs, err := validate(obj, spec, opts, false)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("ValidateStruct: %w", errs[0])
+	}
+	return nil
+}
+
+// ValidateStructAll checks spec against obj like ValidateStructWithOptions, but
+// does not stop at the first problem. It returns nil or a ValidationErrors holding
+// every failure, sorted by path, so that a misconfigured service can report all
+// of its problems at once:
+//
+//	err := schema.ValidateStructAll(&Config{}, spec, nil)
+//	var verrs schema.ValidationErrors
+//	if errors.As(err, &verrs) {
+//	    for _, fe := range verrs {
+//	        log.Printf("%s: %s (expected %s, got %s)", fe.Path, fe.Code, fe.Expected, fe.Actual)
+//	    }
+//	}
+//
+// errors.Is(err, ErrFieldNotFound) and the like report whether any failure has
+// that code. An obj that is not a struct is returned as a plain error.
+func ValidateStructAll(obj any, spec *Struct, opts *ValidateOptions) error {
+	errs, err := validate(obj, spec, opts, true)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate runs the validator over obj, collecting every failure if all is set.
+func validate(obj any, spec *Struct, opts *ValidateOptions, all bool) (ValidationErrors, error) {
 	if spec == nil || len(spec.GetFields()) == 0 {
-		return nil
+		return nil, nil
 	}
 	if obj == nil {
-		return fmt.Errorf("ValidateStruct: object is nil")
+		return nil, fmt.Errorf("ValidateStruct: object is nil")
 	}
 
 	t := reflect.TypeOf(obj)
@@ -32,36 +112,287 @@ func ValidateStruct(obj any, spec *Struct) error {
 		t = t.Elem()
 	}
 	if t.Kind() != reflect.Struct {
-		return fmt.Errorf("ValidateStruct: object must be a struct or pointer to struct, got %v", t.Kind())
+		return nil, fmt.Errorf("ValidateStruct: object must be a struct or pointer to struct, got %v", t.Kind())
+	}
+
+	v := &validator{all: all, seen: make(map[validatePair]bool)}
+	if opts != nil {
+		v.reg = opts.Registry
+		v.nameTag = opts.NameTag
+		v.embedded = opts.Embedded
+	}
+	path := spec.ClassName
+	if path == "" {
+		path = t.Name()
+	}
+	v.validateStruct(t, spec, path)
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].Path < v.errs[j].Path
+	})
+	return v.errs, nil
+}
+
+type validatePair struct {
+	spec *Struct
+	typ  reflect.Type
+}
+
+type validator struct {
+	reg      *Registry
+	nameTag  string
+	embedded bool
+	all      bool
+	errs     ValidationErrors
+	seen     map[validatePair]bool
+}
+
+// report records a failure and reports whether validation should stop.
+func (v *validator) report(fe *FieldError) bool {
+	v.errs = append(v.errs, fe)
+	return !v.all
+}
+
+// validateStruct checks the fields of spec against the struct type t.
+// It reports whether validation should stop.
+func (v *validator) validateStruct(t reflect.Type, spec *Struct, path string) bool {
+	pair := validatePair{spec, t}
+	if v.seen[pair] {
+		return false
+	}
+	v.seen[pair] = true
+
+	structFields := v.structFields(t)
+
+	// Validate each spec field exists and type matches
+	for _, name := range sortedKeys(spec.GetFields()) {
+		value := spec.Fields[name]
+		fieldPath := path + "." + name
+		field, ok := structFields[name]
+		if !ok {
+			if v.report(&FieldError{Path: fieldPath, Code: CodeFieldNotFound, Actual: t.Name()}) {
+				return true
+			}
+			continue
+		}
+
+		if fe := validateFieldType(field, value); fe != nil {
+			fe.Path = fieldPath
+			if v.report(fe) {
+				return true
+			}
+			continue
+		}
+		if errs := validateKeys(field.Type, value); len(errs) > 0 {
+			for _, fe := range errs {
+				fe.Path = fieldPath + fe.Path
+				if v.report(fe) {
+					return true
+				}
+			}
+			continue
+		}
+		if v.reg != nil && v.validateNestedValue(field.Type, value, fieldPath) {
+			return true
+		}
 	}
 
-	// Build map of struct fields by name
-	structFields := make(map[string]reflect.StructField)
+	return false
+}
+
+// fieldCandidate is a struct field reachable under some name.
+type fieldCandidate struct {
+	field  reflect.StructField
+	depth  int
+	tagged bool
+}
+
+// structFields maps every name a spec may use for a field of t to that field.
+func (v *validator) structFields(t reflect.Type) map[string]reflect.StructField {
+	candidates := make(map[string][]fieldCandidate)
+	v.collectFields(t, 0, map[reflect.Type]bool{}, candidates)
+
+	fields := make(map[string]reflect.StructField, len(candidates))
+	for name, list := range candidates {
+		if f, ok := dominantField(list); ok {
+			fields[name] = f
+		}
+	}
+	return fields
+}
+
+// collectFields adds the fields of t at the given embedding depth to candidates.
+func (v *validator) collectFields(t reflect.Type, depth int, visiting map[reflect.Type]bool, candidates map[string][]fieldCandidate) {
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
+		tagName := ""
+		if v.nameTag != "" {
+			tagName, _, _ = strings.Cut(field.Tag.Get(v.nameTag), ",")
+		}
+
+		if v.embedded && field.Anonymous && tagName == "" {
+			et := field.Type
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				v.collectFields(et, depth+1, visiting, candidates)
+				continue
+			}
+		}
 		// Only consider exported fields
-		if len(field.Name) > 0 && unicode.IsUpper([]rune(field.Name)[0]) {
-			structFields[field.Name] = field
+		if len(field.Name) == 0 || !unicode.IsUpper([]rune(field.Name)[0]) {
+			continue
+		}
+
+		tagged := tagName != "" && tagName != "-"
+		candidates[field.Name] = append(candidates[field.Name], fieldCandidate{field, depth, tagged})
+		if tagged && tagName != field.Name {
+			candidates[tagName] = append(candidates[tagName], fieldCandidate{field, depth, tagged})
 		}
 	}
+}
 
-	// Validate each spec field exists and type matches
-	for name, value := range spec.GetFields() {
-		field, ok := structFields[name]
-		if !ok {
-			return fmt.Errorf("ValidateStruct: field %q in spec not found in struct %s", name, t.Name())
+// dominantField picks the field a name refers to, following the encoding/json
+// rules: the shallowest depth wins, and of several at that depth a single
+// tagged field wins. It reports false if the name is ambiguous.
+func dominantField(list []fieldCandidate) (reflect.StructField, bool) {
+	depth := list[0].depth
+	for _, c := range list[1:] {
+		if c.depth < depth {
+			depth = c.depth
+		}
+	}
+	var shallow []fieldCandidate
+	for _, c := range list {
+		if c.depth == depth {
+			shallow = append(shallow, c)
 		}
+	}
+	if len(shallow) == 1 {
+		return shallow[0].field, true
+	}
+	var tagged []fieldCandidate
+	for _, c := range shallow {
+		if c.tagged {
+			tagged = append(tagged, c)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0].field, true
+	}
+	return reflect.StructField{}, false
+}
 
-		if err := validateFieldType(field, value); err != nil {
-			return fmt.Errorf("ValidateStruct: field %q: %w", name, err)
+// validateNestedValue descends into the Structs of value, given the Go type ft of the field.
+func (v *validator) validateNestedValue(ft reflect.Type, value *Value, path string) bool {
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+
+	switch k := value.GetKind().(type) {
+	case *Value_SingleStruct:
+		return v.validateNested(ft, k.SingleStruct, path)
+
+	case *Value_ListStruct:
+		for i, s := range k.ListStruct.GetListFields() {
+			if v.validateNested(ft.Elem(), s, fmt.Sprintf("%s[%d]", path, i)) {
+				return true
+			}
+		}
+
+	case *Value_MapStruct:
+		fields := k.MapStruct.GetMapFields()
+		for _, key := range sortedKeys(fields) {
+			if v.validateNested(ft.Elem(), fields[key], fmt.Sprintf("%s[%q]", path, key)) {
+				return true
+			}
+		}
+
+	case *Value_Map2Struct:
+		et := ft.Elem()
+		if isMap2Nested(ft) {
+			et = et.Elem()
+		}
+		fields := k.Map2Struct.GetMap2Fields()
+		for _, key1 := range sortedKeys(fields) {
+			inner := fields[key1].GetMapFields()
+			for _, key2 := range sortedKeys(inner) {
+				if v.validateNested(et, inner[key2], fmt.Sprintf("%s[%q][%q]", path, key1, key2)) {
+					return true
+				}
+			}
 		}
 	}
+	return false
+}
 
-	return nil
+// validateNested validates s against the concrete type behind the declared type et.
+// If et is an interface, the registered class of s must implement it.
+// A Struct with Choices is validated choice by choice, at path `Field(key)`.
+func (v *validator) validateNested(et reflect.Type, s *Struct, path string) bool {
+	if s == nil {
+		return false
+	}
+	if len(s.Choices) > 0 {
+		for _, key := range sortedKeys(s.Choices) {
+			if v.validateNested(et, s.Choices[key], fmt.Sprintf("%s(%s)", path, key)) {
+				return true
+			}
+		}
+		return false
+	}
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() == reflect.Struct {
+		return v.validateStruct(et, s, path)
+	}
+
+	ct, ok := v.reg.Lookup(s.ClassName)
+	if !ok {
+		if len(s.Fields) == 0 {
+			return false
+		}
+		return v.report(&FieldError{
+			Path:     path,
+			Code:     CodeClassNotRegistered,
+			Expected: s.ClassName,
+			Err:      fmt.Errorf("cannot validate nested fields: %w: %q", ErrClassNotRegistered, s.ClassName),
+		})
+	}
+	if et.Kind() == reflect.Interface && !implements(ct, et) {
+		return v.report(&FieldError{
+			Path:     path,
+			Code:     CodeNotImplemented,
+			Expected: et.String(),
+			Actual:   ct.String(),
+			Err:      fmt.Errorf("class %q (%v) does not implement %v", s.ClassName, ct, et),
+		})
+	}
+	if len(s.Fields) == 0 {
+		return false
+	}
+	if ct.Kind() != reflect.Struct {
+		return v.report(&FieldError{
+			Path:     path,
+			Code:     CodeKindMismatch,
+			Expected: reflect.Struct.String(),
+			Actual:   ct.Kind().String(),
+			Err:      fmt.Errorf("class %q is %v, not a struct", s.ClassName, ct),
+		})
+	}
+	return v.validateStruct(ct, s, path)
 }
 
 // validateFieldType checks that the Go field type is compatible with the spec Value type.
-func validateFieldType(field reflect.StructField, value *Value) error {
+// The returned FieldError has no Path; the caller sets it.
+func validateFieldType(field reflect.StructField, value *Value) *FieldError {
 	if value == nil {
 		return nil
 	}
@@ -72,31 +403,115 @@ func validateFieldType(field reflect.StructField, value *Value) error {
 		kind = field.Type.Elem().Kind()
 	}
 
+	mismatch := func(spec, expected string) *FieldError {
+		return &FieldError{
+			Code:     CodeKindMismatch,
+			Expected: expected,
+			Actual:   kind.String(),
+			Err:      fmt.Errorf("%s requires %s type, got %v", spec, expected, kind),
+		}
+	}
+
 	switch {
 	case value.GetMap2Struct() != nil:
 		// Map2Struct expects map with composite key (e.g., map[[2]string]T)
 		if kind != reflect.Map {
-			return fmt.Errorf("Map2Struct requires map type, got %v", kind)
+			return mismatch("Map2Struct", "map")
 		}
 
 	case value.GetMapStruct() != nil:
 		// MapStruct expects map[string]T or similar
 		if kind != reflect.Map {
-			return fmt.Errorf("MapStruct requires map type, got %v", kind)
+			return mismatch("MapStruct", "map")
 		}
 
 	case value.GetListStruct() != nil:
-		// ListStruct expects slice, array, or map (map is treated as ordered collection)
-		if kind != reflect.Slice && kind != reflect.Array && kind != reflect.Map {
-			return fmt.Errorf("ListStruct requires slice, array, or map type, got %v", kind)
+		// ListStruct expects slice or array; no decoder fills a map from a list
+		if kind != reflect.Slice && kind != reflect.Array {
+			return mismatch("ListStruct", "slice or array")
 		}
 
 	case value.GetSingleStruct() != nil:
 		// SingleStruct expects struct or pointer to struct or interface
 		if kind != reflect.Struct && kind != reflect.Ptr && kind != reflect.Interface {
-			return fmt.Errorf("SingleStruct requires struct, pointer, or interface type, got %v", kind)
+			return mismatch("SingleStruct", "struct, pointer, or interface")
 		}
 	}
 
 	return nil
 }
+
+// validateKeys checks the key type of a map field of type ft against a MapStruct
+// or Map2Struct value, and each explicit key of the value against that key type.
+// A key type implementing encoding.TextUnmarshaler must accept every key except
+// "*", which lets enum-like key types reject unknown keys. The Path of each
+// returned FieldError is relative to the field, e.g. `["key"]`.
+func validateKeys(ft reflect.Type, value *Value) []*FieldError {
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	if ft.Kind() != reflect.Map {
+		return nil
+	}
+
+	var errs []*FieldError
+	check := func(kt reflect.Type, key, path string) {
+		if err := validateKey(kt, key); err != nil {
+			errs = append(errs, &FieldError{
+				Path:     path,
+				Code:     CodeInvalidKey,
+				Expected: kt.String(),
+				Actual:   key,
+				Err:      fmt.Errorf("key %q is not a valid %v: %w", key, kt, err),
+			})
+		}
+	}
+
+	switch k := value.GetKind().(type) {
+	case *Value_MapStruct:
+		if !isStringKey(ft.Key()) {
+			return []*FieldError{{
+				Code:     CodeKeyType,
+				Expected: "string or encoding.TextUnmarshaler",
+				Actual:   ft.Key().String(),
+				Err:      fmt.Errorf("MapStruct requires a string or encoding.TextUnmarshaler key, got %v", ft.Key()),
+			}}
+		}
+		for _, key := range sortedKeys(k.MapStruct.GetMapFields()) {
+			check(ft.Key(), key, fmt.Sprintf("[%q]", key))
+		}
+
+	case *Value_Map2Struct:
+		var kt1, kt2 reflect.Type
+		switch {
+		case isStringPairKey(ft.Key()):
+			kt1, kt2 = ft.Key().Elem(), ft.Key().Elem()
+		case isMap2Nested(ft):
+			kt1, kt2 = ft.Key(), ft.Elem().Key()
+		default:
+			return []*FieldError{{
+				Code:     CodeKeyType,
+				Expected: "[2]string",
+				Actual:   ft.Key().String(),
+				Err:      fmt.Errorf("Map2Struct requires a [2]string key or map[string]map[string]T, got %v", ft),
+			}}
+		}
+		fields := k.Map2Struct.GetMap2Fields()
+		for _, key1 := range sortedKeys(fields) {
+			check(kt1, key1, fmt.Sprintf("[%q]", key1))
+			for _, key2 := range sortedKeys(fields[key1].GetMapFields()) {
+				check(kt2, key2, fmt.Sprintf("[%q][%q]", key1, key2))
+			}
+		}
+	}
+	return errs
+}
+
+// validateKey checks key against the map key type kt.
+func validateKey(kt reflect.Type, key string) error {
+	if key == "*" || !reflect.PointerTo(kt).Implements(textUnmarshalerType) {
+		return nil
+	}
+	_, err := mapKey(kt, key)
+	return err
+}
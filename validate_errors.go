@@ -0,0 +1,103 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ValidationCode classifies a validation failure.
+type ValidationCode string
+
+// Validation failure codes.
+const (
+	CodeFieldNotFound      ValidationCode = "field_not_found"
+	CodeKindMismatch       ValidationCode = "kind_mismatch"
+	CodeClassNotRegistered ValidationCode = "class_not_registered"
+	CodeNotImplemented     ValidationCode = "not_implemented"
+	CodeKeyType            ValidationCode = "key_type"
+	CodeInvalidKey         ValidationCode = "invalid_key"
+)
+
+// Sentinel errors matched by errors.Is against a *FieldError of the same code.
+var (
+	ErrFieldNotFound  = errors.New("field not found")
+	ErrKindMismatch   = errors.New("kind mismatch")
+	ErrNotImplemented = errors.New("interface not implemented")
+	ErrKeyType        = errors.New("unsupported map key type")
+	ErrInvalidKey     = errors.New("invalid map key")
+)
+
+var codeErrors = map[ValidationCode]error{
+	CodeFieldNotFound:      ErrFieldNotFound,
+	CodeKindMismatch:       ErrKindMismatch,
+	CodeClassNotRegistered: ErrClassNotRegistered,
+	CodeNotImplemented:     ErrNotImplemented,
+	CodeKeyType:            ErrKeyType,
+	CodeInvalidKey:         ErrInvalidKey,
+}
+
+// FieldError describes one field of a spec that does not fit its Go struct.
+//
+//	╔════════════════════════╤══════════════════════════╤════════════════════════╗
+//	║ Code                   │ Expected                 │ Actual                 ║
+//	╠════════════════════════╪══════════════════════════╪════════════════════════╣
+//	║ CodeFieldNotFound      │ (empty)                  │ Go struct searched     ║
+//	║ CodeKindMismatch       │ Go kinds the Value needs │ Go kind of the field   ║
+//	║ CodeClassNotRegistered │ class name               │ (empty)                ║
+//	║ CodeNotImplemented     │ interface type           │ Go type of the class   ║
+//	║ CodeKeyType            │ key type the Value needs │ Go key type of the map ║
+//	║ CodeInvalidKey         │ Go key type              │ key in the spec        ║
+//	╚════════════════════════╧══════════════════════════╧════════════════════════╝
+type FieldError struct {
+	// Path locates the field, e.g. `Config.Servers[1].Handler`.
+	Path     string
+	Code     ValidationCode
+	Expected string
+	Actual   string
+	// Err is the underlying cause, if any.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	if e.Code == CodeFieldNotFound {
+		return fmt.Sprintf("field %q in spec not found in struct %s", e.Path, e.Actual)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("field %q: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("field %q: %s: expected %s, got %s", e.Path, e.Code, e.Expected, e.Actual)
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// Is reports whether target is the sentinel error of e.Code.
+func (e *FieldError) Is(target error) bool {
+	sentinel, ok := codeErrors[e.Code]
+	return ok && target == sentinel
+}
+
+// ValidationErrors is the list of failures returned by ValidateStructAll, sorted by path.
+type ValidationErrors []*FieldError
+
+func (es ValidationErrors) Error() string {
+	if len(es) == 1 {
+		return "ValidateStruct: " + es[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ValidateStruct: %d problems:", len(es))
+	for _, e := range es {
+		b.WriteString("\n\t")
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the individual failures for errors.Is and errors.As.
+func (es ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(es))
+	for i, e := range es {
+		errs[i] = e
+	}
+	return errs
+}
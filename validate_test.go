@@ -1,6 +1,8 @@
 package schema
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -215,8 +217,7 @@ func TestValidateStruct_Map2Struct_Invalid(t *testing.T) {
 	}
 }
 
-func TestValidateStruct_ListStructOnMap_Valid(t *testing.T) {
-	// ListStruct on map is valid (map treated as ordered collection)
+func TestValidateStruct_ListStructOnMap_Invalid(t *testing.T) {
 	obj := &validStruct{}
 	spec := &Struct{
 		ClassName: "validStruct",
@@ -227,8 +228,12 @@ func TestValidateStruct_ListStructOnMap_Valid(t *testing.T) {
 		},
 	}
 	err := ValidateStruct(obj, spec)
-	if err != nil {
-		t.Errorf("ValidateStruct should pass for ListStruct on map field, got %v", err)
+	var fe *FieldError
+	if !errors.As(err, &fe) {
+		t.Fatalf("ValidateStruct should fail for ListStruct on map field, got %v", err)
+	}
+	if fe.Code != CodeKindMismatch || fe.Expected != "slice or array" || fe.Actual != "map" {
+		t.Errorf("got %v %q %q", fe.Code, fe.Expected, fe.Actual)
 	}
 }
 
@@ -266,3 +271,459 @@ func TestValidateStruct_PointerObject(t *testing.T) {
 		t.Errorf("ValidateStruct should pass for value object, got %v", err)
 	}
 }
+
+type valConnection interface {
+	Dial() error
+}
+
+type valTCP struct {
+	Host string
+}
+
+func (c *valTCP) Dial() error { return nil }
+
+type valDatabase interface {
+	Open() error
+}
+
+type valPostgres struct {
+	Connection valConnection
+	Replicas   []valConnection
+	Pools      map[string]valConnection
+	Grid       map[[2]string]valConnection
+	Mesh       map[string]map[string]valConnection
+}
+
+func (p *valPostgres) Open() error { return nil }
+
+type valConfig struct {
+	Database valDatabase
+	Backups  []valDatabase
+	Local    *valPostgres
+}
+
+func newValRegistry(t *testing.T) *Registry {
+	t.Helper()
+	reg := NewRegistry()
+	if err := RegisterTo[valPostgres](reg, "Postgres"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTo[valTCP](reg, "TCP"); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestValidateStructWithOptions_Recursive_Valid(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"Database": [2]any{"Postgres", map[string]any{
+			"Connection": "TCP",
+			"Replicas":   []string{"TCP", "TCP"},
+			"Pools":      map[string]string{"*": "TCP"},
+			"Grid":       map[[2]string]string{{"a", "b"}: "TCP"},
+		}},
+		"Backups": [][2]any{{"Postgres", map[string]any{"Connection": "TCP"}}},
+		"Local":   [2]any{"Postgres", map[string]any{"Connection": "TCP"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateStructWithOptions(&valConfig{}, spec, &ValidateOptions{Registry: newValRegistry(t)}); err != nil {
+		t.Errorf("expected valid spec, got %v", err)
+	}
+}
+
+func TestValidateStructWithOptions_Recursive_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec map[string]any
+		want string
+	}{
+		{
+			name: "misspelled nested field",
+			spec: map[string]any{"Database": [2]any{"Postgres", map[string]any{"Conection": "TCP"}}},
+			want: `field "Config.Database.Conection" in spec not found in struct valPostgres`,
+		},
+		{
+			name: "three levels down",
+			spec: map[string]any{"Database": [2]any{"Postgres", map[string]any{
+				"Connection": [2]any{"TCP", map[string]any{"Hots": "X"}},
+			}}},
+			want: `field "Config.Database.Connection.Hots" in spec not found in struct valTCP`,
+		},
+		{
+			name: "list index",
+			spec: map[string]any{"Backups": [][2]any{
+				{"Postgres", map[string]any{"Connection": "TCP"}},
+				{"Postgres", map[string]any{"Replicas": "TCP"}},
+			}},
+			want: `field "Config.Backups[1].Replicas": SingleStruct requires`,
+		},
+		{
+			name: "map2 keys",
+			spec: map[string]any{"Database": [2]any{"Postgres", map[string]any{
+				"Grid": map[[2]string][2]any{{"a", "b"}: {"TCP", map[string]any{"Port": "X"}}},
+			}}},
+			want: `field "Config.Database.Grid[\"a\"][\"b\"].Port" in spec not found`,
+		},
+		{
+			name: "concrete pointer field",
+			spec: map[string]any{"Local": [2]any{"Anything", map[string]any{"Missing": "TCP"}}},
+			want: `field "Config.Local.Missing" in spec not found in struct valPostgres`,
+		},
+		{
+			name: "unregistered class with fields",
+			spec: map[string]any{"Database": [2]any{"MySQL", map[string]any{"Connection": "TCP"}}},
+			want: `field "Config.Database": cannot validate nested fields: class not registered: "MySQL"`,
+		},
+	}
+
+	reg := newValRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := NewStruct("Config", tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ValidateStructWithOptions(&valConfig{}, spec, &ValidateOptions{Registry: reg})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q should contain %q", err, tt.want)
+			}
+			if err := ValidateStruct(&valConfig{}, spec); err != nil {
+				t.Errorf("ValidateStruct without registry should only check the top level, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateStructWithOptions_Cycle(t *testing.T) {
+	type node struct {
+		Next *node
+		Conn valConnection
+	}
+	spec := &Struct{ClassName: "node", Fields: map[string]*Value{
+		"Conn": {Kind: &Value_SingleStruct{SingleStruct: &Struct{ClassName: "TCP"}}},
+	}}
+	spec.Fields["Next"] = &Value{Kind: &Value_SingleStruct{SingleStruct: spec}}
+	if err := ValidateStructWithOptions(&node{}, spec, &ValidateOptions{Registry: newValRegistry(t)}); err != nil {
+		t.Errorf("expected cyclic spec to validate, got %v", err)
+	}
+}
+
+func TestValidateStructAll(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"Database": [2]any{"Postgres", map[string]any{
+			"Conection": "TCP",
+			"Replicas":  "TCP",
+		}},
+		"Backups": [][2]any{{"MySQL", map[string]any{"Connection": "TCP"}}},
+		"Zones":   "TCP",
+		"Local":   []string{"Postgres"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		err = ValidateStructAll(&valConfig{}, spec, &ValidateOptions{Registry: newValRegistry(t)})
+		var verrs ValidationErrors
+		if !errors.As(err, &verrs) {
+			t.Fatalf("expected ValidationErrors, got %T: %v", err, err)
+		}
+
+		want := []struct {
+			path     string
+			code     ValidationCode
+			expected string
+			actual   string
+		}{
+			{"Config.Backups[0]", CodeClassNotRegistered, "MySQL", ""},
+			{"Config.Database.Conection", CodeFieldNotFound, "", "valPostgres"},
+			{"Config.Database.Replicas", CodeKindMismatch, "struct, pointer, or interface", "slice"},
+			{"Config.Local", CodeKindMismatch, "slice or array", "struct"},
+			{"Config.Zones", CodeFieldNotFound, "", "valConfig"},
+		}
+		if len(verrs) != len(want) {
+			t.Fatalf("got %d errors, want %d:\n%v", len(verrs), len(want), err)
+		}
+		for j, w := range want {
+			fe := verrs[j]
+			if fe.Path != w.path || fe.Code != w.code || fe.Expected != w.expected || fe.Actual != w.actual {
+				t.Errorf("error %d = %+v, want %+v", j, fe, w)
+			}
+		}
+	}
+
+	if !errors.Is(err, ErrFieldNotFound) || !errors.Is(err, ErrKindMismatch) || !errors.Is(err, ErrClassNotRegistered) {
+		t.Errorf("errors.Is should match every code in %v", err)
+	}
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Path != "Config.Backups[0]" {
+		t.Errorf("errors.As should find the first FieldError, got %v", fe)
+	}
+	if !strings.HasPrefix(err.Error(), "ValidateStruct: 5 problems:\n\tfield \"Config.Backups[0]\"") {
+		t.Errorf("unexpected message:\n%v", err)
+	}
+
+	first := ValidateStructWithOptions(&valConfig{}, spec, &ValidateOptions{Registry: newValRegistry(t)})
+	if !errors.As(first, &fe) || fe.Path != "Config.Backups[0]" {
+		t.Errorf("ValidateStructWithOptions should return the first error, got %v", first)
+	}
+}
+
+func TestValidateStructAll_Valid(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{"Database": "Postgres"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateStructAll(&valConfig{}, spec, nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	if err := ValidateStructAll(42, spec, nil); err == nil || errors.As(err, new(ValidationErrors)) {
+		t.Errorf("expected a plain error for a non-struct, got %v", err)
+	}
+}
+
+type valUDP struct{}
+
+func (valUDP) Dial() error { return nil }
+
+func TestValidateStructWithOptions_Implements(t *testing.T) {
+	reg := newValRegistry(t)
+	if err := RegisterTo[valUDP](reg, "UDP"); err != nil {
+		t.Fatal(err)
+	}
+
+	valid, err := NewStruct("Config", map[string]any{
+		"Database": [2]any{"Postgres", map[string]any{
+			"Connection": "UDP",
+			"Replicas":   []string{"TCP", "UDP"},
+			"Pools":      map[string]string{"*": "UDP"},
+			"Grid":       map[[2]string]string{{"a", "b"}: "TCP"},
+			"Mesh":       map[[2]string]string{{"a", "b"}: "UDP"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateStructAll(&valConfig{}, valid, &ValidateOptions{Registry: reg}); err != nil {
+		t.Errorf("expected pointer and value receivers to satisfy the interface, got %v", err)
+	}
+
+	invalid, err := NewStruct("Config", map[string]any{
+		"Database": "TCP",
+		"Backups":  []string{"Postgres", "UDP"},
+		"Local": [2]any{"Postgres", map[string]any{
+			"Connection": "Postgres",
+			"Pools":      map[string]string{"main": "Postgres"},
+			"Grid":       map[[2]string]string{{"a", "b"}: "Postgres"},
+			"Mesh":       map[[2]string]string{{"a", "b"}: "Postgres"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateStructAll(&valConfig{}, invalid, &ValidateOptions{Registry: reg})
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	want := []string{
+		"Config.Backups[1]",
+		"Config.Database",
+		"Config.Local.Connection",
+		`Config.Local.Grid["a"]["b"]`,
+		`Config.Local.Mesh["a"]["b"]`,
+		`Config.Local.Pools["main"]`,
+	}
+	if len(verrs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(verrs), len(want), err)
+	}
+	for i, path := range want {
+		if verrs[i].Path != path || verrs[i].Code != CodeNotImplemented {
+			t.Errorf("error %d = %v, want %s at %s", i, verrs[i], CodeNotImplemented, path)
+		}
+	}
+	if !errors.Is(err, ErrNotImplemented) {
+		t.Error("errors.Is(err, ErrNotImplemented) should be true")
+	}
+	if !strings.Contains(verrs[1].Error(), `class "TCP" (schema.valTCP) does not implement schema.valDatabase`) {
+		t.Errorf("unexpected message: %v", verrs[1])
+	}
+}
+
+func TestValidateStructAll_Choices(t *testing.T) {
+	reg := newValRegistry(t)
+	database := &Struct{
+		Discriminator: "engine",
+		Choices: map[string]*Struct{
+			"pg":  {ClassName: "Postgres"},
+			"tcp": {ClassName: "TCP"},
+			"sql": {ClassName: "MySQL", Fields: map[string]*Value{"Pool": {}}},
+		},
+	}
+	spec := &Struct{ClassName: "Config", Fields: map[string]*Value{
+		"Backups": {Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{database}}}},
+	}}
+	err := ValidateStructAll(&valConfig{}, spec, &ValidateOptions{Registry: reg})
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) || len(verrs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if verrs[0].Path != "Config.Backups[0](sql)" || verrs[0].Code != CodeClassNotRegistered {
+		t.Errorf("error 0 = %v", verrs[0])
+	}
+	if verrs[1].Path != "Config.Backups[0](tcp)" || verrs[1].Code != CodeNotImplemented {
+		t.Errorf("error 1 = %v", verrs[1])
+	}
+}
+
+type valBase struct {
+	ID      string
+	Service valConnection `json:"service"`
+}
+
+type valMeta struct {
+	ID string
+}
+
+type valWire struct {
+	valBase
+	*valMeta
+	Database valDatabase            `json:"database,omitempty" hcl:"db,block"`
+	Pools    map[string]valDatabase `json:"pools"`
+	Skipped  valDatabase            `json:"-"`
+}
+
+func TestValidateStructWithOptions_NameTagAndEmbedded(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   *ValidateOptions
+		fields map[string]any
+		want   string
+	}{
+		{"go names", &ValidateOptions{NameTag: "json"}, map[string]any{"Database": "Postgres", "Pools": map[string]string{"*": "Postgres"}}, ""},
+		{"json names", &ValidateOptions{NameTag: "json"}, map[string]any{"database": "Postgres", "pools": map[string]string{"*": "Postgres"}}, ""},
+		{"hcl names", &ValidateOptions{NameTag: "hcl"}, map[string]any{"db": "Postgres"}, ""},
+		{"json name without option", nil, map[string]any{"database": "Postgres"}, `field "valWire.database" in spec not found`},
+		{"dash is not a name", &ValidateOptions{NameTag: "json"}, map[string]any{"-": "Postgres"}, `field "valWire.-" in spec not found`},
+		{"wire type mismatch", &ValidateOptions{NameTag: "json"}, map[string]any{"pools": "Postgres"}, `field "valWire.pools": SingleStruct requires`},
+		{"promoted", &ValidateOptions{Embedded: true}, map[string]any{"Service": "TCP"}, ""},
+		{"promoted by tag", &ValidateOptions{Embedded: true, NameTag: "json"}, map[string]any{"service": "TCP"}, ""},
+		{"not promoted", nil, map[string]any{"Service": "TCP"}, `field "valWire.Service" in spec not found`},
+		{"ambiguous", &ValidateOptions{Embedded: true}, map[string]any{"ID": "TCP"}, `field "valWire.ID" in spec not found`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := NewStruct("valWire", tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ValidateStructWithOptions(&valWire{}, spec, tt.opts)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("expected valid spec, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v should contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateStructWithOptions_EmbeddedNested(t *testing.T) {
+	type inner struct {
+		Conn []valConnection `json:"conn"`
+	}
+	type outer struct {
+		inner
+		Conn valConnection `json:"conn"`
+	}
+	spec, err := NewStruct("outer", map[string]any{"conn": "TCP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &ValidateOptions{Registry: newValRegistry(t), NameTag: "json", Embedded: true}
+	if err := ValidateStructWithOptions(&outer{}, spec, opts); err != nil {
+		t.Errorf("shallow field should shadow the embedded one, got %v", err)
+	}
+}
+
+type valColor string
+
+func (c *valColor) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "red", "green":
+		*c = valColor(b)
+		return nil
+	}
+	return fmt.Errorf("unknown color %q", b)
+}
+
+type valKeyed struct {
+	ByColor map[valColor]valConnection
+	ByInt   map[int]valConnection
+	Flat    map[string]valConnection
+	Grid    map[[2]valColor]valConnection
+	Nested  map[valColor]map[string]valConnection
+}
+
+func TestValidateStructAll_Keys(t *testing.T) {
+	valid, err := NewStruct("valKeyed", map[string]any{
+		"ByColor": map[string]string{"red": "TCP", "*": "TCP"},
+		"Flat":    map[string]string{"anything": "TCP"},
+		"Grid":    map[[2]string]string{{"red", "green"}: "TCP", {"*", "*"}: "TCP"},
+		"Nested":  map[[2]string]string{{"green", "any"}: "TCP"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateStructAll(&valKeyed{}, valid, nil); err != nil {
+		t.Errorf("expected valid keys, got %v", err)
+	}
+
+	invalid, err := NewStruct("valKeyed", map[string]any{
+		"ByColor": map[string]string{"red": "TCP", "blue": "TCP"},
+		"ByInt":   map[string]string{"1": "TCP"},
+		"Flat":    map[[2]string]string{{"a", "b"}: "TCP"},
+		"Grid":    map[[2]string]string{{"red", "blue"}: "TCP"},
+		"Nested":  map[[2]string]string{{"pink", "any"}: "TCP"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateStructAll(&valKeyed{}, invalid, nil)
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	want := []struct {
+		path string
+		code ValidationCode
+	}{
+		{`valKeyed.ByColor["blue"]`, CodeInvalidKey},
+		{"valKeyed.ByInt", CodeKeyType},
+		{"valKeyed.Flat", CodeKeyType},
+		{`valKeyed.Grid["red"]["blue"]`, CodeInvalidKey},
+		{`valKeyed.Nested["pink"]`, CodeInvalidKey},
+	}
+	if len(verrs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(verrs), len(want), err)
+	}
+	for i, w := range want {
+		if verrs[i].Path != w.path || verrs[i].Code != w.code {
+			t.Errorf("error %d = %v, want %s at %s", i, verrs[i], w.code, w.path)
+		}
+	}
+	if !errors.Is(err, ErrKeyType) || !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("errors.Is should match the key codes in %v", err)
+	}
+	if !strings.Contains(verrs[0].Error(), `key "blue" is not a valid schema.valColor: unknown color "blue"`) {
+		t.Errorf("unexpected message: %v", verrs[0])
+	}
+}
@@ -0,0 +1,510 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLServiceStruct creates a Struct from a spec document in YAML, written with
+// the keywords of the JSON dialect read by JSMServiceStruct: className,
+// serviceName, properties, items, prefixItems, additionalProperties, x-map,
+// x-map2, $ref and definitions. As in UnmarshalJSON, oneOf and anyOf are read
+// as Choices.
+//
+// A node with an anchor is one schema wherever it is aliased, so the Structs of
+// its aliases are the same *Struct:
+//
+//	className: Canvas
+//	properties:
+//	  background: &shape {className: Shape}
+//	  foreground: *shape
+//	// Fields["background"] and Fields["foreground"] hold the same *Struct
+//
+// Errors in the document give its line and column.
+func YAMLServiceStruct(className, yamlStr string) (*Struct, error) {
+	if className == "" {
+		return nil, fmt.Errorf("className cannot be empty")
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(yamlStr), &node); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	s := &Struct{}
+	if err := s.UnmarshalYAML(&node); err != nil {
+		return nil, err
+	}
+	s.ClassName = className
+	return s, nil
+}
+
+// YAMLStruct creates a Struct from a spec document in YAML like
+// YAMLServiceStruct, stripping all service names from the result.
+func YAMLStruct(className, yamlStr string) (*Struct, error) {
+	s, err := YAMLServiceStruct(className, yamlStr)
+	if err != nil {
+		return nil, err
+	}
+	return DeriveStructWithoutServices(s), nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface. The spec is written with
+// the keywords of MarshalJSON; a Struct reached more than once is written once
+// with an anchor and aliased afterwards, unless it is part of a cycle, which
+// YAML aliases cannot express: such Structs go under definitions as in JSON.
+func (s *Struct) MarshalYAML() (any, error) {
+	js, err := convertStructToSchema(s)
+	if err != nil || js == nil {
+		return nil, err
+	}
+	return newYAMLEncoder(js).node(js), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for the documents
+// written by MarshalYAML and read by YAMLServiceStruct.
+func (s *Struct) UnmarshalYAML(node *yaml.Node) error {
+	d := newYAMLDecoder()
+	js, err := d.document(node)
+	if err != nil {
+		return err
+	}
+	c := newSchemaConverter(js, true)
+	val, err := c.toValue(js)
+	if err != nil {
+		return d.errorAt(c.failed, err)
+	}
+	s.setSchema(js, val)
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface. The Value is written as
+// the schema of a field holding it, e.g. {items: {className: Circle}} for a
+// ListStruct, with shared Structs as in Struct.MarshalYAML.
+func (v *Value) MarshalYAML() (any, error) {
+	js, err := convertValueToSchema(v)
+	if err != nil || js == nil {
+		return nil, err
+	}
+	return newYAMLEncoder(js).node(js), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for the documents
+// written by Value.MarshalYAML.
+func (v *Value) UnmarshalYAML(node *yaml.Node) error {
+	d := newYAMLDecoder()
+	js, err := d.document(node)
+	if err != nil {
+		return err
+	}
+	c := newSchemaConverter(js, true)
+	val, err := c.toValue(js)
+	if err != nil {
+		return d.errorAt(c.failed, err)
+	}
+	if val == nil {
+		return d.errorAt(js, fmt.Errorf("schema describes no class"))
+	}
+	v.Kind = val.Kind
+	return nil
+}
+
+// yamlDecoder reads YAML nodes into jsonSchema, keeping the node of every
+// schema for the position of errors.
+type yamlDecoder struct {
+	// schemas holds the schema of every mapping node, so that the aliases of
+	// an anchor share one *jsonSchema, and thus one *Struct.
+	schemas map[*yaml.Node]*jsonSchema
+	nodes   map[*jsonSchema]*yaml.Node
+}
+
+func newYAMLDecoder() *yamlDecoder {
+	return &yamlDecoder{
+		schemas: make(map[*yaml.Node]*jsonSchema),
+		nodes:   make(map[*jsonSchema]*yaml.Node),
+	}
+}
+
+// document returns the schema of a document node or of the node itself.
+func (d *yamlDecoder) document(node *yaml.Node) (*jsonSchema, error) {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil, fmt.Errorf("yaml: empty document")
+		}
+		node = node.Content[0]
+	}
+	return d.schema(node)
+}
+
+// errorAt prefixes err with the position of the node js was read from.
+func (d *yamlDecoder) errorAt(js *jsonSchema, err error) error {
+	if n, ok := d.nodes[js]; ok {
+		return fmt.Errorf("yaml: line %d, column %d: %w", n.Line, n.Column, err)
+	}
+	return err
+}
+
+func (d *yamlDecoder) errorf(n *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("yaml: line %d, column %d: %s", n.Line, n.Column, fmt.Sprintf(format, args...))
+}
+
+// schema reads a schema: a mapping of keywords, or the boolean schema true or false.
+func (d *yamlDecoder) schema(n *yaml.Node) (*jsonSchema, error) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if js, ok := d.schemas[n]; ok {
+		return js, nil
+	}
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!bool" {
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, d.errorf(n, "%v", err)
+		}
+		js := &jsonSchema{never: !b}
+		d.nodes[js] = n
+		return js, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil, d.errorf(n, "schema must be a mapping or a boolean")
+	}
+
+	js := &jsonSchema{}
+	d.schemas[n] = js
+	d.nodes[js] = n
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if val.Kind == yaml.AliasNode {
+			val = val.Alias
+		}
+		if key.Kind != yaml.ScalarNode {
+			return nil, d.errorf(key, "keyword must be a string")
+		}
+		var err error
+		switch key.Value {
+		case "<<":
+			return nil, d.errorf(key, "merge keys are not supported")
+		case "className":
+			js.ClassName, err = d.str(key.Value, val)
+		case "serviceName":
+			js.ServiceName, err = d.str(key.Value, val)
+		case "x-service-name":
+			js.XServiceName, err = d.str(key.Value, val)
+		case "title":
+			js.Title, err = d.str(key.Value, val)
+		case "$ref":
+			js.Ref, err = d.str(key.Value, val)
+		case "x-map":
+			js.XMap, err = d.bool(key.Value, val)
+		case "x-map2":
+			js.XMap2, err = d.bool(key.Value, val)
+		case "properties":
+			js.Properties, err = d.schemaMap(key.Value, val)
+		case "definitions":
+			js.Definitions, err = d.schemaMap(key.Value, val)
+		case "$defs":
+			js.Defs, err = d.schemaMap(key.Value, val)
+		case "items":
+			js.Items, err = d.schema(val)
+		case "additionalProperties":
+			js.AdditionalProperties, err = d.schema(val)
+		case "prefixItems":
+			js.PrefixItems, err = d.schemaList(key.Value, val)
+		case "oneOf":
+			js.OneOf, err = d.schemaList(key.Value, val)
+		case "anyOf":
+			js.AnyOf, err = d.schemaList(key.Value, val)
+		case "discriminator":
+			js.Discriminator, err = d.discriminator(val)
+		case "const":
+			js.Const, err = d.raw(key.Value, val)
+		case "type":
+			js.Type, err = d.raw(key.Value, val)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return js, nil
+}
+
+func (d *yamlDecoder) str(keyword string, n *yaml.Node) (string, error) {
+	if n.Kind != yaml.ScalarNode {
+		return "", d.errorf(n, "%s must be a string", keyword)
+	}
+	return n.Value, nil
+}
+
+func (d *yamlDecoder) bool(keyword string, n *yaml.Node) (bool, error) {
+	var b bool
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!bool" || n.Decode(&b) != nil {
+		return false, d.errorf(n, "%s must be a boolean", keyword)
+	}
+	return b, nil
+}
+
+func (d *yamlDecoder) schemaMap(keyword string, n *yaml.Node) (map[string]*jsonSchema, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, d.errorf(n, "%s must be a mapping", keyword)
+	}
+	m := make(map[string]*jsonSchema, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if key.Kind != yaml.ScalarNode {
+			return nil, d.errorf(key, "%s key must be a string", keyword)
+		}
+		js, err := d.schema(n.Content[i+1])
+		if err != nil {
+			return nil, err
+		}
+		m[key.Value] = js
+	}
+	return m, nil
+}
+
+func (d *yamlDecoder) schemaList(keyword string, n *yaml.Node) ([]*jsonSchema, error) {
+	if n.Kind != yaml.SequenceNode {
+		return nil, d.errorf(n, "%s must be a sequence", keyword)
+	}
+	list := make([]*jsonSchema, len(n.Content))
+	for i, item := range n.Content {
+		js, err := d.schema(item)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = js
+	}
+	return list, nil
+}
+
+func (d *yamlDecoder) discriminator(n *yaml.Node) (*jsonDiscriminator, error) {
+	if n.Kind != yaml.MappingNode {
+		return nil, d.errorf(n, "discriminator must be a mapping")
+	}
+	disc := &jsonDiscriminator{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		switch key.Value {
+		case "propertyName":
+			name, err := d.str("discriminator.propertyName", val)
+			if err != nil {
+				return nil, err
+			}
+			disc.PropertyName = name
+		case "mapping":
+			if val.Kind != yaml.MappingNode {
+				return nil, d.errorf(val, "discriminator.mapping must be a mapping")
+			}
+			disc.Mapping = make(map[string]string, len(val.Content)/2)
+			for j := 0; j+1 < len(val.Content); j += 2 {
+				ref, err := d.str("discriminator.mapping value", val.Content[j+1])
+				if err != nil {
+					return nil, err
+				}
+				disc.Mapping[val.Content[j].Value] = ref
+			}
+		}
+	}
+	return disc, nil
+}
+
+// raw returns the JSON encoding of a keyword kept as data, such as const.
+func (d *yamlDecoder) raw(keyword string, n *yaml.Node) (json.RawMessage, error) {
+	var x any
+	if err := n.Decode(&x); err != nil {
+		return nil, d.errorf(n, "%s: %v", keyword, err)
+	}
+	data, err := json.Marshal(x)
+	if err != nil {
+		return nil, d.errorf(n, "%s: %v", keyword, err)
+	}
+	return data, nil
+}
+
+// yamlEncoder writes a jsonSchema document as YAML nodes, turning the $ref of
+// every definition outside a cycle into an anchor and its aliases.
+type yamlEncoder struct {
+	definitions map[string]*jsonSchema
+	// cyclic holds the definitions that reach themselves, which stay definitions.
+	cyclic  map[string]bool
+	anchors map[string]*yaml.Node
+	used    map[string]bool
+}
+
+func newYAMLEncoder(root *jsonSchema) *yamlEncoder {
+	e := &yamlEncoder{
+		definitions: root.Definitions,
+		cyclic:      make(map[string]bool),
+		anchors:     make(map[string]*yaml.Node),
+		used:        make(map[string]bool),
+	}
+	for name, def := range e.definitions {
+		e.cyclic[name] = e.reaches(def, name, make(map[string]bool))
+	}
+	return e
+}
+
+// definition returns the name of the definition ref points to, or "".
+func (e *yamlEncoder) definition(ref string) string {
+	if !strings.HasPrefix(ref, "#/definitions/") {
+		return ""
+	}
+	name := refName(ref)
+	if _, ok := e.definitions[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// reaches reports whether js refers to the definition target, directly or
+// through other definitions.
+func (e *yamlEncoder) reaches(js *jsonSchema, target string, seen map[string]bool) bool {
+	if js == nil {
+		return false
+	}
+	if name := e.definition(js.Ref); name != "" {
+		if name == target {
+			return true
+		}
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+		return e.reaches(e.definitions[name], target, seen)
+	}
+	for _, child := range js.Properties {
+		if e.reaches(child, target, seen) {
+			return true
+		}
+	}
+	for _, list := range [][]*jsonSchema{js.PrefixItems, js.OneOf, js.AnyOf} {
+		for _, child := range list {
+			if e.reaches(child, target, seen) {
+				return true
+			}
+		}
+	}
+	return e.reaches(js.Items, target, seen) || e.reaches(js.AdditionalProperties, target, seen)
+}
+
+// anchorName returns a YAML anchor for the definition name, which may only
+// hold letters, digits, '_' and '-'.
+func (e *yamlEncoder) anchorName(name string) string {
+	base := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' {
+			return r
+		}
+		return '_'
+	}, name)
+	anchor := base
+	for i := 2; e.used[anchor]; i++ {
+		anchor = fmt.Sprintf("%s_%d", base, i)
+	}
+	e.used[anchor] = true
+	return anchor
+}
+
+// node returns the YAML node of js. Nodes are built in document order, so the
+// first use of a shared Struct carries the anchor and later uses are aliases.
+func (e *yamlEncoder) node(js *jsonSchema) *yaml.Node {
+	if name := e.definition(js.Ref); name != "" && !e.cyclic[name] {
+		if a, ok := e.anchors[name]; ok {
+			return &yaml.Node{Kind: yaml.AliasNode, Alias: a, Value: a.Anchor}
+		}
+		n := e.node(e.definitions[name])
+		n.Anchor = e.anchorName(name)
+		e.anchors[name] = n
+		return n
+	}
+
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, val *yaml.Node) {
+		n.Content = append(n.Content, yamlString(key), val)
+	}
+	if js.ClassName != "" {
+		add("className", yamlString(js.ClassName))
+	}
+	if js.ServiceName != "" {
+		add("serviceName", yamlString(js.ServiceName))
+	}
+	if js.XMap {
+		add("x-map", yamlBool(true))
+	}
+	if js.XMap2 {
+		add("x-map2", yamlBool(true))
+	}
+	if d := js.Discriminator; d != nil {
+		dn := &yaml.Node{Kind: yaml.MappingNode}
+		if d.PropertyName != "" {
+			dn.Content = append(dn.Content, yamlString("propertyName"), yamlString(d.PropertyName))
+		}
+		if len(d.Mapping) > 0 {
+			mn := &yaml.Node{Kind: yaml.MappingNode}
+			for _, key := range sortedKeys(d.Mapping) {
+				mn.Content = append(mn.Content, yamlString(key), yamlString(d.Mapping[key]))
+			}
+			dn.Content = append(dn.Content, yamlString("mapping"), mn)
+		}
+		add("discriminator", dn)
+	}
+	if len(js.Const) > 0 {
+		var x any
+		cn := &yaml.Node{}
+		if json.Unmarshal(js.Const, &x) == nil && cn.Encode(x) == nil {
+			add("const", cn)
+		}
+	}
+	if len(js.Properties) > 0 {
+		add("properties", e.mapping(js.Properties, sortedKeys(js.Properties)))
+	}
+	if js.Items != nil {
+		add("items", e.node(js.Items))
+	}
+	if js.PrefixItems != nil {
+		add("prefixItems", e.sequence(js.PrefixItems))
+	}
+	if js.AdditionalProperties != nil {
+		add("additionalProperties", e.node(js.AdditionalProperties))
+	}
+	if len(js.OneOf) > 0 {
+		add("oneOf", e.sequence(js.OneOf))
+	}
+	if js.Ref != "" {
+		add("$ref", yamlString(js.Ref))
+	}
+	var cyclic []string
+	for _, name := range sortedKeys(js.Definitions) {
+		if e.cyclic[name] {
+			cyclic = append(cyclic, name)
+		}
+	}
+	if len(cyclic) > 0 {
+		add("definitions", e.mapping(js.Definitions, cyclic))
+	}
+	return n
+}
+
+func (e *yamlEncoder) mapping(schemas map[string]*jsonSchema, keys []string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		n.Content = append(n.Content, yamlString(key), e.node(schemas[key]))
+	}
+	return n
+}
+
+func (e *yamlEncoder) sequence(list []*jsonSchema) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, js := range list {
+		n.Content = append(n.Content, e.node(js))
+	}
+	return n
+}
+
+func yamlString(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
+
+func yamlBool(b bool) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(b)}
+}
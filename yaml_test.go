@@ -0,0 +1,220 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+func TestYAMLServiceStruct(t *testing.T) {
+	spec, err := YAMLServiceStruct("Config", `
+properties:
+  Primary: &server
+    className: HTTPServer
+    serviceName: httpService
+    properties:
+      Handler: {className: Handler}
+  Backup: *server
+  Pipeline:
+    prefixItems:
+      - {className: Reader}
+      - *server
+  Routes:
+    x-map: true
+    properties:
+      api: {className: APIHandler}
+    additionalProperties: {className: WebHandler}
+  Grid:
+    x-map2: true
+    properties:
+      r1:
+        properties:
+          k1: {className: Cell}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := spec.Fields["Primary"].GetSingleStruct()
+	if primary.GetClassName() != "HTTPServer" || primary.GetServiceName() != "httpService" {
+		t.Fatalf("Primary = %v", primary)
+	}
+	if spec.Fields["Backup"].GetSingleStruct() != primary {
+		t.Error("an alias should share the *Struct of its anchor")
+	}
+	pipeline := spec.Fields["Pipeline"].GetListStruct().GetListFields()
+	if len(pipeline) != 2 || pipeline[0].ClassName != "Reader" || pipeline[1] != primary {
+		t.Errorf("Pipeline = %v", pipeline)
+	}
+	routes := spec.Fields["Routes"].GetMapStruct().GetMapFields()
+	if routes["api"].GetClassName() != "APIHandler" || routes["*"].GetClassName() != "WebHandler" {
+		t.Errorf("Routes = %v", routes)
+	}
+	if cell := spec.Fields["Grid"].GetMap2Struct().GetMap2Fields()["r1"].GetMapFields()["k1"]; cell.GetClassName() != "Cell" {
+		t.Errorf("Grid = %v", spec.Fields["Grid"])
+	}
+
+	stripped, err := YAMLStruct("Config", `properties: {A: {className: X, serviceName: s}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stripped.Fields["A"].GetSingleStruct().GetServiceName() != "" {
+		t.Error("YAMLStruct should strip service names")
+	}
+}
+
+func TestStruct_MarshalYAML_Cyclic(t *testing.T) {
+	tree := newCyclicTree()
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `className: Tree
+properties:
+    Index:
+        additionalProperties: &Leaf
+            className: Leaf
+            serviceName: leafService
+    Root:
+        $ref: '#/definitions/Node'
+definitions:
+    Node:
+        className: Node
+        properties:
+            Children:
+                items:
+                    $ref: '#/definitions/Node'
+            Leaf: *Leaf
+            Owner:
+                $ref: '#'
+`
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+
+	var restored Struct
+	if err := yaml.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !sameGraph(tree, &restored) {
+		t.Error("cyclic Struct did not round-trip")
+	}
+	node := restored.Fields["Root"].GetSingleStruct()
+	if node.Fields["Owner"].GetSingleStruct() != &restored {
+		t.Error("reference to the root should point to the receiver")
+	}
+	if node.Fields["Leaf"].GetSingleStruct() != restored.Fields["Index"].GetMapStruct().MapFields["*"] {
+		t.Error("alias should point to the same *Struct")
+	}
+}
+
+func TestStruct_MarshalYAML_RoundTrip(t *testing.T) {
+	spec, err := NewStruct("Config", map[string]any{
+		"Single":   "Circle",
+		"List":     []string{"HTTPServer", "GRPCServer"},
+		"OneList":  []string{"HTTPServer"},
+		"Fallback": map[string]string{"api": "APIHandler", "*": "WebHandler"},
+		"EmptyMap": map[string]string{},
+		"Grid":     map[[2]string]string{{"r1", "k1"}: "Cell", {"*", "*"}: "Cell"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Struct
+	if err := yaml.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(spec, &restored) {
+		t.Errorf("Struct did not round-trip:\n%s\ngot  %v\nwant %v", data, &restored, spec)
+	}
+
+	shapes := &Struct{ClassName: "Drawing", Fields: map[string]*Value{
+		"Main": {Kind: &Value_SingleStruct{SingleStruct: &Struct{
+			Discriminator: "kind",
+			Choices: map[string]*Struct{
+				"circle": {ClassName: "Circle"},
+				"square": {ClassName: "Square", ServiceName: "squareService"},
+			},
+		}}},
+	}}
+	data, err = yaml.Marshal(shapes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restoredShapes Struct
+	if err := yaml.Unmarshal(data, &restoredShapes); err != nil {
+		t.Fatal(err)
+	}
+	if !sameGraph(shapes, &restoredShapes) {
+		t.Errorf("choices did not round-trip:\n%s\ngot %v", data, &restoredShapes)
+	}
+}
+
+func TestValue_MarshalYAML(t *testing.T) {
+	shared := &Struct{ClassName: "Circle"}
+	original := &Value{Kind: &Value_ListStruct{ListStruct: &ListStruct{ListFields: []*Struct{shared, shared}}}}
+	data, err := yaml.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "prefixItems:\n    - &Circle\n      className: Circle\n    - *Circle\n"
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+
+	var restored Value
+	if err := yaml.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	list := restored.GetListStruct().GetListFields()
+	if len(list) != 2 || list[0].GetClassName() != "Circle" || list[0] != list[1] {
+		t.Errorf("Value did not round-trip: %v", &restored)
+	}
+}
+
+func TestYAMLServiceStruct_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			name: "syntax",
+			doc:  "properties:\n  A: [",
+			want: "failed to parse YAML: yaml: line 2:",
+		},
+		{
+			name: "not a schema",
+			doc:  "properties:\n  A: circle\n",
+			want: "yaml: line 2, column 6: schema must be a mapping or a boolean",
+		},
+		{
+			name: "wrong keyword type",
+			doc:  "properties:\n  A:\n    x-map2: yes please\n",
+			want: "yaml: line 3, column 13: x-map2 must be a boolean",
+		},
+		{
+			name: "unresolved ref",
+			doc:  "properties:\n  A:\n    items:\n      $ref: '#/definitions/Missing'\n",
+			want: `yaml: line 4, column 7: in property "A": in items: cannot resolve $ref "#/definitions/Missing"`,
+		},
+		{
+			name: "merge key",
+			doc:  "definitions:\n  Base: &base {className: A}\nproperties:\n  A:\n    <<: *base\n",
+			want: "yaml: line 5, column 5: merge keys are not supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := YAMLServiceStruct("Config", tt.doc)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+}